@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	releaseAll bool
+)
+
+type channelInfo struct {
+	ID              string `json:"id"`
+	Enabled         bool   `json:"enabled"`
+	CompressionType string `json:"compression_type"`
+}
+
+func channelsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "channels",
+		Short: "Inspect and release doorbell audio channels",
+		Long: `Inspect the doorbell's two-way audio channels and release stuck ones.
+Releasing a channel closes it on the device without tearing down WebRTC sessions.`,
+	}
+
+	listCmd := &cobra.Command{
+		Use:     "list",
+		Short:   "List audio channels",
+		Example: `  doorbell-cli channels list`,
+		RunE:    runChannelsList,
+	}
+
+	releaseCmd := &cobra.Command{
+		Use:   "release [channel-id]",
+		Short: "Release an audio channel",
+		Example: `  doorbell-cli channels release 1
+  doorbell-cli channels release --all`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if releaseAll && len(args) > 0 {
+				return fmt.Errorf("cannot combine --all with a channel ID")
+			}
+			if !releaseAll && len(args) != 1 {
+				return fmt.Errorf("specify a channel ID or --all")
+			}
+			return nil
+		},
+		RunE: runChannelsRelease,
+	}
+	releaseCmd.Flags().BoolVar(&releaseAll, "all", false, "Release every open channel")
+
+	cmd.AddCommand(listCmd)
+	cmd.AddCommand(releaseCmd)
+
+	return cmd
+}
+
+func runChannelsList(cmd *cobra.Command, args []string) error {
+	channels, err := listChannels(cmd.Context(), serverAddr)
+	if err != nil {
+		return err
+	}
+
+	for _, ch := range channels {
+		state := "closed"
+		if ch.Enabled {
+			state = "open"
+		}
+		fmt.Printf("%s\t%s\t%s\n", ch.ID, state, ch.CompressionType)
+	}
+	return nil
+}
+
+func runChannelsRelease(cmd *cobra.Command, args []string) error {
+	if !releaseAll {
+		if err := releaseChannel(cmd.Context(), serverAddr, args[0]); err != nil {
+			return err
+		}
+		log.Printf("Released channel %s", args[0])
+		return nil
+	}
+
+	channels, err := listChannels(cmd.Context(), serverAddr)
+	if err != nil {
+		return err
+	}
+
+	released := 0
+	var failed []string
+	for _, ch := range channels {
+		if !ch.Enabled {
+			continue
+		}
+		if err := releaseChannel(cmd.Context(), serverAddr, ch.ID); err != nil {
+			log.Printf("Failed to release channel %s: %v", ch.ID, err)
+			failed = append(failed, ch.ID)
+			continue
+		}
+		log.Printf("Released channel %s", ch.ID)
+		released++
+	}
+
+	log.Printf("Released %d channel(s)", released)
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to release channel(s): %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func listChannels(ctx context.Context, serverAddr string) ([]channelInfo, error) {
+	url := strings.TrimSuffix(serverAddr, "/") + "/api/channels"
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var channels []channelInfo
+	if err := json.NewDecoder(resp.Body).Decode(&channels); err != nil {
+		return nil, fmt.Errorf("failed to decode channels: %w", err)
+	}
+	return channels, nil
+}
+
+func releaseChannel(ctx context.Context, serverAddr, channelID string) error {
+	endpoint := strings.TrimSuffix(serverAddr, "/") + "/api/channels/" + url.PathEscape(channelID) + "/release"
+
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("server returned status %d: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
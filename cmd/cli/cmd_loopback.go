@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/acardace/hikvision-doorbell-server/internal/audio"
+	"github.com/spf13/cobra"
+)
+
+var (
+	loopbackFreq     float64
+	loopbackDuration float64
+	loopbackWait     time.Duration
+	loopbackLeadIn   time.Duration
+	loopbackCodec    string
+)
+
+// loopbackMinThreshold is the minimum RMS level treated as the returning tone
+const loopbackMinThreshold = 0.05
+
+func loopbackCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "loopback",
+		Short: "Check that audio played on the doorbell comes back through its microphone",
+		Long: `Run an end-to-end audio check: listen to the doorbell microphone, play a test tone
+on its speaker through the play-file path, and report whether the tone was heard and
+how long it took to come back.
+
+The doorbell's microphone must be able to hear its own speaker, and the device needs a
+free channel for each direction. The reported delay includes the time the server takes
+to open the playback channel, so treat it as an upper bound.`,
+		Example: `  doorbell-cli loopback
+  doorbell-cli loopback --freq 440 --wait 10s`,
+		RunE: runLoopback,
+	}
+
+	cmd.Flags().Float64Var(&loopbackFreq, "freq", 1000, "Test tone frequency in Hz")
+	cmd.Flags().Float64Var(&loopbackDuration, "duration", 0.5, "Test tone duration in seconds")
+	cmd.Flags().DurationVar(&loopbackWait, "wait", 5*time.Second, "How long to listen for the tone after sending it")
+	cmd.Flags().DurationVar(&loopbackLeadIn, "lead-in", time.Second, "How long to measure the noise floor before sending the tone")
+	cmd.Flags().StringVar(&loopbackCodec, "codec", audio.DefaultCodec, "Codec of the uploaded tone, must match the server's play-file codec")
+
+	return cmd
+}
+
+// loopbackFrame is one packet of microphone audio, timestamped on arrival
+type loopbackFrame struct {
+	at  time.Time
+	rms float64
+}
+
+func runLoopback(cmd *cobra.Command, args []string) error {
+	toneCodec, ok := audio.LookupCodec(loopbackCodec)
+	if !ok {
+		return fmt.Errorf("unsupported codec: %s", loopbackCodec)
+	}
+	if loopbackDuration <= 0 {
+		return fmt.Errorf("duration must be positive")
+	}
+	if loopbackWait <= 0 {
+		return fmt.Errorf("wait must be positive")
+	}
+
+	tone, err := audio.GenerateTone(toneCodec, loopbackFreq, time.Duration(loopbackDuration*float64(time.Second)), 0.5)
+	if err != nil {
+		return fmt.Errorf("failed to generate tone: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(cmd.Context())
+	defer cancel()
+
+	// Start listening first so the tone can't be missed
+	log.Println("Listening to the doorbell microphone...")
+	body, rms, err := openListenStream(ctx, serverAddr)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	frames := make(chan loopbackFrame, 256)
+	go func() {
+		defer close(frames)
+		buf := make([]byte, audio.SampleSize)
+		for {
+			if _, err := io.ReadFull(body, buf); err != nil {
+				return
+			}
+			select {
+			case frames <- loopbackFrame{at: time.Now(), rms: rms(buf)}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Measure the noise floor before making any sound
+	var noise float64
+	leadInDone := time.After(loopbackLeadIn)
+waitLeadIn:
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case f, ok := <-frames:
+			if !ok {
+				return fmt.Errorf("listen stream ended before the test tone was sent")
+			}
+			noise = math.Max(noise, f.rms)
+		case <-leadInDone:
+			break waitLeadIn
+		}
+	}
+
+	threshold := math.Max(loopbackMinThreshold, noise*4)
+	log.Printf("Playing %.0f Hz test tone (noise floor %s)...", loopbackFreq, formatDBFS(noise))
+
+	sentAt := time.Now()
+	uploadErr := make(chan error, 1)
+	go func() {
+		uploadErr <- uploadAudioFile(ctx, serverAddr, tone)
+	}()
+
+	var peak float64
+	var detectedAt time.Time
+	deadline := time.After(loopbackWait)
+listen:
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-uploadErr:
+			if err != nil {
+				return fmt.Errorf("failed to play test tone: %w", err)
+			}
+			uploadErr = nil // Playback finished; keep listening until the deadline
+		case f, ok := <-frames:
+			if !ok {
+				break listen
+			}
+			peak = math.Max(peak, f.rms)
+			if f.rms >= threshold {
+				detectedAt = f.at
+				break listen
+			}
+		case <-deadline:
+			break listen
+		}
+	}
+
+	if detectedAt.IsZero() {
+		fmt.Printf("Audio detected: no (peak %s, noise floor %s)\n", formatDBFS(peak), formatDBFS(noise))
+		return fmt.Errorf("test tone was not heard within %s", loopbackWait)
+	}
+
+	fmt.Printf("Audio detected: yes\n")
+	fmt.Printf("Round-trip delay: %d ms\n", detectedAt.Sub(sentAt).Milliseconds())
+	fmt.Printf("Level: %s (noise floor %s)\n", formatDBFS(peak), formatDBFS(noise))
+	return nil
+}
+
+// openListenStream starts streaming raw microphone audio from the server and returns the
+// response body along with a level meter for the stream's codec
+func openListenStream(ctx context.Context, serverAddr string) (io.ReadCloser, func([]byte) float64, error) {
+	url := strings.TrimSuffix(serverAddr, "/") + "/api/audio/listen"
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, nil, fmt.Errorf("server returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	codec, ok := audio.LookupRTPCodec(strings.TrimPrefix(contentType, "audio/"))
+	if !ok {
+		resp.Body.Close()
+		return nil, nil, fmt.Errorf("unsupported listen stream format: %s", contentType)
+	}
+
+	rms := audio.MulawRMS
+	if codec.Name == "G.711alaw" {
+		rms = audio.AlawRMS
+	}
+	return resp.Body, rms, nil
+}
+
+// formatDBFS renders a normalized level in dBFS
+func formatDBFS(level float64) string {
+	dbfs := audio.LevelToDBFS(level)
+	if math.IsInf(dbfs, -1) {
+		return "silence"
+	}
+	return fmt.Sprintf("%.1f dBFS", dbfs)
+}
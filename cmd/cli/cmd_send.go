@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"log"
@@ -9,13 +10,18 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 
+	"github.com/acardace/hikvision-doorbell-server/internal/audio"
+	"github.com/acardace/hikvision-doorbell-server/internal/audio/transcode"
 	"github.com/spf13/cobra"
 )
 
 var (
-	audioFile string
+	audioFile       string
+	sendCodec       string
+	resampleQuality string
 )
 
 func sendCommand() *cobra.Command {
@@ -24,15 +30,22 @@ func sendCommand() *cobra.Command {
 		Short: "Send audio file to doorbell",
 		Long: `Send an audio file to the doorbell speaker. The CLI will automatically
 convert the audio to G.711 µ-law format using ffmpeg and upload it to the server.
+Use --codec to match the server's play_file codec if the doorbell uses another format,
+and --resample-quality to trade conversion speed for fewer aliasing artifacts.
 The server handles session management automatically.`,
 		Example: `  doorbell-cli send -f message.mp3
   doorbell-cli send --file announcement.wav
-  doorbell-cli send -f alert.m4a -s http://192.168.1.100:8080`,
+  doorbell-cli send -f alert.m4a -s http://192.168.1.100:8080
+  doorbell-cli send -f message.mp3 --codec G.711alaw
+  doorbell-cli send -f music.flac --resample-quality high`,
 		RunE: runSend,
 	}
 
 	cmd.Flags().StringVarP(&audioFile, "file", "f", "", "Audio file to send (required)")
 	cmd.MarkFlagRequired("file")
+	cmd.Flags().StringVar(&sendCodec, "codec", audio.DefaultCodec, "Target codec (G.711ulaw, G.711alaw)")
+	cmd.Flags().StringVar(&resampleQuality, "resample-quality", transcode.DefaultResampleQuality,
+		"Resampler preset ("+strings.Join(transcode.ResampleQualities(), ", ")+"); soxr needs ffmpeg built with libsoxr")
 
 	return cmd
 }
@@ -43,14 +56,24 @@ func runSend(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("audio file not found: %s", audioFile)
 	}
 
+	codec, ok := audio.LookupCodec(sendCodec)
+	if !ok {
+		return fmt.Errorf("unsupported codec: %s", sendCodec)
+	}
+
+	resample, err := transcode.ResampleFilter(codec.SampleRate, resampleQuality)
+	if err != nil {
+		return err
+	}
+
 	// Check if ffmpeg is available
 	if _, err := exec.LookPath("ffmpeg"); err != nil {
 		return fmt.Errorf("ffmpeg not found in PATH. Please install ffmpeg")
 	}
 
-	// Convert audio file to G.711 µ-law
-	log.Println("Converting audio file to G.711 µ-law...")
-	convertedData, err := convertToG711u(audioFile)
+	// Convert audio file to the target codec
+	log.Printf("Converting audio file to %s...", codec.Name)
+	convertedData, err := convertAudio(cmd.Context(), audioFile, codec, resample)
 	if err != nil {
 		return fmt.Errorf("failed to convert audio: %w", err)
 	}
@@ -59,7 +82,7 @@ func runSend(cmd *cobra.Command, args []string) error {
 
 	// Upload to server
 	log.Println("Uploading audio file to server...")
-	if err := uploadAudioFile(serverAddr, convertedData); err != nil {
+	if err := uploadAudioFile(cmd.Context(), serverAddr, convertedData); err != nil {
 		return fmt.Errorf("failed to upload audio: %w", err)
 	}
 
@@ -67,18 +90,19 @@ func runSend(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
-func convertToG711u(inputFile string) ([]byte, error) {
-	// Build ffmpeg command to convert to G.711 µ-law
+func convertAudio(ctx context.Context, inputFile string, codec audio.Codec, resample string) ([]byte, error) {
+	// Build ffmpeg command to convert to the target codec
 	args := []string{
 		"-i", inputFile,
-		"-ar", "8000", // Sample rate: 8000 Hz
+		"-af", resample, // Resampler filter for the chosen quality
+		"-ar", strconv.Itoa(codec.SampleRate), // Sample rate of the target codec
 		"-ac", "1", // Channels: mono
-		"-acodec", "pcm_mulaw",
-		"-f", "mulaw",
+		"-acodec", codec.FFmpegCodec,
+		"-f", codec.FFmpegFormat,
 		"-", // Output to stdout
 	}
 
-	ffmpegCmd := exec.Command("ffmpeg", args...)
+	ffmpegCmd := exec.CommandContext(ctx, "ffmpeg", args...)
 
 	var stdout, stderr bytes.Buffer
 	ffmpegCmd.Stdout = &stdout
@@ -91,7 +115,7 @@ func convertToG711u(inputFile string) ([]byte, error) {
 	return stdout.Bytes(), nil
 }
 
-func uploadAudioFile(serverAddr string, audioData []byte) error {
+func uploadAudioFile(ctx context.Context, serverAddr string, audioData []byte) error {
 	// Create multipart form data
 	body := &bytes.Buffer{}
 	writer := multipart.NewWriter(body)
@@ -111,7 +135,7 @@ func uploadAudioFile(serverAddr string, audioData []byte) error {
 
 	// Send POST request
 	url := strings.TrimSuffix(serverAddr, "/") + "/api/audio/play-file"
-	req, err := http.NewRequest("POST", url, body)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, body)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -23,6 +24,9 @@ import (
 var (
 	speakDuration int
 	inputDevice   string
+	highpassFreq  int
+	denoise       bool
+	audioFilter   string
 )
 
 func speakCommand() *cobra.Command {
@@ -34,17 +38,24 @@ Uses ffmpeg to capture audio from your system's default microphone or a specifie
 		Example: `  doorbell-cli speak
   doorbell-cli speak -d 30
   doorbell-cli speak --device "hw:0"
+  doorbell-cli speak --highpass 200 --denoise
+  doorbell-cli speak --filter "lowpass=f=3400"
   doorbell-cli speak -s http://192.168.1.100:8080`,
 		RunE: runSpeak,
 	}
 
 	cmd.Flags().IntVarP(&speakDuration, "duration", "d", 0, "Duration in seconds (0 = until Ctrl+C)")
 	cmd.Flags().StringVarP(&inputDevice, "device", "i", "default", "Input device (default, hw:0, etc.)")
+	cmd.Flags().IntVar(&highpassFreq, "highpass", 0, "High-pass filter cutoff in Hz to reduce rumble and feedback (0 = off)")
+	cmd.Flags().BoolVar(&denoise, "denoise", false, "Apply ffmpeg afftdn noise reduction")
+	cmd.Flags().StringVar(&audioFilter, "filter", "", "Raw ffmpeg audio filter chain appended after --highpass/--denoise")
 
 	return cmd
 }
 
 func runSpeak(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
 	// Check if ffmpeg is available
 	if _, err := exec.LookPath("ffmpeg"); err != nil {
 		return fmt.Errorf("ffmpeg not found in PATH. Please install ffmpeg")
@@ -104,11 +115,15 @@ func runSpeak(cmd *cobra.Command, args []string) error {
 
 	// Wait for ICE gathering to complete
 	log.Println("Gathering ICE candidates...")
-	<-gatherComplete
+	select {
+	case <-gatherComplete:
+	case <-ctx.Done():
+		return fmt.Errorf("gathering ICE candidates: %w", ctx.Err())
+	}
 
 	// Send offer to server (now with all ICE candidates)
 	log.Println("Connecting to server...")
-	answer, err := sendOffer(serverAddr, *peerConnection.LocalDescription())
+	answer, err := sendOffer(ctx, serverAddr, *peerConnection.LocalDescription())
 	if err != nil {
 		return fmt.Errorf("failed to send offer: %w", err)
 	}
@@ -189,36 +204,45 @@ func runSpeak(cmd *cobra.Command, args []string) error {
 		log.Println("ICE connection established")
 	case <-time.After(10 * time.Second):
 		return fmt.Errorf("timeout waiting for ICE connection")
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for ICE connection: %w", ctx.Err())
 	}
 
 	// Start ffmpeg to capture microphone input
 	ffmpegArgs := []string{
+		"-hide_banner", "-nostats", // Keep stderr to diagnostics
 		"-f", "alsa", // Linux audio input
 		"-i", inputDevice, // Input device
+	}
+	if filters := captureFilters(); filters != "" {
+		log.Printf("Applying audio filters: %s", filters)
+		ffmpegArgs = append(ffmpegArgs, "-af", filters)
+	}
+	ffmpegArgs = append(ffmpegArgs,
 		"-sample_rate", fmt.Sprintf("%d", audio.SampleRate),
 		"-ch_layout", "mono", // Channels: mono
 		"-f", "mulaw", // Output format: G.711 µ-law
 		"-", // Output to stdout
-	}
+	)
 
 	log.Printf("Starting microphone capture (device: %s, format: G.711µ-law, %dHz, mono)", inputDevice, audio.SampleRate)
-	ffmpegCmd := exec.Command("ffmpeg", ffmpegArgs...)
+	ffmpegCmd := exec.CommandContext(ctx, "ffmpeg", ffmpegArgs...)
 
 	ffmpegStdout, err := ffmpegCmd.StdoutPipe()
 	if err != nil {
 		return fmt.Errorf("failed to create ffmpeg stdout pipe: %w", err)
 	}
 
+	// Captured so a failing microphone can be diagnosed
+	var ffmpegStderr bytes.Buffer
+	ffmpegCmd.Stderr = &ffmpegStderr
+
 	if err := ffmpegCmd.Start(); err != nil {
 		return fmt.Errorf("failed to start ffmpeg: %w", err)
 	}
 
-	// Ensure ffmpeg and ffplay are killed on exit
+	// Ensure ffplay is killed on exit
 	defer func() {
-		if ffmpegCmd != nil && ffmpegCmd.Process != nil {
-			ffmpegCmd.Process.Kill()
-			ffmpegCmd.Wait()
-		}
 		if ffplayCmd != nil && ffplayCmd.Process != nil {
 			ffplayCmd.Process.Kill()
 			ffplayCmd.Wait()
@@ -239,29 +263,28 @@ func runSpeak(cmd *cobra.Command, args []string) error {
 
 	// Read audio from ffmpeg and send via WebRTC
 	done := make(chan error, 1)
+	captureExited := make(chan struct{})
 	totalBytes := 0
 
 	go func() {
+		defer close(captureExited)
+
+		// ffmpeg's pipe returns whatever it has buffered, so fill whole frames before
+		// sending and time each sample by the bytes it actually carries
+		codec, _ := audio.LookupCodec(audio.DefaultCodec)
 		buffer := make([]byte, audio.SampleSize)
 		for {
-			n, err := ffmpegStdout.Read(buffer)
-			if err != nil {
-				if err != io.EOF {
-					done <- err
-				} else {
-					done <- nil
-				}
-				return
-			}
-
+			n, err := io.ReadFull(ffmpegStdout, buffer)
 			if n > 0 {
 				totalBytes += n
 
 				// Send via WebRTC track
 				if err := audioTrack.WriteSample(media.Sample{
 					Data:     buffer[:n],
-					Duration: audio.SampleDuration,
+					Duration: codec.Duration(n),
 				}); err != nil {
+					ffmpegCmd.Process.Kill()
+					ffmpegCmd.Wait()
 					done <- fmt.Errorf("failed to send audio sample: %w", err)
 					return
 				}
@@ -271,26 +294,68 @@ func runSpeak(cmd *cobra.Command, args []string) error {
 					log.Printf("Sent: %.2f MB", float64(totalBytes)/(1024*1024))
 				}
 			}
+
+			if err != nil {
+				if err != io.EOF && err != io.ErrUnexpectedEOF {
+					ffmpegCmd.Process.Kill()
+					ffmpegCmd.Wait()
+					done <- err
+					return
+				}
+
+				// Output ended: tell a crashed capture (e.g. mic unplugged) from a clean exit
+				if err := ffmpegCmd.Wait(); err != nil {
+					done <- fmt.Errorf("ffmpeg capture exited unexpectedly: %w\nStderr: %s",
+						err, strings.TrimSpace(ffmpegStderr.String()))
+				} else {
+					done <- nil
+				}
+				return
+			}
 		}
 	}()
 
+	// Ensure ffmpeg is killed on exit; the reader goroutine reaps it
+	defer func() {
+		ffmpegCmd.Process.Kill()
+		<-captureExited
+	}()
+
 	// Wait for completion or interrupt
 	select {
 	case <-sigChan:
 		log.Println("\nReceived interrupt signal, stopping...")
 	case <-timeoutChan:
 		log.Println("\nDuration reached")
+	case <-ctx.Done():
+		return fmt.Errorf("speaking: %w", ctx.Err())
 	case err := <-done:
 		if err != nil {
 			return fmt.Errorf("error during speaking: %w", err)
 		}
+		log.Println("\nMicrophone capture ended")
 	}
 
 	log.Printf("Complete! Total bytes sent: %d (%.2f MB)", totalBytes, float64(totalBytes)/(1024*1024))
 	return nil
 }
 
-func sendOffer(serverAddr string, offer webrtc.SessionDescription) (*webrtc.SessionDescription, error) {
+// captureFilters builds the ffmpeg -af chain from the --highpass, --denoise and --filter flags
+func captureFilters() string {
+	var filters []string
+	if highpassFreq > 0 {
+		filters = append(filters, fmt.Sprintf("highpass=f=%d", highpassFreq))
+	}
+	if denoise {
+		filters = append(filters, "afftdn")
+	}
+	if audioFilter != "" {
+		filters = append(filters, audioFilter)
+	}
+	return strings.Join(filters, ",")
+}
+
+func sendOffer(ctx context.Context, serverAddr string, offer webrtc.SessionDescription) (*webrtc.SessionDescription, error) {
 	url := strings.TrimSuffix(serverAddr, "/") + "/api/webrtc/offer"
 
 	offerJSON, err := json.Marshal(offer)
@@ -298,7 +363,13 @@ func sendOffer(serverAddr string, offer webrtc.SessionDescription) (*webrtc.Sess
 		return nil, fmt.Errorf("failed to marshal offer: %w", err)
 	}
 
-	resp, err := http.Post(url, "application/json", bytes.NewReader(offerJSON))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(offerJSON))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
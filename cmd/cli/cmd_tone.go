@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/acardace/hikvision-doorbell-server/internal/audio"
+	"github.com/spf13/cobra"
+)
+
+var (
+	toneFreq     float64
+	toneDuration float64
+	toneVolume   float64
+	toneCodec    string
+)
+
+func toneCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "tone",
+		Short: "Play a test tone on the doorbell",
+		Long: `Generate a sine test tone and play it on the doorbell speaker.
+Useful during installation to confirm the speaker works without preparing any audio files.
+The tone is generated locally (no ffmpeg required) and uploaded like 'send'.`,
+		Example: `  doorbell-cli tone
+  doorbell-cli tone --freq 440 --duration 2
+  doorbell-cli tone --codec G.711alaw`,
+		RunE: runTone,
+	}
+
+	cmd.Flags().Float64Var(&toneFreq, "freq", 1000, "Tone frequency in Hz")
+	cmd.Flags().Float64Var(&toneDuration, "duration", 1, "Tone duration in seconds")
+	cmd.Flags().Float64Var(&toneVolume, "volume", 0.5, "Tone amplitude as a fraction of full scale (0-1)")
+	cmd.Flags().StringVar(&toneCodec, "codec", audio.DefaultCodec, "Target codec (G.711ulaw, G.711alaw)")
+
+	return cmd
+}
+
+func runTone(cmd *cobra.Command, args []string) error {
+	codec, ok := audio.LookupCodec(toneCodec)
+	if !ok {
+		return fmt.Errorf("unsupported codec: %s", toneCodec)
+	}
+
+	if toneDuration <= 0 {
+		return fmt.Errorf("duration must be positive")
+	}
+	if toneVolume <= 0 || toneVolume > 1 {
+		return fmt.Errorf("volume must be between 0 and 1")
+	}
+
+	duration := time.Duration(toneDuration * float64(time.Second))
+	data, err := audio.GenerateTone(codec, toneFreq, duration, toneVolume)
+	if err != nil {
+		return fmt.Errorf("failed to generate tone: %w", err)
+	}
+
+	log.Printf("Playing %.0f Hz tone for %.1f seconds...", toneFreq, toneDuration)
+	if err := uploadAudioFile(cmd.Context(), serverAddr, data); err != nil {
+		return fmt.Errorf("failed to upload tone: %w", err)
+	}
+
+	log.Println("Tone played successfully!")
+	return nil
+}
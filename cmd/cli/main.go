@@ -1,14 +1,20 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
 var (
 	serverAddr string
+	timeout    time.Duration
+
+	// timeoutCtx carries the --timeout deadline; it is set before any command runs
+	timeoutCtx context.Context
 )
 
 func main() {
@@ -20,12 +26,34 @@ func main() {
 
 	// Global flags
 	rootCmd.PersistentFlags().StringVarP(&serverAddr, "server", "s", "http://localhost:8080", "Middleware server address")
+	rootCmd.PersistentFlags().DurationVarP(&timeout, "timeout", "t", 0, "Overall deadline for the command, e.g. 30s (0 = no limit)")
+
+	// Commands use cmd.Context(), which carries the --timeout deadline
+	var cancel context.CancelFunc = func() {}
+	rootCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+		timeoutCtx = cmd.Context()
+		if timeout > 0 {
+			timeoutCtx, cancel = context.WithTimeout(timeoutCtx, timeout)
+		}
+		cmd.SetContext(timeoutCtx)
+	}
 
 	// Add commands
 	rootCmd.AddCommand(sendCommand())
 	rootCmd.AddCommand(speakCommand())
+	rootCmd.AddCommand(channelsCommand())
+	rootCmd.AddCommand(toneCommand())
+	rootCmd.AddCommand(loopbackCommand())
 
-	if err := rootCmd.Execute(); err != nil {
+	err := rootCmd.ExecuteContext(context.Background())
+	timedOut := timeoutCtx != nil && timeoutCtx.Err() == context.DeadlineExceeded
+	cancel()
+
+	if timedOut {
+		fmt.Fprintf(os.Stderr, "Timed out after %s\n", timeout)
+		os.Exit(1)
+	}
+	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
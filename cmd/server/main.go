@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,12 +13,16 @@ import (
 	"time"
 
 	"github.com/acardace/hikvision-doorbell-server/internal/api"
+	"github.com/acardace/hikvision-doorbell-server/internal/audio"
+	"github.com/acardace/hikvision-doorbell-server/internal/audio/transcode"
 	"github.com/acardace/hikvision-doorbell-server/internal/config"
 	"github.com/acardace/hikvision-doorbell-server/internal/hikvision"
+	"github.com/acardace/hikvision-doorbell-server/internal/logger"
 )
 
 func main() {
 	configPath := flag.String("config", "config.yaml", "Path to configuration file")
+	logLevel := flag.String("log-level", "", "Log level (debug, info, warn, error), overrides the config file")
 	flag.Parse()
 
 	// Load configuration
@@ -26,16 +31,81 @@ func main() {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
+	if cfg.Log.File != "" {
+		if err := logger.SetFile(cfg.Log.File); err != nil {
+			log.Fatalf("Failed to open log file: %v", err)
+		}
+	}
+
+	if *logLevel != "" {
+		cfg.Log.Level = *logLevel
+	}
+	level, err := cfg.Log.SlogLevel()
+	if err != nil {
+		log.Fatalf("Invalid log level: %v", err)
+	}
+	logger.Configure(level, cfg.Log.Format == "json")
+
+	// The play-file codec and its fallbacks must be codecs this deployment allows
+	allowedCodecs := os.Getenv("ALLOWED_CODECS")
+	if allowedCodecs == "" {
+		allowedCodecs = audio.DefaultAllowedCodecs
+	}
+	for _, codec := range append([]string{cfg.PlayFile.Codec}, cfg.PlayFile.FallbackCodecs...) {
+		if err := checkPlayFileCodec(codec, allowedCodecs); err != nil {
+			log.Fatalf("Invalid codec configuration: %v", err)
+		}
+	}
+
+	// DEVICE=mock runs against a simulated doorbell instead of real hardware
+	if os.Getenv("DEVICE") == "mock" {
+		mockDevice, err := hikvision.StartMockDevice(1)
+		if err != nil {
+			log.Fatalf("Failed to start mock device: %v", err)
+		}
+		defer mockDevice.Close()
+
+		cfg.Hikvision.Host = mockDevice.Addr()
+		log.Printf("Using simulated doorbell at %s", cfg.Hikvision.Host)
+	}
+
 	// Create Hikvision client
 	hikClient := hikvision.NewClient(
 		cfg.Hikvision.Host,
 		cfg.Hikvision.Username,
 		cfg.Hikvision.Password,
 	)
+	hikClient.SetReaderStallTimeout(cfg.Hikvision.ReaderStallTimeout)
+	hikClient.SetReplayBuffer(cfg.Hikvision.ReplayBuffer)
+	hikClient.SetStreamSocketOptions(hikvision.StreamSocketOptions{
+		NoDelay:     cfg.Hikvision.StreamSocket.NoDelay,
+		WriteBuffer: cfg.Hikvision.StreamSocket.WriteBuffer,
+	})
+	hikClient.SetRetryPolicies(cfg.Hikvision.Retry.Stream, cfg.Hikvision.Retry.Request)
+	hikClient.SetRTSPPort(cfg.Hikvision.RTSPPort)
+	hikClient.SetCircuitBreaker(cfg.Hikvision.CircuitBreaker.Failures, cfg.Hikvision.CircuitBreaker.Cooldown)
+	hikClient.SetArmModeEndpoint(hikvision.ArmModeEndpoint{Path: cfg.Hikvision.ArmMode.Path, Element: cfg.Hikvision.ArmMode.Element})
+	if err := hikClient.SetProxy(cfg.Hikvision.Proxy); err != nil {
+		log.Fatalf("Invalid hikvision.proxy: %v", err)
+	}
+
+	audioDataParams, ok := hikvision.LookupAudioDataProfile(cfg.Hikvision.AudioData.Profile)
+	if !ok {
+		log.Fatalf("Unknown audio_data profile: %s", cfg.Hikvision.AudioData.Profile)
+	}
+	if v := cfg.Hikvision.AudioData.ReaderSessionID; v != nil {
+		audioDataParams.ReaderSessionID = *v
+	}
+	if v := cfg.Hikvision.AudioData.WriterSessionID; v != nil {
+		audioDataParams.WriterSessionID = *v
+	}
+	audioDataParams.ChannelID = cfg.Hikvision.AudioData.ChannelID
+	audioDataParams.SessionIDHeader = cfg.Hikvision.AudioData.SessionIDHeader
+	hikClient.SetAudioDataParams(audioDataParams)
 
 	// Test connection by getting channels
 	log.Println("Testing connection to Hikvision device...")
-	channelList, err := hikClient.GetTwoWayAudioChannels()
+	channelList, err := hikClient.GetTwoWayAudioChannels(context.Background())
 	if err != nil {
 		log.Fatalf("Failed to connect to Hikvision device: %v", err)
 	}
@@ -43,18 +113,55 @@ func main() {
 
 	for _, c := range channelList.Channels {
 		if c.Enabled == "true" {
-			if err := hikClient.CloseAudioChannel(c.ID); err != nil {
+			if err := hikClient.CloseAudioChannel(context.Background(), c.ID); err != nil {
 				log.Fatalf("Cannot re-initiliaze hikvision device")
 			}
 		}
 	}
 
+	// Detect which features this model supports; if it fails nothing is gated
+	probeCtx, probeCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	if _, err := hikClient.ProbeCapabilities(probeCtx); err != nil {
+		log.Printf("Warning: failed to probe device capabilities: %v", err)
+	}
+	probeCancel()
+
+	// Temp files for conversions go to the work directory (the rootfs may be read-only)
+	if err := transcode.SetWorkDir(cfg.Server.WorkDir); err != nil {
+		log.Fatalf("Invalid server.work_dir: %v", err)
+	}
+	if err := transcode.SetResampleQuality(cfg.PlayFile.ResampleQuality); err != nil {
+		log.Fatalf("Invalid play_file.resample_quality: %v", err)
+	}
+
 	// Create API handler
-	handler := api.NewHandler(hikClient)
+	handler := api.NewHandler(cfg, hikClient)
+	handler.SetLogLevelOverride(*logLevel)
 	router := handler.SetupRoutes()
 
+	if err := handler.StartAudioSocket(); err != nil {
+		log.Fatalf("Failed to listen on server.audio_socket: %v", err)
+	}
+
+	// Bind before serving so an unusable address fails startup with a clear error
+	addr := cfg.Server.ListenAddr()
+	var listeners []net.Listener
+	if !cfg.Server.DisableTCP {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			log.Fatalf("Failed to listen on %s: %v", addr, err)
+		}
+		listeners = append(listeners, listener)
+	}
+	if cfg.Server.UnixSocket != "" {
+		listener, err := listenUnixSocket(cfg.Server)
+		if err != nil {
+			log.Fatalf("Failed to listen on server.unix_socket: %v", err)
+		}
+		listeners = append(listeners, listener)
+	}
+
 	// Setup HTTP server
-	addr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
 	server := &http.Server{
 		Addr:    addr,
 		Handler: router,
@@ -64,29 +171,92 @@ func main() {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
+	// Reopen the log file on SIGHUP so external log rotation works
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
 	go func() {
-		log.Printf("Starting server on %s", addr)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server error: %v", err)
+		for range hupChan {
+			if err := logger.Reopen(); err != nil {
+				log.Printf("Failed to reopen log file: %v", err)
+				continue
+			}
+			log.Println("Log file reopened")
 		}
 	}()
 
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			log.Printf("Starting server on %s", listener.Addr())
+			if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Server error: %v", err)
+			}
+		}(listener)
+	}
+
 	// Wait for interrupt signal
 	<-sigChan
 	log.Println("\nShutdown signal received, cleaning up...")
 
-	// Close any active sessions
-	if err := handler.CloseAllSessions(); err != nil {
-		log.Printf("Warning: Error closing sessions: %v", err)
-	}
-
 	// Shutdown HTTP server with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	// Close active sessions and release channels, logging a summary
+	if _, err := handler.Shutdown(ctx); err != nil {
+		log.Printf("Warning: Error during shutdown cleanup: %v", err)
+	}
+
 	if err := server.Shutdown(ctx); err != nil {
 		log.Printf("Server shutdown error: %v", err)
 	}
 
+	// Stop any stream reader or writer still running and wait for it
+	if err := hikClient.Shutdown(ctx); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+
 	log.Println("Server stopped")
 }
+
+// checkPlayFileCodec verifies the play-file codec is in the ALLOWED_CODECS list
+func checkPlayFileCodec(playFileCodec, allowedCodecs string) error {
+	allowed, err := audio.ParseCodecList(allowedCodecs)
+	if err != nil {
+		return fmt.Errorf("ALLOWED_CODECS: %w", err)
+	}
+
+	for _, c := range allowed {
+		if c.Name == playFileCodec {
+			return nil
+		}
+	}
+	return fmt.Errorf("play_file codec %s is not in ALLOWED_CODECS (%s)", playFileCodec, allowedCodecs)
+}
+
+// listenUnixSocket listens on the configured Unix socket, replacing a socket file left
+// behind by a previous run. The file is removed again when the server shuts down.
+func listenUnixSocket(cfg config.ServerConfig) (net.Listener, error) {
+	perm, err := cfg.UnixSocketPerm()
+	if err != nil {
+		return nil, err
+	}
+
+	if info, err := os.Lstat(cfg.UnixSocket); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", cfg.UnixSocket)
+		}
+		if err := os.Remove(cfg.UnixSocket); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	listener, err := net.Listen("unix", cfg.UnixSocket)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(cfg.UnixSocket, perm); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+	return listener, nil
+}
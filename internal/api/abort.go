@@ -2,11 +2,18 @@ package api
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"sync"
 
+	"github.com/acardace/hikvision-doorbell-server/internal/logger"
 	"github.com/acardace/hikvision-doorbell-server/internal/session"
+	"github.com/gorilla/mux"
 )
 
 // OperationType represents the type of operation
@@ -15,13 +22,38 @@ type OperationType int
 const (
 	OperationTypePlayFile OperationType = iota
 	OperationTypeWebRTC
+	OperationTypeDiagnostic
+	OperationTypeListen
 )
 
+// String returns the operation type as used in logs
+func (t OperationType) String() string {
+	switch t {
+	case OperationTypePlayFile:
+		return "play_file"
+	case OperationTypeWebRTC:
+		return "webrtc"
+	case OperationTypeDiagnostic:
+		return "diagnostic"
+	case OperationTypeListen:
+		return "listen"
+	default:
+		return "unknown"
+	}
+}
+
 // Operation represents a tracked operation
 type Operation struct {
-	Type    OperationType
-	Cancel  context.CancelFunc
-	Cleanup *sync.WaitGroup // WaitGroup to track cleanup completion
+	Type      OperationType
+	SessionID string // Client-visible ID used to abort just this operation
+	Cancel    context.CancelFunc
+	Cleanup   *sync.WaitGroup // WaitGroup to track cleanup completion
+
+	// teardown stops the operation on a device error. It defaults to Cancel, which
+	// unwinds handlers whose deferred cleanup releases the channel and unregisters;
+	// operations that outlive their handler (WebRTC) replace it with their own cleanup.
+	teardown   func()
+	deviceOnce sync.Once
 }
 
 func (o *Operation) IsPlayFile() bool {
@@ -36,7 +68,20 @@ func (o *Operation) IsWebRTC() bool {
 type AbortManager struct {
 	mu             sync.Mutex
 	activeOps      []*Operation
+	queue          []*queuedOperation // Requests waiting for activeOps to empty, in FIFO order
 	sessionManager session.SessionManager
+	draining       bool // Reject new operations while letting active ones finish
+
+	abortMu   sync.Mutex // Guards abortCall
+	abortCall *abortCall // AbortAll in progress, joined by concurrent callers
+}
+
+// abortCall is a running AbortAll whose result is shared with every caller that
+// asked for one while it ran
+type abortCall struct {
+	done    chan struct{} // Closed once summary and err are set
+	summary AbortSummary
+	err     error
 }
 
 // NewAbortManager creates a new abort manager
@@ -47,36 +92,112 @@ func NewAbortManager(sessionManager session.SessionManager) *AbortManager {
 	}
 }
 
-// Register registers a new operation with a cancel function
-func (am *AbortManager) Register(opType OperationType, cancel context.CancelFunc) *Operation {
+// Register registers a new operation under sessionID with a cancel function
+func (am *AbortManager) Register(opType OperationType, sessionID string, cancel context.CancelFunc) *Operation {
 	am.mu.Lock()
 	defer am.mu.Unlock()
 
+	return am.registerLocked(opType, sessionID, cancel)
+}
+
+// registerLocked tracks a new operation. Caller must hold mu.
+func (am *AbortManager) registerLocked(opType OperationType, sessionID string, cancel context.CancelFunc) *Operation {
 	wg := &sync.WaitGroup{}
 	wg.Add(1) // Will be Done() when cleanup completes
 
 	op := &Operation{
-		Type:    opType,
-		Cancel:  cancel,
-		Cleanup: wg,
+		Type:      opType,
+		SessionID: sessionID,
+		Cancel:    cancel,
+		Cleanup:   wg,
+		teardown:  cancel,
 	}
 	am.activeOps = append(am.activeOps, op)
-	log.Printf("[AbortManager] Registered operation (type: %d)", opType)
+	log.Printf("[AbortManager] Registered operation (type: %d, session: %s)", opType, sessionID)
 	return op
 }
 
-// Unregister removes an operation from tracking
+// requestSessionID returns the session ID the client chose for this request (X-Session-ID
+// header or session_id query parameter), or a new random one, and echoes it back in the
+// X-Session-ID response header so the client can abort the operation later
+func requestSessionID(w http.ResponseWriter, r *http.Request) string {
+	id := r.Header.Get("X-Session-ID")
+	if id == "" {
+		id = r.URL.Query().Get("session_id")
+	}
+	if id == "" {
+		b := make([]byte, 8)
+		rand.Read(b)
+		id = hex.EncodeToString(b)
+	}
+
+	w.Header().Set("X-Session-ID", id)
+	return id
+}
+
+// SessionOperations returns the tracked operations registered under sessionID
+func (am *AbortManager) SessionOperations(sessionID string) []*Operation {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	var ops []*Operation
+	for _, op := range am.activeOps {
+		if op.SessionID == sessionID {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+// AbortOperation cancels a single operation and waits for its cleanup to complete.
+// Operations whose cleanup depends on more than their context (WebRTC sessions) must
+// be torn down by their owner instead.
+func (am *AbortManager) AbortOperation(op *Operation) {
+	am.mu.Lock()
+	am.removeLocked(op)
+	am.mu.Unlock()
+
+	log.Printf("[AbortManager] Cancelling operation (type: %d, session: %s)", op.Type, op.SessionID)
+	op.Cancel()
+	op.Cleanup.Wait()
+	log.Printf("[AbortManager] Operation for session %s cleaned up", op.SessionID)
+}
+
+// DeviceError is how every operation type reacts to an unrecoverable device or stream
+// error: it emits one structured event and tears the operation down, which releases
+// its channel and unregisters it. Repeated calls for the same operation are no-ops.
+func (am *AbortManager) DeviceError(op *Operation, channelID string, err error) {
+	op.deviceOnce.Do(func() {
+		logger.Log.Error("operation aborted on device error",
+			slog.String("component", "abort_manager"),
+			slog.String("operation", op.Type.String()),
+			slog.String("session_id", op.SessionID),
+			slog.String("channel_id", channelID),
+			slog.String("error", err.Error()))
+		op.teardown()
+	})
+}
+
+// Unregister removes an operation from tracking and lets the next queued request in
 func (am *AbortManager) Unregister(op *Operation) {
 	am.mu.Lock()
 	defer am.mu.Unlock()
 
+	if am.removeLocked(op) {
+		log.Printf("[AbortManager] Unregistered operation (type: %d)", op.Type)
+	}
+	am.admitNextLocked()
+}
+
+// removeLocked stops tracking op and reports whether it was tracked. Caller must hold mu.
+func (am *AbortManager) removeLocked(op *Operation) bool {
 	for i, activeOp := range am.activeOps {
 		if activeOp == op {
 			am.activeOps = append(am.activeOps[:i], am.activeOps[i+1:]...)
-			log.Printf("[AbortManager] Unregistered operation (type: %d)", op.Type)
-			return
+			return true
 		}
 	}
+	return false
 }
 
 // AbortPlayFileOperations cancels only play-file operations (not WebRTC)
@@ -118,6 +239,43 @@ func (am *AbortManager) HasActiveOperation() bool {
 	return len(am.activeOps) > 0
 }
 
+// IsIdle reports whether no operation is active or waiting in the queue
+func (am *AbortManager) IsIdle() bool {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	return len(am.activeOps) == 0 && len(am.queue) == 0
+}
+
+// ActiveOperationCount returns the number of tracked operations
+func (am *AbortManager) ActiveOperationCount() int {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	return len(am.activeOps)
+}
+
+// SetDraining enables or disables drain mode. Requests still queued when it is
+// enabled are turned away with ErrDraining, since they would start new work.
+func (am *AbortManager) SetDraining(draining bool) {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	am.draining = draining
+	log.Printf("[AbortManager] Drain mode set to %t (%d active operations)", draining, len(am.activeOps))
+	if draining {
+		am.rejectQueuedLocked(ErrDraining)
+	}
+}
+
+// IsDraining returns true if new operations should be rejected
+func (am *AbortManager) IsDraining() bool {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	return am.draining
+}
+
 // HasActiveWebRTC returns true if there's an active WebRTC session
 func (am *AbortManager) HasActiveWebRTC() bool {
 	am.mu.Lock()
@@ -131,11 +289,60 @@ func (am *AbortManager) HasActiveWebRTC() bool {
 	return false
 }
 
-// AbortAll cancels all active operations and closes all audio channels
-func (am *AbortManager) AbortAll(ctx context.Context) error {
+// AbortSummary counts what AbortAll cleaned up
+type AbortSummary struct {
+	OperationsAborted int // Tracked operations that were cancelled
+	ChannelsReleased  int // Open device channels that were closed
+	ChannelsFailed    int // Open device channels that could not be closed
+}
+
+// AbortAll cancels all active operations and closes all audio channels. Concurrent
+// calls coalesce: a call made while another is running waits for it and returns the
+// same result instead of sweeping the device again. The starting caller's ctx bounds
+// the wait for operations to clean up, so one that never finishes can't hold the
+// sweep (and every caller joining it) forever; the channels are released regardless,
+// even if that caller goes away, since others may be waiting on the result. ctx also
+// bounds how long each caller waits.
+func (am *AbortManager) AbortAll(ctx context.Context) (AbortSummary, error) {
+	am.abortMu.Lock()
+	call := am.abortCall
+	leader := call == nil
+	if leader {
+		call = &abortCall{done: make(chan struct{})}
+		am.abortCall = call
+	}
+	am.abortMu.Unlock()
+
+	if leader {
+		go func() {
+			summary, err := am.abortAll(ctx)
+
+			am.abortMu.Lock()
+			call.summary, call.err = summary, err
+			am.abortCall = nil
+			am.abortMu.Unlock()
+			close(call.done)
+		}()
+	} else {
+		log.Println("[AbortManager] Abort already in progress, waiting for its result")
+	}
+
+	select {
+	case <-call.done:
+		return call.summary, call.err
+	case <-ctx.Done():
+		return AbortSummary{}, ctx.Err()
+	}
+}
+
+// abortAll does the work of AbortAll. ctx bounds only the wait for cleanups.
+func (am *AbortManager) abortAll(ctx context.Context) (AbortSummary, error) {
+	var summary AbortSummary
+
 	am.mu.Lock()
 
 	log.Printf("[AbortManager] Aborting %d active operations", len(am.activeOps))
+	summary.OperationsAborted = len(am.activeOps)
 
 	// Collect all cleanup wait groups before clearing operations
 	waitGroups := make([]*sync.WaitGroup, 0, len(am.activeOps))
@@ -147,48 +354,90 @@ func (am *AbortManager) AbortAll(ctx context.Context) error {
 		waitGroups = append(waitGroups, op.Cleanup)
 	}
 
-	// Clear the slice
+	// Clear the slice, and cancel queued requests so none starts while channels are released
 	am.activeOps = make([]*Operation, 0)
+	for _, q := range am.queue {
+		q.cancel()
+	}
+	am.queue = nil
 	am.mu.Unlock()
 
 	// Wait for all operations to complete cleanup
 	log.Printf("[AbortManager] Waiting for %d operations to complete cleanup", len(waitGroups))
-	for _, wg := range waitGroups {
-		wg.Wait()
+	cleanupErr := waitCleanup(ctx, waitGroups)
+	if cleanupErr != nil {
+		log.Printf("[AbortManager] Gave up waiting for operations to clean up: %v", cleanupErr)
+	} else {
+		log.Printf("[AbortManager] All operations cleaned up")
 	}
-	log.Printf("[AbortManager] All operations cleaned up")
+
+	// Release the channels even if the caller gave up; operations still cleaning up
+	// find theirs already closed
+	ctx = context.WithoutCancel(ctx)
 
 	// List all channels and close any that are enabled (in use)
 	channels, err := am.sessionManager.ListChannels(ctx)
 	if err != nil {
 		log.Printf("[AbortManager] Failed to list channels: %v", err)
-		return err
+		return summary, err
 	}
 
-	closedCount := 0
 	for _, ch := range channels {
 		if ch.Enabled {
 			log.Printf("[AbortManager] Releasing active channel: %s", ch.ID)
 			if err := am.sessionManager.ReleaseChannel(ctx, ch.ID); err != nil {
 				log.Printf("[AbortManager] Failed to release channel %s: %v", ch.ID, err)
+				summary.ChannelsFailed++
 				// Continue closing other channels
 			} else {
-				closedCount++
+				summary.ChannelsReleased++
 			}
 		}
 	}
 
-	log.Printf("[AbortManager] Closed %d audio channels", closedCount)
-	return nil
+	log.Printf("[AbortManager] Closed %d audio channels", summary.ChannelsReleased)
+	return summary, cleanupErr
+}
+
+// waitCleanup waits for every cleanup to complete, or until ctx ends
+func waitCleanup(ctx context.Context, waitGroups []*sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		for _, wg := range waitGroups {
+			wg.Wait()
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("operations did not clean up: %w", ctx.Err())
+	}
 }
 
 // HandleAbort handles the abort endpoint
 func (h *Handler) HandleAbort(w http.ResponseWriter, r *http.Request) {
 	log.Println("[Abort] Received abort request - stopping all operations")
 
+	result := newOperationResult("/api/abort")
+	defer result.log()
+
+	// Skip the sweep when nothing is running and no channel is open, so clients that
+	// abort defensively don't cost the device a round of closes; force=true always sweeps
+	force, _ := strconv.ParseBool(r.URL.Query().Get("force"))
+	if !force && h.abortManager.IsIdle() && !h.anyChannelEnabled(r.Context()) {
+		log.Println("[Abort] Nothing to abort")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Nothing to abort"))
+		return
+	}
+
 	// Abort all tracked operations and close all channels
-	if err := h.abortManager.AbortAll(r.Context()); err != nil {
+	if _, err := h.abortManager.AbortAll(r.Context()); err != nil {
 		log.Printf("[Abort] Error during abort: %v", err)
+		result.fail(deviceErrorCategory(err), err)
 		http.Error(w, "Failed to abort all operations", http.StatusInternalServerError)
 		return
 	}
@@ -196,6 +445,7 @@ func (h *Handler) HandleAbort(w http.ResponseWriter, r *http.Request) {
 	// Close all WebRTC sessions
 	if err := h.CloseAllSessions(); err != nil {
 		log.Printf("[Abort] Error closing WebRTC sessions: %v", err)
+		result.fail(errCategoryInternal, err)
 		http.Error(w, "Failed to close all sessions", http.StatusInternalServerError)
 		return
 	}
@@ -204,3 +454,49 @@ func (h *Handler) HandleAbort(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("All operations aborted"))
 }
+
+// anyChannelEnabled reports whether any channel is open on the device, from the cached
+// channel list when it is fresh. A failed lookup counts as open so the sweep still runs.
+func (h *Handler) anyChannelEnabled(ctx context.Context) bool {
+	channels, err := h.sessionManager.ListChannels(ctx)
+	if err != nil {
+		return true
+	}
+	for _, ch := range channels {
+		if ch.Enabled {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleAbortSession aborts only the operations registered under the session ID in the
+// path, leaving other clients' operations running
+func (h *Handler) HandleAbortSession(w http.ResponseWriter, r *http.Request) {
+	sessionID := mux.Vars(r)["sessionID"]
+	log.Printf("[Abort] Received abort request for session %s", sessionID)
+
+	result := newOperationResult("/api/abort/{sessionID}")
+	defer result.log()
+
+	ops := h.abortManager.SessionOperations(sessionID)
+	queued := h.abortManager.CancelQueued(sessionID)
+	if len(ops) == 0 && queued == 0 {
+		log.Printf("[Abort] Session %s not found", sessionID)
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	for _, op := range ops {
+		if op.IsWebRTC() {
+			// Cancelling the context alone doesn't close the peer connection
+			h.webrtcHandler.CloseSession(op)
+			continue
+		}
+		h.abortManager.AbortOperation(op)
+	}
+
+	log.Printf("[Abort] Aborted %d operation(s) and %d queued request(s) of session %s", len(ops), queued, sessionID)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Session aborted"))
+}
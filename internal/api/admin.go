@@ -0,0 +1,174 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/acardace/hikvision-doorbell-server/internal/config"
+	"github.com/acardace/hikvision-doorbell-server/internal/logger"
+)
+
+// ReloadResult describes the outcome of a configuration reload
+type ReloadResult struct {
+	Applied         []string `json:"applied"`
+	RequiresRestart []string `json:"requires_restart"`
+}
+
+// DrainRequest toggles drain mode
+type DrainRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+// DrainStatus reports drain mode and whether in-progress operations have finished
+type DrainStatus struct {
+	Draining         bool `json:"draining"`
+	ActiveOperations int  `json:"active_operations"`
+	Drained          bool `json:"drained"`
+}
+
+// requireAdmin rejects requests that don't carry the configured admin bearer token
+func (h *Handler) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.cfgMu.RLock()
+		token := h.cfg.Server.AdminToken
+		h.cfgMu.RUnlock()
+
+		if token == "" {
+			log.Printf("[Admin] Rejected %s: admin API disabled (no admin_token configured)", r.URL.Path)
+			http.Error(w, "Admin API disabled", http.StatusForbidden)
+			return
+		}
+
+		provided := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			log.Printf("[Admin] Rejected %s: invalid token", r.URL.Path)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// HandleReload re-reads the configuration file and applies hot-reloadable settings.
+// Active sessions are not interrupted; settings that need a restart are reported back.
+func (h *Handler) HandleReload(w http.ResponseWriter, r *http.Request) {
+	log.Println("[Admin] Reloading configuration")
+
+	newCfg, err := config.Load(h.cfg.Path())
+	if err != nil {
+		log.Printf("[Admin] Failed to reload configuration: %v", err)
+		http.Error(w, "Failed to reload configuration: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result := h.applyConfig(newCfg)
+
+	// The WebRTC public IP comes from the environment/IP file, re-read it for new connections
+	if h.webrtcHandler.ReloadConfig() {
+		result.Applied = append(result.Applied, "webrtc.public_ip")
+	}
+
+	log.Printf("[Admin] Configuration reloaded (applied: %v, requires restart: %v)", result.Applied, result.RequiresRestart)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// applyConfig copies hot-reloadable settings from newCfg and lists changed settings that need a restart
+func (h *Handler) applyConfig(newCfg *config.Config) ReloadResult {
+	h.cfgMu.Lock()
+	defer h.cfgMu.Unlock()
+
+	result := ReloadResult{
+		Applied:         []string{},
+		RequiresRestart: []string{},
+	}
+
+	// Log level and format; a -log-level flag keeps its level
+	if h.logLevelOverride != "" {
+		newCfg.Log.Level = h.logLevelOverride
+	}
+	if level, err := newCfg.Log.SlogLevel(); err == nil {
+		logger.Configure(level, newCfg.Log.Format == "json")
+		h.cfg.Log.Level = newCfg.Log.Level
+		h.cfg.Log.Format = newCfg.Log.Format
+		if h.logLevelOverride == "" {
+			result.Applied = append(result.Applied, "log.level")
+		}
+		result.Applied = append(result.Applied, "log.format")
+	}
+
+	// CORS origins, admin token and recording directory
+	h.cfg.Server.CORSOrigins = newCfg.Server.CORSOrigins
+	h.cfg.Server.AdminToken = newCfg.Server.AdminToken
+	h.cfg.Server.RecordingDir = newCfg.Server.RecordingDir
+	result.Applied = append(result.Applied, "server.cors_origins", "server.admin_token", "server.recording_dir")
+
+	// Everything else is wired up at startup
+	restart := map[string]bool{
+		"server.host":                     h.cfg.Server.Host != newCfg.Server.Host,
+		"server.port":                     h.cfg.Server.Port != newCfg.Server.Port,
+		"server.max_body_bytes":           h.cfg.Server.MaxBodyBytes != newCfg.Server.MaxBodyBytes,
+		"server.play_file_max_body_bytes": h.cfg.Server.PlayFileMaxBodyBytes != newCfg.Server.PlayFileMaxBodyBytes,
+		"server.compression":              h.cfg.Server.Compression != newCfg.Server.Compression,
+		"server.work_dir":                 h.cfg.Server.WorkDir != newCfg.Server.WorkDir,
+		"server.audio_socket":             h.cfg.Server.AudioSocket != newCfg.Server.AudioSocket,
+		"server.unix_socket":              h.cfg.Server.UnixSocket != newCfg.Server.UnixSocket || h.cfg.Server.UnixSocketMode != newCfg.Server.UnixSocketMode,
+		"server.disable_tcp":              h.cfg.Server.DisableTCP != newCfg.Server.DisableTCP,
+		"hikvision":                       !reflect.DeepEqual(h.cfg.Hikvision, newCfg.Hikvision),
+		"play_file":                       !reflect.DeepEqual(h.cfg.PlayFile, newCfg.PlayFile),
+		"log.file":                        h.cfg.Log.File != newCfg.Log.File,
+		"webhook":                         !reflect.DeepEqual(h.cfg.Webhook, newCfg.Webhook),
+	}
+	for _, key := range []string{"server.host", "server.port", "server.max_body_bytes", "server.play_file_max_body_bytes", "server.compression", "server.work_dir", "server.audio_socket", "server.unix_socket", "server.disable_tcp", "hikvision", "play_file", "log.file", "webhook"} {
+		if restart[key] {
+			result.RequiresRestart = append(result.RequiresRestart, key)
+		}
+	}
+
+	return result
+}
+
+// SetLogLevelOverride records the -log-level flag so reloads don't replace it with
+// the file's log.level
+func (h *Handler) SetLogLevelOverride(level string) {
+	h.cfgMu.Lock()
+	defer h.cfgMu.Unlock()
+	h.logLevelOverride = level
+}
+
+// HandleDrain enables or disables drain mode. While draining, new offers and
+// play-file requests get 503 and /healthz reports not-ready; active sessions keep running.
+func (h *Handler) HandleDrain(w http.ResponseWriter, r *http.Request) {
+	var req DrainRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("[Admin] Invalid drain request: %v", err)
+		http.Error(w, "Invalid drain request", http.StatusBadRequest)
+		return
+	}
+
+	h.abortManager.SetDraining(req.Enabled)
+	h.writeDrainStatus(w)
+}
+
+// HandleDrainStatus reports drain mode and the number of operations still running
+func (h *Handler) HandleDrainStatus(w http.ResponseWriter, r *http.Request) {
+	h.writeDrainStatus(w)
+}
+
+func (h *Handler) writeDrainStatus(w http.ResponseWriter) {
+	draining := h.abortManager.IsDraining()
+	active := h.abortManager.ActiveOperationCount()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DrainStatus{
+		Draining:         draining,
+		ActiveOperations: active,
+		Drained:          draining && active == 0,
+	})
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/acardace/hikvision-doorbell-server/internal/hikvision"
+)
+
+// ArmModeRequest and ArmModeResponse carry the device's scene/arming mode (e.g. "atHome")
+type ArmModeRequest struct {
+	Mode string `json:"mode"`
+}
+
+type ArmModeResponse struct {
+	Mode string `json:"mode"`
+}
+
+// HandleGetArmMode reports the device's current scene/arming mode
+func (h *Handler) HandleGetArmMode(w http.ResponseWriter, r *http.Request) {
+	mode, err := h.hikClient.GetArmMode(r.Context())
+	if err != nil {
+		log.Printf("[ArmMode] Failed to get arm mode: %v", err)
+		writeArmModeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ArmModeResponse{Mode: mode})
+}
+
+// HandleSetArmMode changes the device's scene/arming mode. The mode names are the
+// device's own; invalid ones are rejected by the device.
+func (h *Handler) HandleSetArmMode(w http.ResponseWriter, r *http.Request) {
+	var req ArmModeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("[ArmMode] Invalid request: %v", err)
+		http.Error(w, "Invalid arm mode request", http.StatusBadRequest)
+		return
+	}
+	req.Mode = strings.TrimSpace(req.Mode)
+	if req.Mode == "" {
+		http.Error(w, "mode is required", http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("[ArmMode] Setting arm mode to %s", req.Mode)
+	if err := h.hikClient.SetArmMode(r.Context(), req.Mode); err != nil {
+		log.Printf("[ArmMode] Failed to set arm mode: %v", err)
+		writeArmModeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ArmModeResponse{Mode: req.Mode})
+}
+
+func writeArmModeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, hikvision.ErrArmModeUnsupported):
+		http.Error(w, "The doorbell does not support arm/scene mode control", http.StatusNotImplemented)
+	case errors.Is(err, hikvision.ErrCircuitOpen):
+		http.Error(w, "Doorbell unreachable, retrying later", http.StatusServiceUnavailable)
+	default:
+		http.Error(w, "Doorbell request failed: "+err.Error(), http.StatusBadGateway)
+	}
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/acardace/hikvision-doorbell-server/internal/audio/transcode"
+)
+
+// AudioDurationResponse describes how a play-file upload would be played
+type AudioDurationResponse struct {
+	DurationSeconds float64 `json:"duration_seconds"`
+	Bytes           int     `json:"bytes"` // Bytes that would be sent to the device
+	Codec           string  `json:"codec"`
+
+	// Conversion is set when the upload was converted on the server
+	Conversion *transcode.Report `json:"conversion,omitempty"`
+}
+
+// HandleAudioDuration reports the playback duration of an upload without playing it.
+// It accepts the same bodies as play-file and runs the same conversion, fades and
+// pre-roll, but never touches the device.
+func (h *Handler) HandleAudioDuration(w http.ResponseWriter, r *http.Request) {
+	cfg := &h.cfg.PlayFile
+
+	audioData, convertUpload, uploadErr := readPlayFileUpload(r, cfg)
+	if uploadErr != nil {
+		log.Printf("[AudioDuration] %v", uploadErr)
+		http.Error(w, uploadErr.message, uploadErr.status)
+		return
+	}
+
+	prepared, prepareErr := preparePlayFileAudio(r.Context(), audioData, convertUpload, h.convertCache, h.hikClient.Capabilities(), cfg)
+	if prepareErr != nil {
+		log.Printf("[AudioDuration] %v", prepareErr)
+		http.Error(w, prepareErr.message, prepareErr.status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AudioDurationResponse{
+		DurationSeconds: prepared.codec.Duration(len(prepared.data)).Seconds(),
+		Bytes:           len(prepared.data),
+		Codec:           prepared.codec.Name,
+		Conversion:      prepared.conversion,
+	})
+}
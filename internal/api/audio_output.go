@@ -0,0 +1,102 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/acardace/hikvision-doorbell-server/internal/hikvision"
+)
+
+// AudioOutputRequest selects the device audio output (speaker or line-out) audio is played through
+type AudioOutputRequest struct {
+	OutputID  string `json:"output_id"`
+	ChannelID string `json:"channel_id,omitempty"` // Empty routes every channel
+}
+
+// AudioOutputResponse reports the output a channel was routed to
+type AudioOutputResponse struct {
+	ChannelID string `json:"channel_id"`
+	OutputID  string `json:"output_id"`
+}
+
+// HandleSetAudioOutput routes one or all two-way audio channels to a device audio output.
+// The routing is stored on the device and applies to sessions opened afterwards.
+func (h *Handler) HandleSetAudioOutput(w http.ResponseWriter, r *http.Request) {
+	var req AudioOutputRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("[AudioOutput] Invalid request: %v", err)
+		http.Error(w, "Invalid audio output request", http.StatusBadRequest)
+		return
+	}
+	if req.OutputID == "" {
+		http.Error(w, "output_id is required", http.StatusBadRequest)
+		return
+	}
+
+	// Outputs are numbered from 1; reject ones beyond what the device reported at startup
+	if caps := h.hikClient.Capabilities(); caps != nil && caps.AudioOutputs > 0 {
+		if n, err := strconv.Atoi(req.OutputID); err == nil && (n < 1 || n > caps.AudioOutputs) {
+			http.Error(w, "Unknown output_id: the doorbell has "+strconv.Itoa(caps.AudioOutputs)+" audio output(s)", http.StatusBadRequest)
+			return
+		}
+	}
+
+	channels, err := h.sessionManager.ListChannels(r.Context())
+	if err != nil {
+		log.Printf("[AudioOutput] Failed to list channels: %v", err)
+		http.Error(w, "Failed to list channels", http.StatusBadGateway)
+		return
+	}
+
+	var channelIDs []string
+	for _, ch := range channels {
+		if req.ChannelID == "" || ch.ID == req.ChannelID {
+			channelIDs = append(channelIDs, ch.ID)
+		}
+	}
+	if len(channelIDs) == 0 {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+
+	resp := make([]AudioOutputResponse, 0, len(channelIDs))
+	for _, channelID := range channelIDs {
+		log.Printf("[AudioOutput] Routing channel %s to output %s", channelID, req.OutputID)
+		if err := h.sessionManager.SetAudioOutput(r.Context(), channelID, req.OutputID); err != nil {
+			switch {
+			case errors.Is(err, hikvision.ErrOutputRoutingUnsupported):
+				http.Error(w, "The doorbell does not support audio output routing", http.StatusNotImplemented)
+			case errors.Is(err, hikvision.ErrCircuitOpen):
+				http.Error(w, "Doorbell unreachable, retrying later", http.StatusServiceUnavailable)
+			default:
+				http.Error(w, "Failed to set audio output on channel "+channelID+": "+err.Error(), http.StatusBadGateway)
+			}
+			return
+		}
+		resp = append(resp, AudioOutputResponse{ChannelID: channelID, OutputID: req.OutputID})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// HandleGetAudioOutput reports the device audio output each two-way audio channel is routed to
+func (h *Handler) HandleGetAudioOutput(w http.ResponseWriter, r *http.Request) {
+	channels, err := h.sessionManager.ListChannels(r.Context())
+	if err != nil {
+		log.Printf("[AudioOutput] Failed to list channels: %v", err)
+		http.Error(w, "Failed to list channels", http.StatusBadGateway)
+		return
+	}
+
+	resp := make([]AudioOutputResponse, 0, len(channels))
+	for _, ch := range channels {
+		resp = append(resp, AudioOutputResponse{ChannelID: ch.ID, OutputID: ch.AudioOutputID})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
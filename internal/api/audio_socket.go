@@ -0,0 +1,249 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/acardace/hikvision-doorbell-server/internal/audio"
+	"github.com/acardace/hikvision-doorbell-server/internal/hikvision"
+	"github.com/acardace/hikvision-doorbell-server/internal/lifecycle"
+	"github.com/acardace/hikvision-doorbell-server/internal/session"
+)
+
+// audioSocketSessionID tags the socket's stream in the abort manager, so it can be
+// stopped with /api/abort/audio-socket
+const audioSocketSessionID = "audio-socket"
+
+// audioSocketWriteTimeout is how long a client may block a write before it is dropped,
+// so one stalled reader can't hold up the others
+const audioSocketWriteTimeout = time.Second
+
+// AudioSocket serves live doorbell audio on a Unix domain socket. The first client to
+// connect opens a listen-only channel; every connected client gets the same raw audio
+// in the channel's codec, and the channel is released when the last one disconnects.
+type AudioSocket struct {
+	path           string
+	hikClient      hikvision.DeviceClient
+	sessionManager session.SessionManager
+	abortManager   *AbortManager
+	listener       net.Listener
+	tasks          *lifecycle.Group
+
+	mu      sync.Mutex
+	clients map[net.Conn]struct{}
+	stream  *socketStream // Stream feeding the clients, kept until its channel is released
+}
+
+// socketStream is one acquisition of the channel, lasting while clients are connected
+type socketStream struct {
+	cancel   context.CancelFunc
+	stopping bool // The last client left and the channel is being released
+}
+
+// NewAudioSocket creates an audio socket that will listen on path once started
+func NewAudioSocket(path string, hikClient hikvision.DeviceClient, sessionManager session.SessionManager, abortManager *AbortManager) *AudioSocket {
+	return &AudioSocket{
+		path:           path,
+		hikClient:      hikClient,
+		sessionManager: sessionManager,
+		abortManager:   abortManager,
+		tasks:          lifecycle.New(),
+		clients:        make(map[net.Conn]struct{}),
+	}
+}
+
+// Start listens on the socket path, replacing a socket file left behind by a previous
+// run, and accepts clients in the background
+func (s *AudioSocket) Start() error {
+	if info, err := os.Lstat(s.path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("%s exists and is not a socket", s.path)
+		}
+		if err := os.Remove(s.path); err != nil {
+			return fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	listener, err := net.Listen("unix", s.path)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+
+	log.Printf("[AudioSocket] Serving doorbell audio on %s", s.path)
+	s.tasks.Go("audio socket accept", s.accept)
+	return nil
+}
+
+// Close stops accepting clients, disconnects the connected ones and waits for the
+// stream to release its channel, giving up when ctx ends
+func (s *AudioSocket) Close(ctx context.Context) error {
+	if s.listener != nil {
+		s.listener.Close()
+	}
+
+	s.mu.Lock()
+	for conn := range s.clients {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	return s.tasks.Stop(ctx)
+}
+
+// accept registers each client and starts a stream for the first one. A client that
+// connects while the previous stream is still releasing the channel is picked up by
+// a new stream once the release finishes.
+func (s *AudioSocket) accept(ctx context.Context) {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+				log.Printf("[AudioSocket] Accept failed: %v", err)
+			}
+			return
+		}
+
+		s.mu.Lock()
+		s.clients[conn] = struct{}{}
+		log.Printf("[AudioSocket] Client connected (%d connected)", len(s.clients))
+		if s.stream == nil {
+			s.startStreamLocked()
+		}
+		s.mu.Unlock()
+
+		// Clients only listen; reading tells when they hang up
+		s.tasks.Go("audio socket client", func(ctx context.Context) {
+			io.Copy(io.Discard, conn)
+			s.remove(conn)
+		})
+	}
+}
+
+// startStreamLocked starts feeding the clients from a new channel acquisition.
+// Caller must hold mu.
+func (s *AudioSocket) startStreamLocked() {
+	ctx, cancel := context.WithCancel(s.tasks.Context())
+	stream := &socketStream{cancel: cancel}
+	s.stream = stream
+
+	s.tasks.GoWithin(ctx, "audio socket stream", func(ctx context.Context) {
+		defer cancel()
+		s.run(ctx, cancel) // Returns once the channel is released
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.stream != stream {
+			return
+		}
+		s.stream = nil
+
+		// Clients that connected while the channel was being released get a new
+		// stream now that it is free
+		if stream.stopping {
+			if len(s.clients) > 0 && s.tasks.Context().Err() == nil {
+				s.startStreamLocked()
+			}
+			return
+		}
+
+		// Clients still connected when the stream ends on its own (abort, device
+		// error) are disconnected, so they can tell and reconnect
+		for conn := range s.clients {
+			conn.Close()
+		}
+	})
+}
+
+// remove disconnects a client, stopping the stream when it was the last one
+func (s *AudioSocket) remove(conn net.Conn) {
+	conn.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.clients[conn]; !ok {
+		return
+	}
+	delete(s.clients, conn)
+	log.Printf("[AudioSocket] Client disconnected (%d connected)", len(s.clients))
+
+	if len(s.clients) == 0 && s.stream != nil && !s.stream.stopping {
+		s.stream.stopping = true
+		s.stream.cancel()
+	}
+}
+
+// run acquires a listen-only channel and copies its audio to the clients until ctx ends
+// or the device stream fails
+func (s *AudioSocket) run(ctx context.Context, cancel context.CancelFunc) {
+	op := s.abortManager.Register(OperationTypeListen, audioSocketSessionID, cancel)
+	defer func() {
+		s.abortManager.Unregister(op)
+		op.Cleanup.Done()
+	}()
+
+	sess, err := s.sessionManager.AcquireChannel(ctx, session.AudioModeListen, "")
+	if err != nil {
+		log.Printf("[AudioSocket] Failed to open audio channel: %v", err)
+		return
+	}
+	defer s.sessionManager.ReleaseChannel(context.Background(), sess.ChannelID)
+
+	reader := s.hikClient.NewAudioStreamReader(ctx, &hikvision.AudioSession{
+		ChannelID: sess.ChannelID,
+		SessionID: sess.SessionID,
+		Mode:      hikvision.AudioMode(sess.Mode),
+		Codec:     sess.Codec,
+	})
+	reader.Start()
+	defer reader.Close()
+
+	log.Printf("[AudioSocket] Streaming channel %s", sess.ChannelID)
+
+	buffer := make([]byte, audio.SampleSize)
+	for {
+		n, err := reader.Read(buffer)
+		if n > 0 {
+			s.broadcast(buffer[:n])
+		}
+
+		if err != nil {
+			switch {
+			case ctx.Err() != nil:
+				log.Printf("[AudioSocket] Stopped streaming channel %s", sess.ChannelID)
+			case errors.Is(err, io.EOF):
+				log.Printf("[AudioSocket] Device ended the stream on channel %s", sess.ChannelID)
+			default:
+				log.Printf("[AudioSocket] Failed to read from device: %v", err)
+				s.abortManager.DeviceError(op, sess.ChannelID, err)
+			}
+			return
+		}
+	}
+}
+
+// broadcast writes a frame to every client, dropping those that fail or stall
+func (s *AudioSocket) broadcast(frame []byte) {
+	s.mu.Lock()
+	conns := make([]net.Conn, 0, len(s.clients))
+	for conn := range s.clients {
+		conns = append(conns, conn)
+	}
+	s.mu.Unlock()
+
+	for _, conn := range conns {
+		conn.SetWriteDeadline(time.Now().Add(audioSocketWriteTimeout))
+		if _, err := conn.Write(frame); err != nil {
+			log.Printf("[AudioSocket] Dropping client: %v", err)
+			s.remove(conn)
+		}
+	}
+}
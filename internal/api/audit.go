@@ -0,0 +1,181 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/acardace/hikvision-doorbell-server/internal/hikvision"
+	"github.com/acardace/hikvision-doorbell-server/internal/logger"
+	"github.com/acardace/hikvision-doorbell-server/internal/session"
+	"github.com/acardace/hikvision-doorbell-server/internal/webhook"
+)
+
+// Error categories reported in operation result logs
+const (
+	errCategoryBadRequest = "bad_request"  // Client sent something invalid
+	errCategoryBusy       = "busy"         // Another operation or all channels are in use
+	errCategoryDraining   = "draining"     // Server is not accepting new work
+	errCategoryDevice     = "device"       // Doorbell request failed
+	errCategoryCircuit    = "circuit_open" // Doorbell requests short-circuited after repeated failures
+	errCategoryTimeout    = "timeout"      // Doorbell or ICE did not respond in time
+	errCategoryCancelled  = "cancelled"    // Aborted or client went away
+	errCategoryConnection = "connection"   // WebRTC peer connection failed
+	errCategoryInternal   = "internal"     // Server-side failure
+)
+
+// operationResult collects the outcome of one API operation and logs it as a single
+// audit line when the operation ends. It is safe for concurrent use.
+type operationResult struct {
+	mu            sync.Mutex
+	endpoint      string
+	start         time.Time
+	channelID     string
+	bytesSent     int64 // Audio sent to the doorbell
+	bytesReceived int64 // Audio received from the doorbell
+	category      string
+	err           error
+	logged        bool
+
+	// Session lifecycle webhooks, for operations that call trackSession
+	notifier  *webhook.Notifier
+	source    string
+	sessionID string
+	openedAt  time.Time // When the channel was acquired
+}
+
+// newOperationResult starts timing an operation on endpoint
+func newOperationResult(endpoint string) *operationResult {
+	return &operationResult{
+		endpoint: endpoint,
+		start:    time.Now(),
+	}
+}
+
+// trackSession sends session_started to n once a channel is acquired (setChannel)
+// and session_ended when the operation is logged
+func (r *operationResult) trackSession(n *webhook.Notifier, source, sessionID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.notifier = n
+	r.source = source
+	r.sessionID = sessionID
+}
+
+// setChannel records the doorbell channel used by the operation
+func (r *operationResult) setChannel(channelID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.channelID = channelID
+
+	if r.notifier != nil && r.openedAt.IsZero() {
+		r.openedAt = time.Now()
+		r.notifier.Notify(webhook.Event{
+			Event:     webhook.EventSessionStarted,
+			Source:    r.source,
+			SessionID: r.sessionID,
+			ChannelID: channelID,
+			Timestamp: r.openedAt.UTC(),
+		})
+	}
+}
+
+// addBytes adds to the audio byte counters
+func (r *operationResult) addBytes(sent, received int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.bytesSent += sent
+	r.bytesReceived += received
+}
+
+// fail marks the operation as failed; only the first failure is kept
+func (r *operationResult) fail(category string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.category == "" {
+		r.category = category
+		r.err = err
+	}
+}
+
+// errorCategory returns the category of the first failure, empty if none
+func (r *operationResult) errorCategory() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.category
+}
+
+// log emits the audit line. Only the first call logs.
+func (r *operationResult) log() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.logged {
+		return
+	}
+	r.logged = true
+
+	attrs := []any{
+		slog.String("component", "audit"),
+		slog.String("endpoint", r.endpoint),
+		slog.String("channel_id", r.channelID),
+		slog.Int64("duration_ms", time.Since(r.start).Milliseconds()),
+		slog.Int64("bytes_sent", r.bytesSent),
+		slog.Int64("bytes_received", r.bytesReceived),
+		slog.Bool("success", r.category == ""),
+		slog.String("error_category", r.category),
+	}
+	if r.err != nil {
+		attrs = append(attrs, slog.String("error", r.err.Error()))
+	}
+	logger.Log.Info("operation result", attrs...)
+
+	if r.notifier != nil && !r.openedAt.IsZero() {
+		success := r.category == ""
+		r.notifier.Notify(webhook.Event{
+			Event:           webhook.EventSessionEnded,
+			Source:          r.source,
+			SessionID:       r.sessionID,
+			ChannelID:       r.channelID,
+			DurationSeconds: time.Since(r.openedAt).Seconds(),
+			Success:         &success,
+			ErrorCategory:   r.category,
+		})
+	}
+}
+
+// writeChannelUnavailable responds to an AcquireChannel error that means no channel
+// could be used: 409 when they are all busy (worth retrying) and 500 when the device
+// has none configured (needs fixing on the device). It returns false for other errors,
+// which the caller reports itself.
+func writeChannelUnavailable(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, session.ErrAllChannelsBusy):
+		http.Error(w, "All doorbell audio channels are in use, try again later", http.StatusConflict)
+	case errors.Is(err, session.ErrNoChannelsConfigured):
+		http.Error(w, "The doorbell has no two-way audio channels configured", http.StatusInternalServerError)
+	default:
+		return false
+	}
+	return true
+}
+
+// deviceErrorCategory classifies an error from acquiring or talking to the doorbell
+func deviceErrorCategory(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return errCategoryTimeout
+	case errors.Is(err, context.Canceled):
+		return errCategoryCancelled
+	case errors.Is(err, session.ErrAllChannelsBusy):
+		return errCategoryBusy
+	case errors.Is(err, session.ErrUnknownAudioInput):
+		return errCategoryBadRequest
+	case errors.Is(err, hikvision.ErrCircuitOpen):
+		return errCategoryCircuit
+	default:
+		return errCategoryDevice
+	}
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// bitrateCap returns the bandwidth cap in kbit/s for a session: the max_bitrate query
+// parameter or X-Max-Bitrate header, else the server default. A session can only lower
+// the server-wide cap, not raise it. Zero means uncapped.
+func (h *WebRTCHandler) bitrateCap(r *http.Request, serverCap int) (int, error) {
+	value := r.URL.Query().Get("max_bitrate")
+	if value == "" {
+		value = r.Header.Get("X-Max-Bitrate")
+	}
+	if value == "" {
+		return serverCap, nil
+	}
+
+	kbps, err := strconv.Atoi(value)
+	if err != nil || kbps <= 0 {
+		return 0, fmt.Errorf("invalid max_bitrate %q: must be a positive number of kbit/s", value)
+	}
+	if serverCap > 0 && kbps > serverCap {
+		kbps = serverCap
+	}
+	return kbps, nil
+}
+
+// capAudioBandwidth returns sdp with b=AS (kbit/s) and b=TIAS (bit/s) lines on every
+// audio media section, replacing any bandwidth lines already there. Per RFC 4566 the
+// b= lines follow the section's c= line.
+func capAudioBandwidth(sdp string, kbps int) string {
+	bandwidth := []string{
+		fmt.Sprintf("b=AS:%d", kbps),
+		fmt.Sprintf("b=TIAS:%d", kbps*1000),
+	}
+
+	lines := strings.Split(strings.TrimSuffix(sdp, "\r\n"), "\r\n")
+	out := make([]string, 0, len(lines)+4)
+	inAudio, pending := false, false
+	for _, line := range lines {
+		if strings.HasPrefix(line, "m=") {
+			inAudio = strings.HasPrefix(line, "m=audio")
+			pending = inAudio
+			out = append(out, line)
+			continue
+		}
+		if !inAudio {
+			out = append(out, line)
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "b="):
+			// Dropped in favour of the cap
+		case strings.HasPrefix(line, "c=") && pending:
+			out = append(out, line)
+			out = append(out, bandwidth...)
+			pending = false
+		case strings.HasPrefix(line, "a=") && pending:
+			// No connection line in this section; the b= lines go before the attributes
+			out = append(out, bandwidth...)
+			out = append(out, line)
+			pending = false
+		default:
+			out = append(out, line)
+		}
+	}
+
+	return strings.Join(out, "\r\n") + "\r\n"
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// bodyLimitMiddleware caps request bodies using http.MaxBytesReader.
+// Routes listed in overrides (keyed by path template) use their own limit instead of the global one.
+func bodyLimitMiddleware(limit int64, overrides map[string]int64) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			routeLimit := limit
+			if route := mux.CurrentRoute(r); route != nil {
+				if tmpl, err := route.GetPathTemplate(); err == nil {
+					if override, ok := overrides[tmpl]; ok {
+						routeLimit = override
+					}
+				}
+			}
+
+			if routeLimit > 0 {
+				// Reject early when the client announces an oversized body
+				if r.ContentLength > routeLimit {
+					log.Printf("[API] Rejected %s %s: body of %d bytes exceeds limit of %d", r.Method, r.URL.Path, r.ContentLength, routeLimit)
+					http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+					return
+				}
+				r.Body = http.MaxBytesReader(w, r.Body, routeLimit)
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// isBodyTooLarge reports whether err was caused by exceeding the request body limit
+func isBodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
@@ -0,0 +1,225 @@
+package api
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/acardace/hikvision-doorbell-server/internal/logger"
+	"github.com/acardace/hikvision-doorbell-server/internal/session"
+	"github.com/acardace/hikvision-doorbell-server/internal/webhook"
+)
+
+// CallState is the stage a WebRTC call has reached
+type CallState string
+
+const (
+	CallStateRinging   CallState = "ringing"   // Channel open, waiting for the browser to connect
+	CallStateConnected CallState = "connected" // Peer connected and audio flowing
+	CallStateEnded     CallState = "ended"     // Torn down, by either side or by an error
+)
+
+// Directions of a call's audio tracks
+const (
+	trackDeviceToClient = "device_to_client"
+	trackClientToDevice = "client_to_device"
+)
+
+// maxCallEvents bounds a call's event log; later events are dropped
+const maxCallEvents = 50
+
+// Call ties together the pieces of one WebRTC session (its channel, audio tracks and
+// state) so a UI can follow it as a whole. It is created for each offer and becomes
+// visible once the doorbell channel is open; the WebRTCHandler keeps the last call
+// after it ends so its outcome can still be read. It is safe for concurrent use.
+type Call struct {
+	mu          sync.Mutex
+	sessionID   string
+	state       CallState // Empty until the channel is open
+	channelID   string
+	mode        session.AudioMode
+	codec       string
+	tracks      []CallTrack
+	events      []CallEvent
+	startedAt   time.Time
+	connectedAt time.Time
+	endedAt     time.Time
+	endReason   string            // Error category the call ended with, empty for a hangup
+	notifier    *webhook.Notifier // call_* webhooks (nil disables)
+}
+
+// CallTrack describes one audio track of a call
+type CallTrack struct {
+	Direction string `json:"direction"` // device_to_client or client_to_device
+	TrackID   string `json:"track_id"`
+	Codec     string `json:"codec"` // RTP MIME type
+}
+
+// CallEvent is an entry in a call's event log
+type CallEvent struct {
+	Time   time.Time `json:"time"`
+	Event  string    `json:"event"` // A state reached, or track_added
+	Detail string    `json:"detail,omitempty"`
+}
+
+// CallInfo is a snapshot of a call for the status endpoint
+type CallInfo struct {
+	SessionID       string      `json:"session_id"`
+	State           CallState   `json:"state"`
+	ChannelID       string      `json:"channel_id"`
+	Mode            string      `json:"mode"`            // both, or listen for listen-only clients
+	Codec           string      `json:"codec,omitempty"` // The channel's codec
+	Tracks          []CallTrack `json:"tracks"`
+	StartedAt       time.Time   `json:"started_at"`
+	ConnectedAt     *time.Time  `json:"connected_at,omitempty"`
+	EndedAt         *time.Time  `json:"ended_at,omitempty"`
+	DurationSeconds float64     `json:"duration_seconds"` // Since the call started ringing
+	EndReason       string      `json:"end_reason,omitempty"`
+	Events          []CallEvent `json:"events"`
+}
+
+// newCall creates the call for an offer registered under sessionID
+func newCall(sessionID string, notifier *webhook.Notifier) *Call {
+	return &Call{sessionID: sessionID, notifier: notifier}
+}
+
+// ring records the channel opened for the call and moves it to ringing
+func (c *Call) ring(sess *session.AudioSession) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state != "" {
+		return
+	}
+	c.channelID = sess.ChannelID
+	c.mode = sess.Mode
+	c.codec = sess.Codec
+	c.startedAt = time.Now()
+	c.setStateLocked(CallStateRinging, c.startedAt, "")
+}
+
+// connect moves a ringing call to connected once the peer connection is up
+func (c *Call) connect() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state != CallStateRinging {
+		return
+	}
+	c.connectedAt = time.Now()
+	c.setStateLocked(CallStateConnected, c.connectedAt, "")
+}
+
+// end moves the call to ended with reason, the error category it failed with (empty
+// for a normal hangup). Calls that never rang are not reported.
+func (c *Call) end(reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state == "" || c.state == CallStateEnded {
+		return
+	}
+	c.endedAt = time.Now()
+	c.endReason = reason
+	c.setStateLocked(CallStateEnded, c.endedAt, reason)
+}
+
+// addTrack records an audio track carried by the call
+func (c *Call) addTrack(direction, trackID, codec string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.tracks = append(c.tracks, CallTrack{Direction: direction, TrackID: trackID, Codec: codec})
+	c.addEventLocked(time.Now(), "track_added", direction)
+}
+
+// setStateLocked records a state change, logs it and sends its webhook. Caller must hold mu.
+func (c *Call) setStateLocked(state CallState, at time.Time, detail string) {
+	c.state = state
+	c.addEventLocked(at, string(state), detail)
+
+	logger.Log.Info("call state changed",
+		slog.String("component", "webrtc"),
+		slog.String("session_id", c.sessionID),
+		slog.String("state", string(state)),
+		slog.String("channel_id", c.channelID))
+
+	event := webhook.Event{
+		Source:    "webrtc",
+		SessionID: c.sessionID,
+		ChannelID: c.channelID,
+		Timestamp: at.UTC(),
+	}
+	switch state {
+	case CallStateRinging:
+		event.Event = webhook.EventCallRinging
+	case CallStateConnected:
+		event.Event = webhook.EventCallConnected
+	case CallStateEnded:
+		success := c.endReason == ""
+		event.Event = webhook.EventCallEnded
+		event.DurationSeconds = c.endedAt.Sub(c.startedAt).Seconds()
+		event.Success = &success
+		event.ErrorCategory = c.endReason
+	}
+	c.notifier.Notify(event)
+}
+
+// addEventLocked appends to the event log. Caller must hold mu.
+func (c *Call) addEventLocked(at time.Time, event, detail string) {
+	if len(c.events) >= maxCallEvents {
+		return
+	}
+	c.events = append(c.events, CallEvent{Time: at.UTC(), Event: event, Detail: detail})
+}
+
+// Info returns a snapshot of the call. ok is false while the channel is not open yet.
+func (c *Call) Info() (info CallInfo, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state == "" {
+		return CallInfo{}, false
+	}
+
+	info = CallInfo{
+		SessionID: c.sessionID,
+		State:     c.state,
+		ChannelID: c.channelID,
+		Mode:      string(c.mode),
+		Codec:     c.codec,
+		Tracks:    append([]CallTrack{}, c.tracks...),
+		StartedAt: c.startedAt.UTC(),
+		EndReason: c.endReason,
+		Events:    append([]CallEvent{}, c.events...),
+	}
+	if !c.connectedAt.IsZero() {
+		connectedAt := c.connectedAt.UTC()
+		info.ConnectedAt = &connectedAt
+	}
+	end := time.Now()
+	if !c.endedAt.IsZero() {
+		endedAt := c.endedAt.UTC()
+		info.EndedAt = &endedAt
+		end = c.endedAt
+	}
+	info.DurationSeconds = end.Sub(c.startedAt).Seconds()
+	return info, true
+}
+
+// CurrentCall returns the active call, or the last one if it has ended. It is nil
+// before the first call rings.
+func (h *WebRTCHandler) CurrentCall() *CallInfo {
+	h.sessionMu.Lock()
+	call := h.call
+	h.sessionMu.Unlock()
+
+	if call == nil {
+		return nil
+	}
+	info, ok := call.Info()
+	if !ok {
+		return nil
+	}
+	return &info
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// ChannelResponse describes a doorbell audio channel
+type ChannelResponse struct {
+	ID              string `json:"id"`
+	Enabled         bool   `json:"enabled"` // true if the channel is currently open
+	CompressionType string `json:"compression_type,omitempty"`
+	AudioInputID    string `json:"audio_input_id,omitempty"`
+	AudioOutputID   string `json:"audio_output_id,omitempty"`
+}
+
+// HandleListChannels returns the doorbell's two-way audio channels and whether they are open
+func (h *Handler) HandleListChannels(w http.ResponseWriter, r *http.Request) {
+	channels, err := h.sessionManager.ListChannels(r.Context())
+	if err != nil {
+		log.Printf("[Channels] Failed to list channels: %v", err)
+		http.Error(w, "Failed to list channels", http.StatusBadGateway)
+		return
+	}
+
+	resp := make([]ChannelResponse, 0, len(channels))
+	for _, ch := range channels {
+		resp = append(resp, ChannelResponse{
+			ID:              ch.ID,
+			Enabled:         ch.Enabled,
+			CompressionType: ch.CompressionType,
+			AudioInputID:    ch.AudioInputID,
+			AudioOutputID:   ch.AudioOutputID,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ChannelCapabilitiesResponse lists the codecs and rates a doorbell audio channel supports
+type ChannelCapabilitiesResponse struct {
+	ID          string   `json:"id"`
+	Codec       string   `json:"codec,omitempty"` // Currently configured codec
+	Codecs      []string `json:"codecs"`
+	SampleRates []int    `json:"sample_rates"`
+}
+
+// HandleChannelCapabilities returns the codecs and sample rates a channel supports
+func (h *Handler) HandleChannelCapabilities(w http.ResponseWriter, r *http.Request) {
+	channelID := mux.Vars(r)["id"]
+
+	caps, err := h.sessionManager.ChannelCapabilities(r.Context(), channelID)
+	if err != nil {
+		log.Printf("[Channels] Failed to get capabilities of channel %s: %v", channelID, err)
+		http.Error(w, "Failed to get channel capabilities: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	resp := ChannelCapabilitiesResponse{
+		ID:          caps.ChannelID,
+		Codec:       caps.Codec,
+		Codecs:      caps.Codecs,
+		SampleRates: caps.SampleRates,
+	}
+	if resp.Codecs == nil {
+		resp.Codecs = []string{}
+	}
+	if resp.SampleRates == nil {
+		resp.SampleRates = []int{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// HandleReleaseChannel closes a single audio channel on the device.
+// Unlike /api/abort, tracked operations and WebRTC sessions are left untouched.
+func (h *Handler) HandleReleaseChannel(w http.ResponseWriter, r *http.Request) {
+	channelID := mux.Vars(r)["id"]
+	log.Printf("[Channels] Releasing channel %s", channelID)
+
+	if err := h.sessionManager.ReleaseChannel(r.Context(), channelID); err != nil {
+		log.Printf("[Channels] Failed to release channel %s: %v", channelID, err)
+		http.Error(w, "Failed to release channel: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Channel released"))
+}
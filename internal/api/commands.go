@@ -0,0 +1,238 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxBatchCommands bounds the commands in one /api/commands request
+const maxBatchCommands = 20
+
+// BatchRequest is an ordered list of commands run one after another. Each command is
+// an object with a "type" and the fields of the endpoint it stands for:
+//
+//	play:         audio_base64, format and loop_count, as for /api/audio/play-file
+//	arm_mode:     mode, as for PUT /api/device/arm-mode
+//	audio_output: output_id and channel_id, as for POST /api/audio/output
+//	wait:         duration, a Go duration such as "2s"
+type BatchRequest struct {
+	Commands []json.RawMessage `json:"commands"`
+
+	// ContinueOnError runs the remaining commands after one fails instead of stopping
+	ContinueOnError bool `json:"continue_on_error"`
+}
+
+// BatchResponse reports the outcome of each command that ran, in order
+type BatchResponse struct {
+	SessionID string               `json:"session_id"`
+	Success   bool                 `json:"success"` // Every command ran and succeeded
+	Results   []BatchCommandResult `json:"results"`
+}
+
+// BatchCommandResult is the response a command's endpoint gave
+type BatchCommandResult struct {
+	Type    string          `json:"type"`
+	Status  int             `json:"status"`
+	Success bool            `json:"success"`
+	Result  json.RawMessage `json:"result,omitempty"`  // JSON response body
+	Message string          `json:"message,omitempty"` // Plain-text response body
+}
+
+// batchCommand is a validated command ready to run
+type batchCommand struct {
+	kind string
+	body []byte        // The command object, passed to the endpoint's handler as its body
+	wait time.Duration // For wait commands
+	loop int           // loop_count of a play command (0 plays once)
+}
+
+// unsupportedCommands are device features this server has no endpoint for
+var unsupportedCommands = map[string]bool{"unlock": true, "light": true, "snapshot": true}
+
+// HandleCommands runs a batch of commands in order and reports each one's result.
+// Commands go through the same handlers as their endpoints, so each one gets the
+// usual session handling: a play opens and releases the channel itself. By default
+// the batch stops at the first command that fails.
+func (h *Handler) HandleCommands(w http.ResponseWriter, r *http.Request) {
+	var req BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("[Commands] Invalid request: %v", err)
+		if isBodyTooLarge(err) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Invalid commands request", http.StatusBadRequest)
+		return
+	}
+
+	// Validate the whole batch first so a typo doesn't leave it half run
+	commands, err := parseBatchCommands(req.Commands)
+	if err != nil {
+		log.Printf("[Commands] Rejected batch: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Every step runs under the batch's session ID, so /api/abort/{id} stops the current one
+	sessionID := requestSessionID(w, r)
+	log.Printf("[Commands] Running %d command(s) for session %s", len(commands), sessionID)
+
+	resp := BatchResponse{SessionID: sessionID, Success: true, Results: []BatchCommandResult{}}
+	for i, cmd := range commands {
+		if r.Context().Err() != nil {
+			resp.Success = false
+			break
+		}
+
+		result := h.runBatchCommand(r, sessionID, cmd)
+		resp.Results = append(resp.Results, result)
+		if !result.Success {
+			log.Printf("[Commands] Command %d (%s) failed with status %d", i+1, cmd.kind, result.Status)
+			resp.Success = false
+			if !req.ContinueOnError {
+				break
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// parseBatchCommands checks each command's type and its own parameters
+func parseBatchCommands(raw []json.RawMessage) ([]batchCommand, error) {
+	if len(raw) == 0 {
+		return nil, errors.New("commands is empty")
+	}
+	if len(raw) > maxBatchCommands {
+		return nil, fmt.Errorf("too many commands: at most %d per batch", maxBatchCommands)
+	}
+
+	commands := make([]batchCommand, 0, len(raw))
+	for i, body := range raw {
+		var fields struct {
+			Type      string `json:"type"`
+			Duration  string `json:"duration"`
+			LoopCount int    `json:"loop_count"`
+		}
+		if err := json.Unmarshal(body, &fields); err != nil {
+			return nil, fmt.Errorf("command %d: invalid JSON object", i+1)
+		}
+
+		cmd := batchCommand{kind: fields.Type, body: body}
+		switch {
+		case fields.Type == "play":
+			if fields.LoopCount < 0 {
+				return nil, fmt.Errorf("command %d: loop_count must be a positive integer", i+1)
+			}
+			cmd.loop = fields.LoopCount
+		case fields.Type == "arm_mode", fields.Type == "audio_output":
+		case fields.Type == "wait":
+			d, err := time.ParseDuration(fields.Duration)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("command %d: wait needs a positive duration such as 2s", i+1)
+			}
+			cmd.wait = d
+		case unsupportedCommands[fields.Type]:
+			return nil, fmt.Errorf("command %d: %s is not supported by this server", i+1, fields.Type)
+		case fields.Type == "":
+			return nil, fmt.Errorf("command %d: type is required", i+1)
+		default:
+			return nil, fmt.Errorf("command %d: unknown type %q", i+1, fields.Type)
+		}
+		commands = append(commands, cmd)
+	}
+	return commands, nil
+}
+
+// runBatchCommand runs one command through its endpoint's handler
+func (h *Handler) runBatchCommand(r *http.Request, sessionID string, cmd batchCommand) BatchCommandResult {
+	if cmd.kind == "wait" {
+		select {
+		case <-time.After(cmd.wait):
+			return BatchCommandResult{Type: cmd.kind, Status: http.StatusOK, Success: true}
+		case <-r.Context().Done():
+			return BatchCommandResult{Type: cmd.kind, Status: http.StatusServiceUnavailable, Message: "Batch cancelled"}
+		}
+	}
+
+	var handler http.HandlerFunc
+	path := ""
+	query := url.Values{}
+	switch cmd.kind {
+	case "play":
+		handler = HandlePlayFile(h.hikClient, h.sessionManager, h.abortManager, h.convertCache, h.notifier, h.recordingDir, &h.cfg.PlayFile)
+		path = "/api/audio/play-file"
+		if cmd.loop > 0 {
+			query.Set("loop_count", strconv.Itoa(cmd.loop))
+		}
+	case "arm_mode":
+		handler = h.HandleSetArmMode
+		path = "/api/device/arm-mode"
+	case "audio_output":
+		handler = h.HandleSetAudioOutput
+		path = "/api/audio/output"
+	}
+
+	sub, err := http.NewRequestWithContext(r.Context(), http.MethodPost, path+"?"+query.Encode(), bytes.NewReader(cmd.body))
+	if err != nil {
+		return BatchCommandResult{Type: cmd.kind, Status: http.StatusInternalServerError, Message: err.Error()}
+	}
+	sub.Header.Set("Content-Type", "application/json")
+	sub.Header.Set("Accept", "application/json")
+	sub.Header.Set("X-Session-ID", sessionID)
+	sub.RemoteAddr = r.RemoteAddr
+
+	rec := newCapturedResponse()
+	handler(rec, sub)
+
+	result := BatchCommandResult{
+		Type:    cmd.kind,
+		Status:  rec.status,
+		Success: rec.status >= 200 && rec.status < 300,
+	}
+	body := bytes.TrimSpace(rec.body.Bytes())
+	if strings.HasPrefix(rec.header.Get("Content-Type"), "application/json") && json.Valid(body) {
+		result.Result = body
+	} else {
+		result.Message = string(body)
+	}
+	return result
+}
+
+// capturedResponse collects a handler's response so it can be folded into a batch result
+type capturedResponse struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func newCapturedResponse() *capturedResponse {
+	return &capturedResponse{header: make(http.Header), status: http.StatusOK}
+}
+
+func (c *capturedResponse) Header() http.Header {
+	return c.header
+}
+
+func (c *capturedResponse) Write(p []byte) (int, error) {
+	c.wroteHeader = true
+	return c.body.Write(p)
+}
+
+func (c *capturedResponse) WriteHeader(status int) {
+	if c.wroteHeader {
+		return
+	}
+	c.status = status
+	c.wroteHeader = true
+}
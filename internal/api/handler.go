@@ -1,37 +1,107 @@
 package api
 
 import (
+	"context"
 	"log"
+	"log/slog"
 	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/acardace/hikvision-doorbell-server/internal/audio/transcode"
+	"github.com/acardace/hikvision-doorbell-server/internal/config"
 	"github.com/acardace/hikvision-doorbell-server/internal/hikvision"
+	"github.com/acardace/hikvision-doorbell-server/internal/logger"
 	"github.com/acardace/hikvision-doorbell-server/internal/session"
+	"github.com/acardace/hikvision-doorbell-server/internal/webhook"
 	"github.com/gorilla/mux"
 )
 
 type Handler struct {
-	hikClient     *hikvision.Client
-	webrtcHandler *WebRTCHandler
-	abortManager  *AbortManager
+	cfgMu          sync.RWMutex // Guards hot-reloadable fields of cfg
+	cfg            *config.Config
+	hikClient      hikvision.DeviceClient
+	sessionManager session.SessionManager
+	webrtcHandler  *WebRTCHandler
+	abortManager   *AbortManager
+	convertCache   *transcode.Cache  // nil when play_file.conversion_cache_bytes is 0
+	notifier       *webhook.Notifier // nil when webhook.url is empty
+	audioSocket    *AudioSocket      // nil when server.audio_socket is empty
+
+	// logLevelOverride is the -log-level flag, which outranks log.level across reloads
+	logLevelOverride string
 }
 
-func NewHandler(hikClient *hikvision.Client) *Handler {
+func NewHandler(cfg *config.Config, hikClient hikvision.DeviceClient) *Handler {
 	// Create session manager and abort manager
 	sessionManager := session.NewHikvisionSessionManager(hikClient)
+	sessionManager.SetChannelCacheTTL(cfg.Hikvision.ChannelCacheTTL)
+	sessionManager.SetReopenDelay(cfg.Hikvision.ReopenDelay)
+	sessionManager.SetChannelPolicy(session.ChannelPolicy(cfg.Hikvision.ChannelPolicy))
+	hooks := cfg.Hikvision.ChannelHooks
+	sessionManager.SetChannelHooks(
+		hikvision.ChannelHook{Method: hooks.Claim.Method, Path: hooks.Claim.Path},
+		hikvision.ChannelHook{Method: hooks.Release.Method, Path: hooks.Release.Path},
+	)
 	abortManager := NewAbortManager(sessionManager)
 
+	var convertCache *transcode.Cache
+	if cfg.PlayFile.ConversionCacheBytes > 0 {
+		convertCache = transcode.NewCache(cfg.PlayFile.ConversionCacheBytes)
+	}
+
+	var notifier *webhook.Notifier
+	if cfg.Webhook.URL != "" {
+		notifier = webhook.New(cfg.Webhook.URL, cfg.Webhook.Timeout, cfg.Webhook.Retry)
+	}
+
+	var audioSocket *AudioSocket
+	if cfg.Server.AudioSocket != "" {
+		audioSocket = NewAudioSocket(cfg.Server.AudioSocket, hikClient, sessionManager, abortManager)
+	}
+
 	return &Handler{
-		hikClient:     hikClient,
-		webrtcHandler: NewWebRTCHandler(hikClient, sessionManager, abortManager),
-		abortManager:  abortManager,
+		cfg:            cfg,
+		hikClient:      hikClient,
+		sessionManager: sessionManager,
+		webrtcHandler:  NewWebRTCHandler(hikClient, sessionManager, abortManager, notifier),
+		abortManager:   abortManager,
+		convertCache:   convertCache,
+		notifier:       notifier,
+		audioSocket:    audioSocket,
+	}
+}
+
+// StartAudioSocket starts serving doorbell audio on server.audio_socket, if configured
+func (h *Handler) StartAudioSocket() error {
+	if h.audioSocket == nil {
+		return nil
 	}
+	return h.audioSocket.Start()
 }
 
 // Healthz endpoint for Kubernetes health probes
 func (h *Handler) Healthz(w http.ResponseWriter, r *http.Request) {
-	// Test connection to doorbell by getting channels (quietly, without logging)
-	_, err := h.hikClient.GetTwoWayAudioChannelsQuiet()
-	if err != nil {
+	// Report not-ready while draining so load balancers stop routing new requests
+	if h.abortManager.IsDraining() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("draining"))
+		return
+	}
+
+	// Fail fast while the circuit breaker is cooling down; once it elapses the check
+	// below is let through as the probe
+	if state := h.hikClient.BreakerState(); state.Open && state.RetryIn > 0 {
+		log.Printf("[Health] Device unreachable: circuit breaker open, probing in %s", state.RetryIn.Round(time.Second))
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("unhealthy"))
+		return
+	}
+
+	// Test connection to doorbell by getting channels (quietly, may reuse the cached list)
+	if err := h.sessionManager.CheckDevice(r.Context()); err != nil {
 		// Only log errors, not successful health checks
 		log.Printf("[Health] Device unreachable: %v", err)
 		w.WriteHeader(http.StatusServiceUnavailable)
@@ -51,43 +121,224 @@ func (h *Handler) CloseAllSessions() error {
 	return nil
 }
 
+// ShutdownReport summarizes what was cleaned up when the server stopped
+type ShutdownReport struct {
+	SessionsClosed int // WebRTC sessions torn down
+	AbortSummary
+	Auth hikvision.AuthStats // Device auth challenges and retries over the server's lifetime
+}
+
+// Shutdown closes the WebRTC session and audio socket, aborts remaining operations and releases any
+// channels still open on the device, then logs a single summary line
+func (h *Handler) Shutdown(ctx context.Context) (ShutdownReport, error) {
+	var report ShutdownReport
+
+	if h.webrtcHandler.Close() {
+		report.SessionsClosed = 1
+	}
+
+	// Disconnect socket clients so the stream doesn't reopen the channel being released
+	var err error
+	if h.audioSocket != nil {
+		err = h.audioSocket.Close(ctx)
+	}
+
+	summary, abortErr := h.abortManager.AbortAll(ctx)
+	report.AbortSummary = summary
+	if abortErr != nil && err == nil {
+		err = abortErr
+	}
+
+	// Closing the session ends its goroutines; wait so none outlives the server
+	if stopErr := h.webrtcHandler.tasks.Stop(ctx); stopErr != nil && err == nil {
+		err = stopErr
+	}
+
+	// Deliver the session_ended events of the sessions just closed
+	if notifyErr := h.notifier.Close(ctx); notifyErr != nil && err == nil {
+		err = notifyErr
+	}
+	report.Auth = h.hikClient.AuthStats()
+
+	attrs := []any{
+		slog.String("component", "server"),
+		slog.Int("sessions_closed", report.SessionsClosed),
+		slog.Int("operations_aborted", report.OperationsAborted),
+		slog.Int("channels_released", report.ChannelsReleased),
+		slog.Int("channels_failed", report.ChannelsFailed),
+		slog.Int64("auth_challenges", report.Auth.Challenges),
+		slog.Int64("auth_retries", report.Auth.Retries),
+		slog.Int64("auth_resets", report.Auth.Resets),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	logger.Log.Info("shutdown report", attrs...)
+
+	return report, err
+}
+
 // CORS middleware to allow requests from Home Assistant
-func corsMiddleware(next http.Handler) http.Handler {
+func (h *Handler) corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Allow all origins for local network deployment
-		// In production, you might want to restrict this to specific origins
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-		// Handle preflight requests
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
+		// Allowed origins come from server.cors_origins ("*" by default for local network deployment)
+		if origin := h.allowedOrigin(r.Header.Get("Origin")); origin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			if origin != "*" {
+				w.Header().Add("Vary", "Origin")
+			}
 		}
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Audio-Codec, X-Audio-Input, X-Max-Bitrate, X-Session-ID")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Session-ID, X-Recording-ID")
 
 		next.ServeHTTP(w, r)
 	})
 }
 
+// handlePreflight answers OPTIONS for every route, so routes only list the methods
+// they implement. Access-Control-Allow-Methods reflects the methods registered for
+// the requested path; paths with no routes get 404.
+func handlePreflight(router *mux.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		methods := allowedMethods(router, r)
+		if len(methods) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+
+		methods = append(methods, "OPTIONS")
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// allowedMethods returns the methods of the routes matching r's path, in registration order
+func allowedMethods(router *mux.Router, r *http.Request) []string {
+	var methods []string
+	router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		routeMethods, err := route.GetMethods()
+		if err != nil {
+			return nil // Route without a method matcher
+		}
+		for _, method := range routeMethods {
+			if method == "OPTIONS" || slices.Contains(methods, method) {
+				continue
+			}
+			probe := r.Clone(r.Context())
+			probe.Method = method
+			var match mux.RouteMatch
+			if route.Match(probe, &match) {
+				methods = append(methods, method)
+			}
+		}
+		return nil
+	})
+	return methods
+}
+
+// allowedOrigin returns the value for Access-Control-Allow-Origin, or "" if the origin is not allowed
+func (h *Handler) allowedOrigin(origin string) string {
+	h.cfgMu.RLock()
+	defer h.cfgMu.RUnlock()
+
+	for _, allowed := range h.cfg.Server.CORSOrigins {
+		if allowed == "*" {
+			return "*"
+		}
+		if origin != "" && allowed == origin {
+			return origin
+		}
+	}
+	return ""
+}
+
 // SetupRoutes configures all API routes
 func (h *Handler) SetupRoutes() *mux.Router {
 	router := mux.NewRouter()
 
 	// Apply CORS middleware
-	router.Use(corsMiddleware)
+	router.Use(h.corsMiddleware)
+
+	// Compress JSON responses (skips audio endpoints)
+	if h.cfg.Server.Compression {
+		router.Use(compressionMiddleware)
+	}
+
+	// Limit request body sizes (play-file uploads get their own limit)
+	router.Use(bodyLimitMiddleware(h.cfg.Server.MaxBodyBytes, map[string]int64{
+		"/api/audio/play-file":   h.cfg.Server.PlayFileMaxBodyBytes,
+		"/api/audio/duration":    h.cfg.Server.PlayFileMaxBodyBytes,
+		"/api/commands":          h.cfg.Server.PlayFileMaxBodyBytes, // May carry play commands
+		"/api/audio/play-stream": 0,                                 // Streams last as long as the client keeps sending
+	}))
 
 	// Health check
 	router.HandleFunc("/healthz", h.Healthz).Methods("GET")
 
+	// Server and device status (capabilities detected at startup, circuit breaker)
+	router.HandleFunc("/api/status", h.HandleStatus).Methods("GET")
+
 	// WebRTC signaling
-	router.HandleFunc("/api/webrtc/offer", h.webrtcHandler.HandleOffer).Methods("POST", "OPTIONS")
+	router.HandleFunc("/api/webrtc/offer", h.webrtcHandler.HandleOffer).Methods("POST")
+	router.HandleFunc("/api/webrtc/stats", h.HandleSessionStats).Methods("GET")
 
 	// Play audio file (with automatic session management)
-	router.HandleFunc("/api/audio/play-file", HandlePlayFile(h.hikClient, h.abortManager)).Methods("POST", "OPTIONS")
+	router.HandleFunc("/api/audio/play-file", HandlePlayFile(h.hikClient, h.sessionManager, h.abortManager, h.convertCache, h.notifier, h.recordingDir, &h.cfg.PlayFile)).Methods("POST")
+
+	// Play audio as it is uploaded (chunked), for live generators such as TTS
+	router.HandleFunc("/api/audio/play-stream", h.HandlePlayStream).Methods("POST")
+
+	// Playback duration of a play-file upload, without playing it
+	router.HandleFunc("/api/audio/duration", h.HandleAudioDuration).Methods("POST")
+
+	// Live doorbell audio over plain HTTP (raw G.711 or ?format=wav)
+	router.HandleFunc("/api/audio/listen", h.HandleListen).Methods("GET")
+
+	// Speaker routing (internal speaker, line-out) for two-way audio channels
+	router.HandleFunc("/api/audio/output", h.HandleGetAudioOutput).Methods("GET")
+	router.HandleFunc("/api/audio/output", h.HandleSetAudioOutput).Methods("POST")
+
+	// Doorbell microphone level (during an active WebRTC session)
+	router.HandleFunc("/api/audio/input-level", h.HandleInputLevel).Methods("GET")
+
+	// Round-trip latency diagnostic (requires the device to loop audio back)
+	router.HandleFunc("/api/diagnostics/latency", h.HandleLatencyTest).Methods("POST")
+
+	// Channel management
+	router.HandleFunc("/api/channels", h.HandleListChannels).Methods("GET")
+	router.HandleFunc("/api/channels/{id}/capabilities", h.HandleChannelCapabilities).Methods("GET")
+	router.HandleFunc("/api/channels/{id}/release", h.HandleReleaseChannel).Methods("POST")
+
+	// Scene/arming mode (e.g. silence the chime at night)
+	router.HandleFunc("/api/device/arm-mode", h.HandleGetArmMode).Methods("GET")
+	router.HandleFunc("/api/device/arm-mode", h.HandleSetArmMode).Methods("PUT")
+
+	// Credential check against the doorbell, without side effects
+	router.HandleFunc("/api/device/test-auth", h.HandleTestAuth).Methods("POST")
+
+	// Video stream URLs (the server does not proxy video)
+	router.HandleFunc("/api/video/streams", h.HandleVideoStreams).Methods("GET")
+
+	// Several commands (play, arm mode, audio output, wait) run in order
+	router.HandleFunc("/api/commands", h.HandleCommands).Methods("POST")
+
+	// Abort all operations, or only those of one session
+	router.HandleFunc("/api/abort", h.HandleAbort).Methods("POST")
+	router.HandleFunc("/api/abort/{sessionID}", h.HandleAbortSession).Methods("POST")
+
+	// Admin endpoints (require server.admin_token)
+	router.HandleFunc("/api/admin/reload", h.requireAdmin(h.HandleReload)).Methods("POST")
+	router.HandleFunc("/api/admin/drain", h.requireAdmin(h.HandleDrainStatus)).Methods("GET")
+	router.HandleFunc("/api/admin/drain", h.requireAdmin(h.HandleDrain)).Methods("POST")
+
+	// Recordings (require server.admin_token and server.recording_dir)
+	router.HandleFunc("/api/recordings", h.requireAdmin(h.HandleListRecordings)).Methods("GET")
+	router.HandleFunc("/api/recordings/{id}", h.requireAdmin(h.HandleDownloadRecording)).Methods("GET")
 
-	// Abort all operations
-	router.HandleFunc("/api/abort", h.HandleAbort).Methods("POST", "OPTIONS")
+	// CORS preflight for all of the above; registered last so it only sees OPTIONS
+	// requests no other route claimed
+	router.Methods("OPTIONS").HandlerFunc(handlePreflight(router))
 
 	return router
 }
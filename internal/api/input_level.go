@@ -0,0 +1,53 @@
+package api
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/acardace/hikvision-doorbell-server/internal/audio"
+)
+
+// InputLevelResponse reports the doorbell microphone level measured from the incoming audio
+type InputLevelResponse struct {
+	RMS        float64   `json:"rms"`  // Normalized RMS level, 0..1 of full scale
+	DBFS       *float64  `json:"dbfs"` // Level in dBFS, null for digital silence
+	MeasuredAt time.Time `json:"measured_at"`
+}
+
+// InputLevel returns the doorbell microphone level of the active WebRTC session.
+// ok is false if no session is streaming device audio.
+func (h *WebRTCHandler) InputLevel() (level float64, measuredAt time.Time, ok bool) {
+	h.sessionMu.Lock()
+	defer h.sessionMu.Unlock()
+
+	if h.audioStreamer == nil || h.activeSession == nil {
+		return 0, time.Time{}, false
+	}
+
+	level, measuredAt = h.audioStreamer.InputLevel()
+	return level, measuredAt, !measuredAt.IsZero()
+}
+
+// HandleInputLevel reports the RMS level of the doorbell microphone.
+// The device has no metering endpoint, so the level is computed from the audio
+// received during an active WebRTC session.
+func (h *Handler) HandleInputLevel(w http.ResponseWriter, r *http.Request) {
+	level, measuredAt, ok := h.webrtcHandler.InputLevel()
+	if !ok {
+		http.Error(w, "No active audio session", http.StatusNotFound)
+		return
+	}
+
+	resp := InputLevelResponse{
+		RMS:        level,
+		MeasuredAt: measuredAt,
+	}
+	if dbfs := audio.LevelToDBFS(level); !math.IsInf(dbfs, -1) {
+		resp.DBFS = &dbfs
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,199 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/acardace/hikvision-doorbell-server/internal/audio"
+	"github.com/acardace/hikvision-doorbell-server/internal/hikvision"
+	"github.com/acardace/hikvision-doorbell-server/internal/session"
+)
+
+const (
+	latencyLeadIn        = time.Second            // Silence sent before the tone while connections settle
+	latencyToneDuration  = 200 * time.Millisecond // Length of the test tone
+	latencyToneFrequency = 1000                   // Hz
+	latencyMinThreshold  = 0.05                   // Minimum RMS treated as the returning tone
+	latencyDefaultWait   = 3 * time.Second        // How long to listen for the tone after sending it
+)
+
+// LatencyResponse reports the result of a round-trip latency test
+type LatencyResponse struct {
+	ChannelID      string   `json:"channel_id"`
+	Detected       bool     `json:"detected"`             // false if the tone never came back
+	LatencyMs      *float64 `json:"latency_ms,omitempty"` // Time from sending the tone to hearing it back
+	NoiseFloorDBFS *float64 `json:"noise_floor_dbfs"`     // Loudest frame heard during the lead-in
+	PeakDBFS       *float64 `json:"peak_dbfs"`            // Loudest frame heard after the tone was sent
+}
+
+// HandleLatencyTest plays a short tone to the device and listens for it on the device's
+// microphone stream, reporting the round-trip time. This only works if the device loops
+// audio back (speaker near microphone, or a device that echoes); the result is approximate
+// since it includes device buffering on both paths.
+func (h *Handler) HandleLatencyTest(w http.ResponseWriter, r *http.Request) {
+	if h.abortManager.IsDraining() {
+		http.Error(w, "Server is draining", http.StatusServiceUnavailable)
+		return
+	}
+	if h.abortManager.HasActiveOperation() {
+		http.Error(w, "Cannot run latency test while another session is active", http.StatusConflict)
+		return
+	}
+
+	wait := latencyDefaultWait
+	if v := r.URL.Query().Get("wait"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			http.Error(w, "Invalid wait duration", http.StatusBadRequest)
+			return
+		}
+		wait = d
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	op := h.abortManager.Register(OperationTypeDiagnostic, requestSessionID(w, r), cancel)
+	defer func() {
+		h.abortManager.Unregister(op)
+		op.Cleanup.Done()
+	}()
+
+	session, err := h.sessionManager.AcquireChannel(ctx, session.AudioModeBoth, "")
+	if err != nil {
+		log.Printf("[Latency] Failed to open audio channel: %v", err)
+		if writeChannelUnavailable(w, err) {
+			return
+		}
+		http.Error(w, "Failed to open audio channel: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer h.sessionManager.ReleaseChannel(context.Background(), session.ChannelID)
+
+	// Level detection decodes µ-law only
+	if session.Codec != "" && session.Codec != "G.711ulaw" {
+		http.Error(w, "Latency test requires a G.711ulaw channel, channel uses "+session.Codec, http.StatusUnprocessableEntity)
+		return
+	}
+
+	codec, _ := audio.LookupCodec("G.711ulaw")
+	tone, err := audio.GenerateTone(codec, latencyToneFrequency, latencyToneDuration, 0.5)
+	if err != nil {
+		http.Error(w, "Failed to generate tone", http.StatusInternalServerError)
+		return
+	}
+
+	hikSession := hikvision.AudioSession{
+		ChannelID: session.ChannelID,
+		SessionID: session.SessionID,
+		Mode:      hikvision.AudioMode(session.Mode),
+		Codec:     session.Codec,
+	}
+
+	reader := h.hikClient.NewAudioStreamReader(ctx, &hikSession)
+	reader.Start()
+	defer reader.Close()
+
+	writer := h.hikClient.NewAudioStreamWriter(ctx, &hikSession)
+	writer.Start()
+	defer writer.Close()
+
+	// Frames from the device, timestamped on arrival
+	type frame struct {
+		at  time.Time
+		rms float64
+	}
+	frames := make(chan frame, 256)
+	go func() {
+		defer close(frames)
+		buf := make([]byte, audio.SampleSize)
+		for {
+			if _, err := io.ReadFull(reader, buf); err != nil {
+				return
+			}
+			select {
+			case frames <- frame{at: time.Now(), rms: audio.MulawRMS(buf)}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	log.Printf("[Latency] Starting test on channel %s", session.ChannelID)
+	writer.Write(codec.SilenceBytes(latencyLeadIn))
+
+	// Measure the noise floor while the lead-in plays
+	var noise float64
+	leadInDone := time.After(latencyLeadIn)
+waitLeadIn:
+	for {
+		select {
+		case <-ctx.Done():
+			http.Error(w, "Operation interrupted", http.StatusServiceUnavailable)
+			return
+		case f, ok := <-frames:
+			if !ok {
+				h.abortManager.DeviceError(op, session.ChannelID, errors.New("device audio stream ended"))
+				http.Error(w, "Device audio stream ended", http.StatusBadGateway)
+				return
+			}
+			noise = math.Max(noise, f.rms)
+		case <-leadInDone:
+			break waitLeadIn
+		}
+	}
+
+	threshold := math.Max(latencyMinThreshold, noise*4)
+	sentAt := time.Now()
+	writer.Write(tone)
+
+	resp := LatencyResponse{ChannelID: session.ChannelID, NoiseFloorDBFS: dbfsOrNil(noise)}
+	var peak float64
+	deadline := time.After(wait)
+listen:
+	for {
+		select {
+		case <-ctx.Done():
+			http.Error(w, "Operation interrupted", http.StatusServiceUnavailable)
+			return
+		case f, ok := <-frames:
+			if !ok {
+				break listen
+			}
+			peak = math.Max(peak, f.rms)
+			if f.rms >= threshold {
+				ms := float64(f.at.Sub(sentAt).Microseconds()) / 1000
+				resp.Detected = true
+				resp.LatencyMs = &ms
+				break listen
+			}
+		case <-deadline:
+			break listen
+		}
+	}
+	resp.PeakDBFS = dbfsOrNil(peak)
+
+	if resp.Detected {
+		log.Printf("[Latency] Channel %s round-trip latency: %.1f ms", session.ChannelID, *resp.LatencyMs)
+	} else {
+		log.Printf("[Latency] Channel %s: tone not detected within %s", session.ChannelID, wait)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// dbfsOrNil converts a normalized level to dBFS, returning nil for digital silence
+func dbfsOrNil(level float64) *float64 {
+	dbfs := audio.LevelToDBFS(level)
+	if math.IsInf(dbfs, -1) {
+		return nil
+	}
+	return &dbfs
+}
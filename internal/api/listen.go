@@ -0,0 +1,135 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/acardace/hikvision-doorbell-server/internal/audio"
+	"github.com/acardace/hikvision-doorbell-server/internal/hikvision"
+	"github.com/acardace/hikvision-doorbell-server/internal/session"
+)
+
+// HandleListen streams live doorbell audio over plain HTTP until the client disconnects.
+// The body is the channel's raw G.711 stream, or a WAV stream with ?format=wav, so
+// clients without WebRTC (curl, ffplay, an <audio> tag) can listen in.
+func (h *Handler) HandleListen(w http.ResponseWriter, r *http.Request) {
+	result := newOperationResult("/api/audio/listen")
+	defer result.log()
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "raw" && format != "wav" {
+		err := errors.New("format must be raw or wav")
+		result.fail(errCategoryBadRequest, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if h.abortManager.IsDraining() {
+		log.Println("[Listen] Rejected: server is draining")
+		result.fail(errCategoryDraining, errors.New("server is draining"))
+		http.Error(w, "Server is draining", http.StatusServiceUnavailable)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		result.fail(errCategoryInternal, errors.New("response writer does not support flushing"))
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// The request context ends when the client disconnects
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	op := h.abortManager.Register(OperationTypeListen, requestSessionID(w, r), cancel)
+	defer func() {
+		h.abortManager.Unregister(op)
+		op.Cleanup.Done()
+	}()
+
+	sess, err := h.sessionManager.AcquireChannel(ctx, session.AudioModeListen, "")
+	if err != nil {
+		log.Printf("[Listen] Failed to open audio channel: %v", err)
+		result.fail(deviceErrorCategory(err), err)
+		if writeChannelUnavailable(w, err) {
+			return
+		}
+		if errors.Is(err, hikvision.ErrCircuitOpen) {
+			http.Error(w, "Doorbell unreachable, retrying later", http.StatusServiceUnavailable)
+			return
+		}
+		http.Error(w, "Failed to open audio channel: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer h.sessionManager.ReleaseChannel(context.Background(), sess.ChannelID)
+	result.setChannel(sess.ChannelID)
+
+	codecName := sess.Codec
+	if codecName == "" {
+		codecName = audio.DefaultCodec
+	}
+	codec, ok := audio.LookupCodec(codecName)
+	if !ok {
+		err := errors.New("unsupported channel codec " + codecName)
+		result.fail(errCategoryDevice, err)
+		http.Error(w, "Cannot stream channel audio: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	reader := h.hikClient.NewAudioStreamReader(ctx, &hikvision.AudioSession{
+		ChannelID: sess.ChannelID,
+		SessionID: sess.SessionID,
+		Mode:      hikvision.AudioMode(sess.Mode),
+		Codec:     sess.Codec,
+	})
+	reader.Start()
+	defer reader.Close()
+
+	log.Printf("[Listen] Streaming channel %s (%s, format %q) to %s", sess.ChannelID, codec.Name, format, r.RemoteAddr)
+
+	w.Header().Set("Cache-Control", "no-store")
+	if format == "wav" {
+		w.Header().Set("Content-Type", "audio/wav")
+		w.WriteHeader(http.StatusOK)
+		w.Write(codec.WAVHeader(audio.WAVStreamingSize))
+	} else {
+		w.Header().Set("Content-Type", codec.RTPMimeType())
+		w.WriteHeader(http.StatusOK)
+	}
+	flusher.Flush()
+
+	buffer := make([]byte, audio.SampleSize)
+	for {
+		n, err := reader.Read(buffer)
+		if n > 0 {
+			if _, werr := w.Write(buffer[:n]); werr != nil {
+				log.Printf("[Listen] Client disconnected from channel %s: %v", sess.ChannelID, werr)
+				return
+			}
+			flusher.Flush()
+			result.addBytes(0, int64(n))
+		}
+
+		if err != nil {
+			switch {
+			case r.Context().Err() != nil:
+				// Client disconnecting is how a listen normally ends
+				log.Printf("[Listen] Client disconnected from channel %s", sess.ChannelID)
+			case ctx.Err() != nil:
+				log.Printf("[Listen] Aborted streaming channel %s", sess.ChannelID)
+				result.fail(errCategoryCancelled, ctx.Err())
+			case errors.Is(err, io.EOF):
+				log.Printf("[Listen] Device ended the stream on channel %s", sess.ChannelID)
+			default:
+				log.Printf("[Listen] Failed to read from device: %v", err)
+				result.fail(errCategoryDevice, err)
+				h.abortManager.DeviceError(op, sess.ChannelID, err)
+			}
+			return
+		}
+	}
+}
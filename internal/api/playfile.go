@@ -2,23 +2,64 @@ package api
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime"
 	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/acardace/hikvision-doorbell-server/internal/audio"
+	"github.com/acardace/hikvision-doorbell-server/internal/audio/transcode"
+	"github.com/acardace/hikvision-doorbell-server/internal/config"
 	"github.com/acardace/hikvision-doorbell-server/internal/hikvision"
 	"github.com/acardace/hikvision-doorbell-server/internal/session"
+	"github.com/acardace/hikvision-doorbell-server/internal/webhook"
 )
 
+// PlayFileResponse describes a completed playback (returned for Accept: application/json)
+type PlayFileResponse struct {
+	ChannelID       string  `json:"channel_id"`
+	SessionID       string  `json:"session_id"`
+	BytesSent       int     `json:"bytes_sent"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	Plays           int     `json:"plays"` // Times the audio was played (more than 1 for loops)
+
+	// Conversion is set when the upload was converted on the server (convert=true)
+	Conversion *transcode.Report `json:"conversion,omitempty"`
+
+	// RecordingID names the recording of the doorbell's microphone (record=true)
+	RecordingID string `json:"recording_id,omitempty"`
+}
+
 // HandlePlayFile handles uploading and playing an audio file
-// This automatically manages the session lifecycle
-func HandlePlayFile(hikClient *hikvision.Client, abortManager *AbortManager) http.HandlerFunc {
+// This automatically manages the session lifecycle. With record=true the doorbell's
+// microphone is recorded into recordingDir while the file plays and for
+// play_file.record_tail afterwards, capturing the response to an announcement.
+func HandlePlayFile(hikClient hikvision.DeviceClient, sessionManager session.SessionManager, abortManager *AbortManager, convertCache *transcode.Cache, notifier *webhook.Notifier, recordingDir func() string, cfg *config.PlayFileConfig) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Check if there's an active op
-		if abortManager.HasActiveOperation() {
+		result := newOperationResult("/api/audio/play-file")
+		defer result.log()
+
+		// Reject new work while draining
+		if abortManager.IsDraining() {
+			log.Println("[PlayFile] Rejected: server is draining")
+			result.fail(errCategoryDraining, errors.New("server is draining"))
+			http.Error(w, "Server is draining", http.StatusServiceUnavailable)
+			return
+		}
+
+		// Check if there's an active op (unless requests may queue for the channel)
+		if cfg.MaxQueue == 0 && abortManager.HasActiveOperation() {
 			log.Println("[PlayFile] Rejected: another session is active")
+			result.fail(errCategoryBusy, errors.New("another session is active"))
 			http.Error(w, "Cannot play file while another session is active", http.StatusConflict)
 			return
 		}
@@ -27,50 +68,123 @@ func HandlePlayFile(hikClient *hikvision.Client, abortManager *AbortManager) htt
 		ctx, cancel := context.WithCancel(r.Context())
 		defer cancel()
 
-		// Register with abort manager
-		op := abortManager.Register(OperationTypePlayFile, cancel)
+		// Register with abort manager, waiting in line if play_file.max_queue allows it
+		var op *Operation
+		if cfg.MaxQueue == 0 {
+			op = abortManager.Register(OperationTypePlayFile, requestSessionID(w, r), cancel)
+		} else {
+			timeout, err := queueTimeout(r, cfg.QueueTimeout)
+			if err != nil {
+				result.fail(errCategoryBadRequest, err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			queueCtx, queueCancel := context.WithTimeout(ctx, timeout)
+			op, err = abortManager.RegisterQueued(queueCtx, OperationTypePlayFile, requestSessionID(w, r), cancel, cfg.MaxQueue)
+			queueCancel()
+			if err != nil {
+				log.Printf("[PlayFile] Rejected while queued: %v", err)
+				switch {
+				case errors.Is(err, ErrQueueFull):
+					result.fail(errCategoryBusy, err)
+					http.Error(w, "Too many play-file requests waiting for the audio channel", http.StatusServiceUnavailable)
+				case errors.Is(err, ErrDraining):
+					result.fail(errCategoryDraining, err)
+					http.Error(w, "Server is draining", http.StatusServiceUnavailable)
+				case ctx.Err() == nil:
+					result.fail(errCategoryTimeout, err)
+					http.Error(w, "Timed out waiting for the audio channel", http.StatusServiceUnavailable)
+				default:
+					result.fail(errCategoryCancelled, err)
+					http.Error(w, "Cancelled while waiting for the audio channel", http.StatusServiceUnavailable)
+				}
+				return
+			}
+		}
 		defer func() {
 			abortManager.Unregister(op)
 			op.Cleanup.Done() // Signal cleanup completion
 		}()
+		result.trackSession(notifier, "play_file", op.SessionID)
 
 		log.Println("[PlayFile] Received request to play audio file")
 
-		// Read uploaded file
-		err := r.ParseMultipartForm(10 << 20) // 10 MB max
-		if err != nil {
-			log.Printf("[PlayFile] Failed to parse multipart form: %v", err)
-			http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		// Read the audio from a multipart upload, or from a JSON body for JSON-only clients
+		audioData, convertUpload, uploadErr := readPlayFileUpload(r, cfg)
+		if uploadErr != nil {
+			log.Printf("[PlayFile] %v", uploadErr)
+			result.fail(errCategoryBadRequest, uploadErr.err)
+			http.Error(w, uploadErr.message, uploadErr.status)
 			return
 		}
 
-		file, _, err := r.FormFile("audio")
+		log.Printf("[PlayFile] Read %d bytes of audio data", len(audioData))
+
+		// loop=true replays until aborted; loop_count=N plays N times
+		plays, err := parseLoop(r)
 		if err != nil {
-			log.Printf("[PlayFile] Failed to get file from form: %v", err)
-			http.Error(w, "No audio file provided", http.StatusBadRequest)
+			log.Printf("[PlayFile] Invalid loop parameters: %v", err)
+			result.fail(errCategoryBadRequest, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		defer file.Close()
 
-		// Read file contents
-		audioData, err := io.ReadAll(file)
+		// record=true captures the microphone on the same session, which needs a
+		// channel that can play and listen at once
+		record, err := parseRecord(r)
 		if err != nil {
-			log.Printf("[PlayFile] Failed to read file: %v", err)
-			http.Error(w, "Failed to read file", http.StatusInternalServerError)
+			log.Printf("[PlayFile] Invalid record parameter: %v", err)
+			result.fail(errCategoryBadRequest, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		dir := recordingDir()
+		if record && dir == "" {
+			err := errors.New("record=true requires server.recording_dir")
+			result.fail(errCategoryBadRequest, err)
+			http.Error(w, "Recording is disabled: server.recording_dir is not set", http.StatusBadRequest)
+			return
+		}
+		if caps := hikClient.Capabilities(); record && caps != nil && !caps.FullDuplex() {
+			err := errors.New("device does not support simultaneous playback and recording")
+			log.Printf("[PlayFile] Rejected: %v", err)
+			result.fail(errCategoryBadRequest, err)
+			http.Error(w, "The doorbell cannot record while playing audio", http.StatusNotImplemented)
 			return
 		}
 
-		log.Printf("[PlayFile] Read %d bytes of audio data", len(audioData))
-
-		sessionManager := session.NewHikvisionSessionManager(hikClient)
+		// Convert, fade and pad the audio before opening a channel
+		prepared, prepareErr := preparePlayFileAudio(ctx, audioData, convertUpload, convertCache, hikClient.Capabilities(), cfg)
+		if prepareErr != nil {
+			log.Printf("[PlayFile] %v", prepareErr)
+			result.fail(prepareErr.category(), prepareErr.err)
+			http.Error(w, prepareErr.message, prepareErr.status)
+			return
+		}
+		audioData, playCodec, conversion := prepared.data, prepared.codec, prepared.conversion
 
-		session, err := sessionManager.AcquireChannel(ctx)
+		mode := session.AudioModeTalk
+		if record {
+			mode = session.AudioModeBoth
+		}
+		session, err := sessionManager.AcquireChannel(ctx, mode, "")
 		if err != nil {
 			log.Printf("[PlayFile] Failed to open audio channel: %v", err)
+			result.fail(deviceErrorCategory(err), err)
+			if writeChannelUnavailable(w, err) {
+				return
+			}
+			if errors.Is(err, hikvision.ErrCircuitOpen) {
+				http.Error(w, "Doorbell unreachable, retrying later", http.StatusServiceUnavailable)
+				return
+			}
 			http.Error(w, fmt.Sprintf("Failed to open audio channel: %v", err), http.StatusInternalServerError)
 			return
 		}
 
+		result.setChannel(session.ChannelID)
+
 		// Ensure we close the channel when done
 		defer func() {
 			log.Println("[PlayFile] Closing audio channel...")
@@ -78,58 +192,531 @@ func HandlePlayFile(hikClient *hikvision.Client, abortManager *AbortManager) htt
 			sessionManager.ReleaseChannel(context.Background(), session.ChannelID)
 		}()
 
+		// The device will misinterpret the data if the channel expects a different codec
+		if session.Codec != "" && session.Codec != playCodec.Name {
+			log.Printf("[PlayFile] Warning: channel %s is configured for %s but play-file codec is %s, audio may be distorted",
+				session.ChannelID, session.Codec, playCodec.Name)
+		}
+
 		// Create audio writer
 		hikvisionSession := hikvision.AudioSession{
 			ChannelID: session.ChannelID,
 			SessionID: session.SessionID,
+			Mode:      hikvision.AudioMode(session.Mode),
+			Codec:     session.Codec,
 		}
 
-		writer := hikClient.NewAudioStreamWriter(&hikvisionSession)
+		writer := hikClient.NewAudioStreamWriter(ctx, &hikvisionSession)
+		writer.SetPacing(!cfg.DisablePacing)
 		writer.Start()
 		defer writer.Close()
 
-		// Send audio data in chunks
+		var recordingID string
+		if record {
+			codecName := session.Codec
+			if codecName == "" {
+				codecName = audio.DefaultCodec
+			}
+			recordCodec, ok := audio.LookupCodec(codecName)
+			if !ok {
+				err := errors.New("unsupported channel codec " + codecName)
+				result.fail(errCategoryDevice, err)
+				http.Error(w, "Cannot record channel audio: "+err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+			rec, err := createRecording(dir, session.ChannelID, recordCodec)
+			if err != nil {
+				log.Printf("[PlayFile] Failed to create recording: %v", err)
+				result.fail(errCategoryInternal, err)
+				http.Error(w, "Failed to create recording", http.StatusInternalServerError)
+				return
+			}
+			recordingID = rec.id
+			log.Printf("[PlayFile] Recording channel %s to %s", session.ChannelID, rec.id)
+
+			reader := hikClient.NewAudioStreamReader(ctx, &hikvisionSession)
+			reader.Start()
+			recorded := make(chan struct{})
+			go func() {
+				defer close(recorded)
+				rec.record(reader)
+			}()
+			defer func() {
+				reader.Close()
+				<-recorded
+			}()
+		}
+
+		// Send audio data in chunks, repeating it for loops
 		chunkSize := 4096
 		totalChunks := (len(audioData) + chunkSize - 1) / chunkSize
-		log.Printf("[PlayFile] Sending %d chunks...", totalChunks)
+		if plays == loopForever {
+			log.Printf("[PlayFile] Sending %d chunks in a loop until aborted...", totalChunks)
+		} else {
+			log.Printf("[PlayFile] Sending %d chunks %d time(s)...", totalChunks, plays)
+		}
 
-		for i := 0; i < len(audioData); i += chunkSize {
-			select {
-			case <-ctx.Done():
+		started := time.Now()
+		played := 0
+		for ; plays == loopForever || played < plays; played++ {
+			if err := ctx.Err(); err != nil {
+				log.Printf("[PlayFile] Interrupted before play %d", played+1)
+				result.fail(errCategoryCancelled, err)
 				http.Error(w, "Operation interrupted", http.StatusServiceUnavailable)
 				return
-			default:
-				end := i + chunkSize
-				if end > len(audioData) {
-					end = len(audioData)
-				}
-
-				chunk := audioData[i:end]
-				_, err := writer.Write(chunk)
-				if err != nil {
-					log.Printf("[PlayFile] Failed to write chunk: %v", err)
+			}
+			for i := 0; i < len(audioData); i += chunkSize {
+				select {
+				case <-ctx.Done():
+					log.Printf("[PlayFile] Interrupted during play %d", played+1)
+					result.fail(errCategoryCancelled, ctx.Err())
+					http.Error(w, "Operation interrupted", http.StatusServiceUnavailable)
+					return
+				case <-writer.Failed():
+					// The device dropped the stream; stop instead of queueing into a dead connection
+					log.Printf("[PlayFile] Audio stream failed after %d of %d chunks: %v", i/chunkSize, totalChunks, writer.Err())
+					result.fail(errCategoryDevice, writer.Err())
+					abortManager.DeviceError(op, session.ChannelID, writer.Err())
 					http.Error(w, "Failed to send audio", http.StatusInternalServerError)
 					return
+				default:
+					end := i + chunkSize
+					if end > len(audioData) {
+						end = len(audioData)
+					}
+
+					chunk := audioData[i:end]
+					_, err := writer.Write(chunk)
+					if err != nil {
+						log.Printf("[PlayFile] Failed to write chunk: %v", err)
+						result.fail(errCategoryDevice, err)
+						abortManager.DeviceError(op, session.ChannelID, err)
+						http.Error(w, "Failed to send audio", http.StatusInternalServerError)
+						return
+					}
+					result.addBytes(int64(len(chunk)), 0)
 				}
 			}
 		}
 
-		log.Println("[PlayFile] All audio data sent")
+		log.Println("[PlayFile] All audio data queued")
+
+		// Up to the writer's whole buffer (many repetitions of a short file) may still be
+		// queued; closing the writer now would drop it
+		if err := writer.Flush(ctx); err != nil {
+			if ctx.Err() != nil {
+				result.fail(errCategoryCancelled, ctx.Err())
+				http.Error(w, "Operation interrupted", http.StatusServiceUnavailable)
+				return
+			}
+			log.Printf("[PlayFile] Audio stream failed during playback: %v", err)
+			result.fail(errCategoryDevice, err)
+			abortManager.DeviceError(op, session.ChannelID, err)
+			http.Error(w, "Failed to send audio", http.StatusInternalServerError)
+			return
+		}
 
-		// Calculate playback duration and wait for audio to finish
-		// G.711 is 8000 bytes/sec
-		audioDuration := time.Duration(len(audioData)) * time.Second / 8000
-		log.Printf("[PlayFile] Waiting %.2f seconds for playback to complete...", audioDuration.Seconds())
+		// Wait out the rest of the playback, which is all of it when the writer doesn't
+		// pace and the device buffers what was pushed
+		audioDuration := playCodec.Duration(len(audioData))
+		remaining := time.Until(started.Add(audioDuration * time.Duration(played)))
+		log.Printf("[PlayFile] All audio data sent, waiting %.2f seconds for playback to complete...", max(remaining, 0).Seconds())
 
 		select {
 		case <-ctx.Done():
+			result.fail(errCategoryCancelled, ctx.Err())
 			http.Error(w, "Operation interrupted", http.StatusServiceUnavailable)
 			return
-		case <-time.After(audioDuration):
+		case <-writer.Failed():
+			log.Printf("[PlayFile] Audio stream failed during playback: %v", writer.Err())
+			result.fail(errCategoryDevice, writer.Err())
+			abortManager.DeviceError(op, session.ChannelID, writer.Err())
+			http.Error(w, "Failed to send audio", http.StatusInternalServerError)
+			return
+		case <-time.After(remaining):
 			log.Println("[PlayFile] Playback complete")
 		}
 
+		// Keep recording for the visitor's response; an abort here only ends it early
+		if record && cfg.RecordTail > 0 {
+			log.Printf("[PlayFile] Recording for %s after playback...", cfg.RecordTail)
+			select {
+			case <-ctx.Done():
+				log.Println("[PlayFile] Recording ended early")
+			case <-time.After(cfg.RecordTail):
+			}
+		}
+
+		// Plain text stays the default for backward compatibility
+		if strings.Contains(r.Header.Get("Accept"), "application/json") {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(PlayFileResponse{
+				ChannelID:       session.ChannelID,
+				SessionID:       session.SessionID,
+				BytesSent:       len(audioData) * played,
+				DurationSeconds: audioDuration.Seconds() * float64(played),
+				Plays:           played,
+				Conversion:      conversion,
+				RecordingID:     recordingID,
+			})
+			return
+		}
+
+		if recordingID != "" {
+			w.Header().Set("X-Recording-ID", recordingID)
+		}
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("Audio played successfully"))
 	}
 }
+
+// playFileAudio is an upload ready to be sent to the device
+type playFileAudio struct {
+	data       []byte
+	codec      audio.Codec
+	conversion *transcode.Report // Set when the upload was converted on the server
+}
+
+// preparePlayFileAudio runs an upload through the play-file pipeline: conversion to the
+// play-file codec (forced for recognized containers), fades and pre-roll silence
+func preparePlayFileAudio(ctx context.Context, audioData []byte, convertUpload bool, convertCache *transcode.Cache, caps *hikvision.DeviceCapabilities, cfg *config.PlayFileConfig) (*playFileAudio, *uploadError) {
+	// Nothing to play; a looped empty file would never yield to an abort
+	if len(audioData) == 0 {
+		return nil, &uploadError{http.StatusBadRequest, "Audio file is empty", errors.New("uploaded audio is empty")}
+	}
+
+	// Recognized containers are converted even if the client didn't ask, since
+	// playing them as raw audio only produces noise
+	container := transcode.Sniff(audioData)
+	if container != transcode.ContainerRaw && !convertUpload {
+		log.Printf("[PlayFile] Detected %s upload, converting it", container)
+		convertUpload = true
+	}
+
+	// Codec is validated at startup; fall back to the default just in case
+	playCodec, ok := audio.LookupCodec(cfg.Codec)
+	if !ok {
+		playCodec, _ = audio.LookupCodec(audio.DefaultCodec)
+	}
+
+	// Catch other formats uploaded as raw audio, which the doorbell plays as static
+	if !convertUpload && cfg.ValidateRaw {
+		if err := audio.ValidateG711(playCodec, audioData); err != nil {
+			message := "Upload is not raw audio: " + err.Error() + ". Set convert=true (or format in a JSON upload) to have the server convert it"
+			return nil, &uploadError{http.StatusBadRequest, message, err}
+		}
+	}
+
+	// Optionally convert any audio format to the play-file codec
+	var conversion *transcode.Report
+	if convertUpload {
+		convert := transcode.ToCodec
+		if convertCache != nil {
+			convert = convertCache.ToCodec
+		}
+
+		if container != transcode.ContainerRaw {
+			if supported, err := transcode.CanDecode(ctx, container); err == nil && !supported {
+				err := fmt.Errorf("unsupported format %s", container)
+				return nil, &uploadError{http.StatusUnsupportedMediaType, fmt.Sprintf("Audio format %s is not supported by the server's ffmpeg", container), err}
+			}
+		}
+
+		// Fall back through play_file.fallback_codecs when ffmpeg can't produce the
+		// configured codec
+		var converted []byte
+		var report *transcode.Report
+		var err error
+		candidates := conversionCodecs(playCodec, cfg.FallbackCodecs, caps)
+		for i, codec := range candidates {
+			converted, report, err = convert(ctx, audioData, codec)
+			if err == nil {
+				if i > 0 {
+					log.Printf("[PlayFile] Converted to fallback codec %s", codec.Name)
+				}
+				playCodec = codec
+				break
+			}
+			if errors.Is(err, transcode.ErrFFmpegNotFound) || ctx.Err() != nil {
+				break
+			}
+			if i+1 < len(candidates) {
+				log.Printf("[PlayFile] Conversion to %s failed, trying %s: %v", codec.Name, candidates[i+1].Name, err)
+			}
+		}
+		if err != nil {
+			if errors.Is(err, transcode.ErrFFmpegNotFound) {
+				return nil, &uploadError{http.StatusNotImplemented, "Server-side conversion is unavailable: ffmpeg is not installed", err}
+			}
+			return nil, &uploadError{http.StatusUnprocessableEntity, "Failed to convert audio: " + err.Error(), err}
+		}
+		if len(converted) == 0 {
+			err := errors.New("conversion produced no audio")
+			return nil, &uploadError{http.StatusUnprocessableEntity, "Failed to convert audio: " + err.Error(), err}
+		}
+
+		log.Printf("[PlayFile] Converted %s %d Hz %d ch (%s) to %s %d Hz mono, downmix: %q, cached: %t",
+			report.InputCodec, report.InputSampleRate, report.InputChannels, report.InputLayout,
+			report.OutputCodec, report.OutputRate, report.Downmix, report.Cached)
+		audioData = converted
+		conversion = report
+	}
+
+	// Soften the start and end of the file; looped plays fade each repetition
+	if cfg.FadeIn > 0 || cfg.FadeOut > 0 {
+		faded, err := audio.ApplyFade(playCodec, audioData, cfg.FadeIn, cfg.FadeOut)
+		if err != nil {
+			log.Printf("[PlayFile] Skipping fade: %v", err)
+		} else {
+			audioData = faded
+		}
+	}
+
+	// Lead with silence so the device's amplifier is on before the audio starts
+	if cfg.PreRoll > 0 {
+		log.Printf("[PlayFile] Prepending %s of silence", cfg.PreRoll)
+		audioData = append(playCodec.SilenceBytes(cfg.PreRoll), audioData...)
+	}
+
+	return &playFileAudio{data: audioData, codec: playCodec, conversion: conversion}, nil
+}
+
+// conversionCodecs lists the codecs to try converting an upload to: the play-file codec,
+// then each fallback the device supports. Fallbacks are only filtered when the device
+// was probed and reported its codecs.
+func conversionCodecs(primary audio.Codec, fallbacks []string, caps *hikvision.DeviceCapabilities) []audio.Codec {
+	codecs := []audio.Codec{primary}
+	for _, name := range fallbacks {
+		codec, ok := audio.LookupCodec(name)
+		if !ok || slices.Contains(codecs, codec) {
+			continue
+		}
+		if caps != nil && len(caps.AudioCodecs) > 0 && !slices.ContainsFunc(caps.AudioCodecs, func(c string) bool { return strings.EqualFold(c, name) }) {
+			log.Printf("[PlayFile] Skipping fallback codec %s: not supported by the doorbell", name)
+			continue
+		}
+		codecs = append(codecs, codec)
+	}
+	return codecs
+}
+
+// readPlayFileUpload reads the audio from a multipart upload, or from a JSON body for
+// JSON-only clients, along with whether the client asked for it to be converted
+func readPlayFileUpload(r *http.Request, cfg *config.PlayFileConfig) ([]byte, bool, *uploadError) {
+	if isJSONContent(r) {
+		return readPlayFileJSON(r, cfg.MaxDecodedBytes, cfg.AllowedTypes)
+	}
+	return readPlayFileForm(r, cfg.AllowedTypes)
+}
+
+// PlayFileJSONRequest is the JSON alternative to a multipart play-file upload
+type PlayFileJSONRequest struct {
+	// AudioBase64 is the standard base64 encoding of the audio file
+	AudioBase64 string `json:"audio_base64"`
+
+	// Format is the file's format (e.g. "mp3", "wav"). Anything other than "raw"
+	// is converted to the play-file codec on the server; empty means raw.
+	Format string `json:"format"`
+}
+
+// uploadError is a play-file input error with the status and message sent to the client
+type uploadError struct {
+	status  int
+	message string
+	err     error
+}
+
+func (e *uploadError) Error() string {
+	return e.message + ": " + e.err.Error()
+}
+
+// category is the audit log error category: the client's fault unless the server
+// cannot convert at all
+func (e *uploadError) category() string {
+	if errors.Is(e.err, transcode.ErrFFmpegNotFound) {
+		return errCategoryInternal
+	}
+	return errCategoryBadRequest
+}
+
+// isJSONContent reports whether the request body is JSON
+func isJSONContent(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/json"
+}
+
+// uploadTypeAllowed checks an upload against play_file.allowed_types, whose entries are
+// MIME types ("audio/*" matches any subtype) and file extensions (".wav"). A file
+// extension, if present, must be listed, and so must a Content-Type other than the
+// generic application/octet-stream. An empty list accepts everything.
+func uploadTypeAllowed(allowed []string, filename, contentType string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+
+	if ext := strings.ToLower(filepath.Ext(filename)); ext != "" && !slices.Contains(allowed, ext) {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType == "application/octet-stream" {
+		return true // No usable type declared, the extension decides
+	}
+	for _, entry := range allowed {
+		if entry == mediaType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(entry, "/*"); ok && strings.HasPrefix(mediaType, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// unsupportedTypeMessage is the 415 response body listing the accepted types
+func unsupportedTypeMessage(allowed []string) string {
+	return "Unsupported file type, accepted types: " + strings.Join(allowed, ", ")
+}
+
+// maxFormFieldBytes caps each non-file field of a multipart upload
+const maxFormFieldBytes = 1 << 10
+
+// readPlayFileForm reads the "audio" file of a multipart upload and its convert flag.
+// Parts are streamed so a file type outside allowedTypes is rejected as soon as its
+// part header arrives, before the file itself is read. Other fields are added to
+// r.Form so FormValue keeps working for them.
+func readPlayFileForm(r *http.Request, allowedTypes []string) ([]byte, bool, *uploadError) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, false, &uploadError{http.StatusBadRequest, "Failed to parse form", err}
+	}
+	if err := r.ParseForm(); err != nil {
+		return nil, false, &uploadError{http.StatusBadRequest, "Failed to parse form", err}
+	}
+
+	var audioData []byte
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if isBodyTooLarge(err) {
+				return nil, false, &uploadError{http.StatusRequestEntityTooLarge, "Audio file too large", err}
+			}
+			return nil, false, &uploadError{http.StatusBadRequest, "Failed to parse form", err}
+		}
+
+		if part.FormName() == "audio" && audioData == nil {
+			contentType := part.Header.Get("Content-Type")
+			if !uploadTypeAllowed(allowedTypes, part.FileName(), contentType) {
+				part.Close()
+				err := fmt.Errorf("file %q of type %q is not an accepted audio type", part.FileName(), contentType)
+				return nil, false, &uploadError{http.StatusUnsupportedMediaType, unsupportedTypeMessage(allowedTypes), err}
+			}
+
+			audioData, err = io.ReadAll(part)
+			part.Close()
+			if err != nil {
+				if isBodyTooLarge(err) {
+					return nil, false, &uploadError{http.StatusRequestEntityTooLarge, "Audio file too large", err}
+				}
+				return nil, false, &uploadError{http.StatusInternalServerError, "Failed to read file", err}
+			}
+			if audioData == nil {
+				audioData = []byte{}
+			}
+			continue
+		}
+
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, maxFormFieldBytes))
+			if err == nil {
+				r.Form.Add(part.FormName(), string(value))
+			}
+		}
+		part.Close()
+	}
+
+	if audioData == nil {
+		return nil, false, &uploadError{http.StatusBadRequest, "No audio file provided", errors.New("no audio part in form")}
+	}
+
+	convert, _ := strconv.ParseBool(r.FormValue("convert"))
+	return audioData, convert, nil
+}
+
+// readPlayFileJSON decodes the base64 audio of a PlayFileJSONRequest, rejecting audio
+// that would decode to more than maxBytes (0 means no limit beyond the body limit)
+func readPlayFileJSON(r *http.Request, maxBytes int64, allowedTypes []string) ([]byte, bool, *uploadError) {
+	var req PlayFileJSONRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if isBodyTooLarge(err) {
+			return nil, false, &uploadError{http.StatusRequestEntityTooLarge, "Audio file too large", err}
+		}
+		return nil, false, &uploadError{http.StatusBadRequest, "Invalid JSON body", err}
+	}
+
+	// The format stands in for a file extension; checked before anything is decoded
+	if req.Format != "" && !uploadTypeAllowed(allowedTypes, "audio."+strings.ToLower(req.Format), "") {
+		err := fmt.Errorf("format %q is not an accepted audio type", req.Format)
+		return nil, false, &uploadError{http.StatusUnsupportedMediaType, unsupportedTypeMessage(allowedTypes), err}
+	}
+
+	if req.AudioBase64 == "" {
+		return nil, false, &uploadError{http.StatusBadRequest, "No audio file provided", errors.New("audio_base64 is empty")}
+	}
+
+	// Check the decoded size up front so an oversized payload is never decoded
+	if decodedLen := int64(base64.StdEncoding.DecodedLen(len(req.AudioBase64))); maxBytes > 0 && decodedLen > maxBytes {
+		err := fmt.Errorf("decoded audio of up to %d bytes exceeds limit of %d", decodedLen, maxBytes)
+		return nil, false, &uploadError{http.StatusRequestEntityTooLarge, "Audio file too large", err}
+	}
+
+	audioData, err := base64.StdEncoding.DecodeString(req.AudioBase64)
+	if err != nil {
+		return nil, false, &uploadError{http.StatusBadRequest, "Invalid audio_base64", err}
+	}
+
+	convert := req.Format != "" && !strings.EqualFold(req.Format, "raw")
+	return audioData, convert, nil
+}
+
+// loopForever is the play count of loop=true without a loop_count
+const loopForever = -1
+
+// parseLoop returns how many times to play the audio from the loop and loop_count
+// parameters: 1 by default, loopForever for loop=true, or loop_count if given
+func parseLoop(r *http.Request) (int, error) {
+	if v := r.FormValue("loop_count"); v != "" {
+		count, err := strconv.Atoi(v)
+		if err != nil || count < 1 {
+			return 0, fmt.Errorf("loop_count must be a positive integer")
+		}
+		return count, nil
+	}
+
+	if v := r.FormValue("loop"); v != "" {
+		loop, err := strconv.ParseBool(v)
+		if err != nil {
+			return 0, fmt.Errorf("loop must be true or false")
+		}
+		if loop {
+			return loopForever, nil
+		}
+	}
+	return 1, nil
+}
+
+// parseRecord reads the record parameter, false by default
+func parseRecord(r *http.Request) (bool, error) {
+	v := r.FormValue("record")
+	if v == "" {
+		return false, nil
+	}
+	record, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("record must be true or false")
+	}
+	return record, nil
+}
@@ -0,0 +1,221 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/acardace/hikvision-doorbell-server/internal/audio"
+	"github.com/acardace/hikvision-doorbell-server/internal/hikvision"
+	"github.com/acardace/hikvision-doorbell-server/internal/session"
+)
+
+// playStreamChunkSize caps how much of the request body is forwarded per write. Writes
+// go out as soon as any data arrives, so small chunks from a live generator are not held back.
+const playStreamChunkSize = 4096
+
+// HandlePlayStream plays audio to the doorbell as the request body arrives, for
+// generators (e.g. live TTS) that cannot produce a whole file up front. The body is raw
+// audio in the play-file codec, typically sent with Transfer-Encoding: chunked. The
+// channel is held until the body ends or the client disconnects.
+func (h *Handler) HandlePlayStream(w http.ResponseWriter, r *http.Request) {
+	cfg := &h.cfg.PlayFile
+	result := newOperationResult("/api/audio/play-stream")
+	defer result.log()
+
+	if h.abortManager.IsDraining() {
+		log.Println("[PlayStream] Rejected: server is draining")
+		result.fail(errCategoryDraining, errors.New("server is draining"))
+		http.Error(w, "Server is draining", http.StatusServiceUnavailable)
+		return
+	}
+
+	if h.abortManager.HasActiveOperation() {
+		log.Println("[PlayStream] Rejected: another session is active")
+		result.fail(errCategoryBusy, errors.New("another session is active"))
+		http.Error(w, "Cannot play stream while another session is active", http.StatusConflict)
+		return
+	}
+
+	// The request context ends when the client disconnects
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	// Registered as a play-file operation so WebRTC and aborts preempt it the same way
+	op := h.abortManager.Register(OperationTypePlayFile, requestSessionID(w, r), cancel)
+	defer func() {
+		h.abortManager.Unregister(op)
+		op.Cleanup.Done()
+	}()
+	result.trackSession(h.notifier, "play_stream", op.SessionID)
+
+	// Codec is validated at startup; fall back to the default just in case
+	playCodec, ok := audio.LookupCodec(cfg.Codec)
+	if !ok {
+		playCodec, _ = audio.LookupCodec(audio.DefaultCodec)
+	}
+
+	sess, err := h.sessionManager.AcquireChannel(ctx, session.AudioModeTalk, "")
+	if err != nil {
+		log.Printf("[PlayStream] Failed to open audio channel: %v", err)
+		result.fail(deviceErrorCategory(err), err)
+		if writeChannelUnavailable(w, err) {
+			return
+		}
+		if errors.Is(err, hikvision.ErrCircuitOpen) {
+			http.Error(w, "Doorbell unreachable, retrying later", http.StatusServiceUnavailable)
+			return
+		}
+		http.Error(w, fmt.Sprintf("Failed to open audio channel: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		log.Println("[PlayStream] Closing audio channel...")
+		h.sessionManager.ReleaseChannel(context.Background(), sess.ChannelID)
+	}()
+	result.setChannel(sess.ChannelID)
+
+	if sess.Codec != "" && sess.Codec != playCodec.Name {
+		log.Printf("[PlayStream] Warning: channel %s is configured for %s but play-file codec is %s, audio may be distorted",
+			sess.ChannelID, sess.Codec, playCodec.Name)
+	}
+
+	writer := h.hikClient.NewAudioStreamWriter(ctx, &hikvision.AudioSession{
+		ChannelID: sess.ChannelID,
+		SessionID: sess.SessionID,
+		Mode:      hikvision.AudioMode(sess.Mode),
+		Codec:     sess.Codec,
+	})
+	writer.SetPacing(!cfg.DisablePacing)
+	writer.Start()
+	defer writer.Close()
+
+	log.Printf("[PlayStream] Streaming %s from %s to channel %s", playCodec.Name, r.RemoteAddr, sess.ChannelID)
+
+	// The body is read on its own goroutine so an abort or device failure is noticed
+	// while waiting on a slow generator
+	chunks := make(chan []byte)
+	readErr := make(chan error, 1)
+	stop := make(chan struct{})
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		defer close(chunks)
+		for {
+			buffer := make([]byte, playStreamChunkSize)
+			n, err := r.Body.Read(buffer)
+			if n > 0 {
+				select {
+				case chunks <- buffer[:n]:
+				case <-stop:
+					return
+				case <-ctx.Done():
+					readErr <- ctx.Err()
+					return
+				}
+			}
+			if err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+
+	// The body can't be read once the handler returns, so a read still blocked on the
+	// client is cut short and the reader waited for
+	defer func() {
+		close(stop)
+		select {
+		case <-readDone:
+			return
+		default:
+		}
+		if err := http.NewResponseController(w).SetReadDeadline(time.Now()); err != nil {
+			r.Body.Close()
+		}
+		<-readDone
+	}()
+
+	// playbackEnd estimates when the device finishes what was sent so far; gaps in the
+	// stream push it out, since the device plays nothing while waiting for data
+	var playbackEnd time.Time
+	sent := 0
+stream:
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("[PlayStream] Interrupted after %d bytes", sent)
+			result.fail(errCategoryCancelled, ctx.Err())
+			http.Error(w, "Operation interrupted", http.StatusServiceUnavailable)
+			return
+		case <-writer.Failed():
+			// The device dropped the stream; stop instead of queueing into a dead connection
+			log.Printf("[PlayStream] Audio stream failed after %d bytes: %v", sent, writer.Err())
+			result.fail(errCategoryDevice, writer.Err())
+			h.abortManager.DeviceError(op, sess.ChannelID, writer.Err())
+			http.Error(w, "Failed to send audio", http.StatusInternalServerError)
+			return
+		case chunk, ok := <-chunks:
+			if !ok {
+				break stream
+			}
+			if _, err := writer.Write(chunk); err != nil {
+				log.Printf("[PlayStream] Failed to write chunk: %v", err)
+				result.fail(errCategoryDevice, err)
+				h.abortManager.DeviceError(op, sess.ChannelID, err)
+				http.Error(w, "Failed to send audio", http.StatusInternalServerError)
+				return
+			}
+			playbackEnd = later(playbackEnd, time.Now()).Add(playCodec.Duration(len(chunk)))
+			sent += len(chunk)
+			result.addBytes(int64(len(chunk)), 0)
+		}
+	}
+
+	if err := <-readErr; ctx.Err() != nil {
+		log.Printf("[PlayStream] Interrupted after %d bytes", sent)
+		result.fail(errCategoryCancelled, ctx.Err())
+		http.Error(w, "Operation interrupted", http.StatusServiceUnavailable)
+		return
+	} else if !errors.Is(err, io.EOF) {
+		// A dropped connection surfaces here as a read error rather than a cancelled context
+		log.Printf("[PlayStream] Stream ended early after %d bytes: %v", sent, err)
+		result.fail(errCategoryCancelled, err)
+		http.Error(w, "Failed to read audio stream", http.StatusBadRequest)
+		return
+	}
+
+	// Hold the channel until what was sent has had time to play
+	remaining := max(time.Until(playbackEnd), 0)
+	log.Printf("[PlayStream] Stream ended after %d bytes, waiting %.2f seconds for playback to complete...", sent, remaining.Seconds())
+
+	select {
+	case <-ctx.Done():
+		result.fail(errCategoryCancelled, ctx.Err())
+		http.Error(w, "Operation interrupted", http.StatusServiceUnavailable)
+		return
+	case <-writer.Failed():
+		log.Printf("[PlayStream] Audio stream failed during playback: %v", writer.Err())
+		result.fail(errCategoryDevice, writer.Err())
+		h.abortManager.DeviceError(op, sess.ChannelID, writer.Err())
+		http.Error(w, "Failed to send audio", http.StatusInternalServerError)
+		return
+	case <-time.After(remaining):
+		log.Println("[PlayStream] Playback complete")
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Audio stream played successfully"))
+}
+
+// later returns the later of two times
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// setAudioPtime returns sdp with an a=ptime line on every audio media section, replacing
+// any already there, so the client expects packets of that duration from the server
+func setAudioPtime(sdp string, ptime time.Duration) string {
+	attribute := fmt.Sprintf("a=ptime:%d", ptime.Milliseconds())
+
+	lines := strings.Split(strings.TrimSuffix(sdp, "\r\n"), "\r\n")
+	out := make([]string, 0, len(lines)+2)
+	inAudio := false
+	for _, line := range lines {
+		if strings.HasPrefix(line, "m=") {
+			if inAudio {
+				out = append(out, attribute)
+			}
+			inAudio = strings.HasPrefix(line, "m=audio")
+			out = append(out, line)
+			continue
+		}
+		if inAudio && strings.HasPrefix(line, "a=ptime:") {
+			continue
+		}
+		out = append(out, line)
+	}
+	if inAudio {
+		out = append(out, attribute)
+	}
+
+	return strings.Join(out, "\r\n") + "\r\n"
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// ErrQueueFull is returned by RegisterQueued when the wait queue is at its limit
+var ErrQueueFull = errors.New("queue is full")
+
+// ErrDraining is returned by RegisterQueued when drain mode starts, or has started,
+// before the request is admitted
+var ErrDraining = errors.New("server is draining")
+
+// queuedOperation is a request waiting for the active operations to finish
+type queuedOperation struct {
+	opType    OperationType
+	sessionID string
+	cancel    context.CancelFunc
+	ready     chan struct{} // Closed once op is registered or err is set
+	op        *Operation
+	err       error // Why the request was turned away while waiting
+}
+
+// RegisterQueued registers an operation like Register, but only once no other
+// operation is active. Until then the request waits in FIFO order behind at most
+// maxQueue others; if that many are already waiting it fails with ErrQueueFull. It
+// returns ctx's error if ctx ends first, so callers bound the wait with a timeout.
+func (am *AbortManager) RegisterQueued(ctx context.Context, opType OperationType, sessionID string, cancel context.CancelFunc, maxQueue int) (*Operation, error) {
+	am.mu.Lock()
+	if am.draining {
+		am.mu.Unlock()
+		return nil, ErrDraining
+	}
+	if len(am.activeOps) == 0 && len(am.queue) == 0 {
+		op := am.registerLocked(opType, sessionID, cancel)
+		am.mu.Unlock()
+		return op, nil
+	}
+	if len(am.queue) >= maxQueue {
+		am.mu.Unlock()
+		return nil, ErrQueueFull
+	}
+
+	q := &queuedOperation{
+		opType:    opType,
+		sessionID: sessionID,
+		cancel:    cancel,
+		ready:     make(chan struct{}),
+	}
+	am.queue = append(am.queue, q)
+	log.Printf("[AbortManager] Queued operation (type: %d, session: %s, position: %d)", opType, sessionID, len(am.queue))
+	am.mu.Unlock()
+
+	select {
+	case <-q.ready:
+		return q.op, q.err
+	case <-ctx.Done():
+	}
+
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	if q.err != nil {
+		return nil, q.err
+	}
+	if q.op != nil {
+		// Admitted just as the wait ended; give the slot to the next in line
+		am.removeLocked(q.op)
+		q.op.Cleanup.Done()
+		am.admitNextLocked()
+		return nil, ctx.Err()
+	}
+	for i, queued := range am.queue {
+		if queued == q {
+			am.queue = append(am.queue[:i], am.queue[i+1:]...)
+			break
+		}
+	}
+	log.Printf("[AbortManager] Queued operation for session %s left the queue: %v", sessionID, ctx.Err())
+	return nil, ctx.Err()
+}
+
+// admitNextLocked registers the first queued request once nothing is active.
+// Nothing is admitted while draining. Caller must hold mu.
+func (am *AbortManager) admitNextLocked() {
+	if am.draining || len(am.activeOps) > 0 || len(am.queue) == 0 {
+		return
+	}
+
+	q := am.queue[0]
+	am.queue = am.queue[1:]
+	q.op = am.registerLocked(q.opType, q.sessionID, q.cancel)
+	close(q.ready)
+}
+
+// rejectQueuedLocked turns away every queued request with err. Caller must hold mu.
+func (am *AbortManager) rejectQueuedLocked(err error) {
+	for _, q := range am.queue {
+		q.err = err
+		close(q.ready)
+	}
+	if len(am.queue) > 0 {
+		log.Printf("[AbortManager] Rejected %d queued operation(s): %v", len(am.queue), err)
+	}
+	am.queue = nil
+}
+
+// CancelQueued cancels the queued requests of sessionID and returns how many there were
+func (am *AbortManager) CancelQueued(sessionID string) int {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	cancelled := 0
+	for _, q := range am.queue {
+		if q.sessionID == sessionID {
+			q.cancel()
+			cancelled++
+		}
+	}
+	return cancelled
+}
+
+// QueueLength returns the number of requests waiting for the active operations to finish
+func (am *AbortManager) QueueLength() int {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	return len(am.queue)
+}
+
+// queueTimeout returns how long a play-file request may wait in the queue: the
+// queue_timeout query parameter (a duration such as "10s"), else the configured default.
+// The query string is used rather than the form so the body is not read while queued.
+func queueTimeout(r *http.Request, defaultTimeout time.Duration) (time.Duration, error) {
+	value := r.URL.Query().Get("queue_timeout")
+	if value == "" {
+		return defaultTimeout, nil
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid queue_timeout %q: must be a positive duration such as 10s", value)
+	}
+	return timeout, nil
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/acardace/hikvision-doorbell-server/internal/audio"
+	"github.com/acardace/hikvision-doorbell-server/internal/hikvision"
+)
+
+// wavRecorder writes device audio to a WAV file in the recording directory. The
+// header is written with an unknown length and patched with the real sizes on close,
+// so a recording cut short by a crash still plays.
+type wavRecorder struct {
+	id    string
+	file  *os.File
+	bytes int64
+}
+
+// createRecording creates a recording for channelID named like the ones listed by
+// /api/recordings ("<time>-ch<id>.wav")
+func createRecording(dir, channelID string, codec audio.Codec) (*wavRecorder, error) {
+	id := fmt.Sprintf("%s-ch%s.wav", time.Now().Format("20060102-150405"), channelID)
+	if !validRecordingID(id) {
+		return nil, fmt.Errorf("invalid recording name %q", id)
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, id), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Write(codec.WAVHeader(audio.WAVStreamingSize)); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return &wavRecorder{id: id, file: file}, nil
+}
+
+// record copies audio from reader until it ends, then finalizes the file
+func (rec *wavRecorder) record(reader hikvision.StreamReader) {
+	buffer := make([]byte, audio.SampleSize)
+	for {
+		n, err := reader.Read(buffer)
+		if n > 0 {
+			if _, werr := rec.file.Write(buffer[:n]); werr != nil {
+				log.Printf("[PlayFile] Failed to write recording %s: %v", rec.id, werr)
+				break
+			}
+			rec.bytes += int64(n)
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Printf("[PlayFile] Recording %s stopped: %v", rec.id, err)
+			}
+			break
+		}
+	}
+
+	if err := rec.close(); err != nil {
+		log.Printf("[PlayFile] Failed to finalize recording %s: %v", rec.id, err)
+		return
+	}
+	log.Printf("[PlayFile] Saved recording %s (%d bytes)", rec.id, rec.bytes)
+}
+
+// close writes the final RIFF and data sizes into the header and closes the file
+func (rec *wavRecorder) close() error {
+	defer rec.file.Close()
+
+	if rec.bytes < audio.WAVStreamingSize-44 {
+		var size [4]byte
+		binary.LittleEndian.PutUint32(size[:], uint32(rec.bytes+36))
+		if _, err := rec.file.WriteAt(size[:], 4); err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint32(size[:], uint32(rec.bytes))
+		if _, err := rec.file.WriteAt(size[:], 40); err != nil {
+			return err
+		}
+	}
+	return rec.file.Close()
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/acardace/hikvision-doorbell-server/internal/audio"
+	"github.com/gorilla/mux"
+)
+
+// RecordingResponse describes a recording in server.recording_dir
+type RecordingResponse struct {
+	ID              string    `json:"id"`
+	StartedAt       time.Time `json:"started_at"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	ChannelID       string    `json:"channel_id,omitempty"`
+	SizeBytes       int64     `json:"size_bytes"`
+}
+
+// recordingDir returns the configured recording directory, or "" if recordings are disabled
+func (h *Handler) recordingDir() string {
+	h.cfgMu.RLock()
+	defer h.cfgMu.RUnlock()
+	return h.cfg.Server.RecordingDir
+}
+
+// HandleListRecordings lists the WAV recordings in server.recording_dir, newest first.
+// ?limit=N returns only the N most recent.
+func (h *Handler) HandleListRecordings(w http.ResponseWriter, r *http.Request) {
+	dir := h.recordingDir()
+	if dir == "" {
+		http.Error(w, "Recordings disabled", http.StatusNotFound)
+		return
+	}
+
+	limit := 0
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid limit: must be a positive number", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Printf("[Recordings] Failed to read %s: %v", dir, err)
+		http.Error(w, "Failed to list recordings", http.StatusInternalServerError)
+		return
+	}
+
+	recordings := []RecordingResponse{}
+	for _, entry := range entries {
+		if !validRecordingID(entry.Name()) || !entry.Type().IsRegular() {
+			continue
+		}
+		rec, err := readRecording(dir, entry.Name())
+		if err != nil {
+			log.Printf("[Recordings] Skipping %s: %v", entry.Name(), err)
+			continue
+		}
+		recordings = append(recordings, rec)
+	}
+
+	sort.Slice(recordings, func(i, j int) bool {
+		return recordings[i].StartedAt.After(recordings[j].StartedAt)
+	})
+	if limit > 0 && len(recordings) > limit {
+		recordings = recordings[:limit]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recordings)
+}
+
+// HandleDownloadRecording serves one recording as a WAV file
+func (h *Handler) HandleDownloadRecording(w http.ResponseWriter, r *http.Request) {
+	dir := h.recordingDir()
+	if dir == "" {
+		http.Error(w, "Recordings disabled", http.StatusNotFound)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	if !validRecordingID(id) {
+		log.Printf("[Recordings] Rejected invalid recording ID %q", id)
+		http.Error(w, "Invalid recording ID", http.StatusBadRequest)
+		return
+	}
+
+	path := filepath.Join(dir, id)
+	info, err := os.Lstat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		http.Error(w, "Recording not found", http.StatusNotFound)
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		log.Printf("[Recordings] Failed to open %s: %v", path, err)
+		http.Error(w, "Failed to open recording", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	log.Printf("[Recordings] Serving %s (%d bytes)", id, info.Size())
+	w.Header().Set("Content-Type", "audio/wav")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+id+`"`)
+	http.ServeContent(w, r, id, info.ModTime(), f)
+}
+
+// validRecordingID reports whether id names a WAV file directly inside the recording
+// directory. IDs are plain file names, so anything with a path separator, a leading
+// dot or ".." is rejected before it reaches the filesystem.
+func validRecordingID(id string) bool {
+	return strings.HasSuffix(id, ".wav") &&
+		!strings.HasPrefix(id, ".") &&
+		!strings.ContainsAny(id, `/\`+"\x00\"") &&
+		filepath.Base(id) == id
+}
+
+// readRecording builds the listing entry for a recording from its WAV header and file
+// times. Recordings are written as they happen, so the file's modification time marks
+// the end and the start is derived from the duration. A "-ch<id>" suffix on the file
+// name gives the channel.
+func readRecording(dir, id string) (RecordingResponse, error) {
+	f, err := os.Open(filepath.Join(dir, id))
+	if err != nil {
+		return RecordingResponse{}, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return RecordingResponse{}, err
+	}
+	wav, err := audio.ReadWAVInfo(f)
+	if err != nil {
+		return RecordingResponse{}, err
+	}
+
+	duration := wav.Duration()
+	rec := RecordingResponse{
+		ID:              id,
+		StartedAt:       stat.ModTime().Add(-duration).UTC(),
+		DurationSeconds: duration.Seconds(),
+		SizeBytes:       stat.Size(),
+	}
+	name := strings.TrimSuffix(id, ".wav")
+	if i := strings.LastIndex(name, "-ch"); i >= 0 && i+3 < len(name) {
+		rec.ChannelID = name[i+3:]
+	}
+	return rec, nil
+}
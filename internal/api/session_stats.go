@@ -0,0 +1,53 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// SessionStatsResponse reports the audio counters of the active WebRTC session
+type SessionStatsResponse struct {
+	SessionID      string  `json:"session_id"`
+	ChannelID      string  `json:"channel_id"`
+	BytesSent      int64   `json:"bytes_sent"`      // Client audio written to the doorbell
+	BytesReceived  int64   `json:"bytes_received"`  // Doorbell audio read for the client
+	Underruns      int64   `json:"underruns"`       // Times the client ran out of doorbell audio
+	SilenceSeconds float64 `json:"silence_seconds"` // Total length of those gaps
+	ReaderRestarts int64   `json:"reader_restarts"` // Times the stalled doorbell audio stream was reconnected
+}
+
+// SessionStats returns the counters of the active WebRTC session. ok is false if no
+// session is streaming audio.
+func (h *WebRTCHandler) SessionStats() (stats SessionStatsResponse, ok bool) {
+	h.sessionMu.Lock()
+	defer h.sessionMu.Unlock()
+
+	if h.audioStreamer == nil || h.activeSession == nil || h.activeOp == nil {
+		return SessionStatsResponse{}, false
+	}
+
+	sent, received := h.audioStreamer.BytesTransferred()
+	underruns, silence := h.audioStreamer.Underruns()
+	return SessionStatsResponse{
+		SessionID:      h.activeOp.SessionID,
+		ChannelID:      h.activeSession.ChannelID,
+		BytesSent:      sent,
+		BytesReceived:  received,
+		Underruns:      underruns,
+		SilenceSeconds: silence.Seconds(),
+		ReaderRestarts: h.audioStreamer.ReaderRestarts(),
+	}, true
+}
+
+// HandleSessionStats reports the audio counters of the active WebRTC session, including
+// gaps in the doorbell audio sent to the browser
+func (h *Handler) HandleSessionStats(w http.ResponseWriter, r *http.Request) {
+	stats, ok := h.webrtcHandler.SessionStats()
+	if !ok {
+		http.Error(w, "No active audio session", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/acardace/hikvision-doorbell-server/internal/hikvision"
+)
+
+// StatusResponse summarizes the server's view of the doorbell
+type StatusResponse struct {
+	Draining         bool                        `json:"draining"`
+	ActiveOperations int                         `json:"active_operations"`
+	QueuedRequests   int                         `json:"queued_requests"`
+	CircuitBreaker   CircuitBreakerStatus        `json:"circuit_breaker"`
+	Capabilities     *DeviceCapabilitiesResponse `json:"capabilities"` // null if the device was not probed
+	Call             *CallInfo                   `json:"call"`         // Active or last WebRTC call, null before the first
+}
+
+// CircuitBreakerStatus reports whether device requests are being short-circuited
+type CircuitBreakerStatus struct {
+	Open           bool    `json:"open"`
+	Failures       int     `json:"failures"`
+	RetryInSeconds float64 `json:"retry_in_seconds,omitempty"`
+}
+
+// DeviceCapabilitiesResponse lists the features detected when the device was probed at startup
+type DeviceCapabilitiesResponse struct {
+	TwoWayAudio   bool      `json:"two_way_audio"`
+	AudioChannels int       `json:"audio_channels"`
+	AudioCodecs   []string  `json:"audio_codecs"`
+	AudioInputs   int       `json:"audio_inputs,omitempty"`
+	AudioOutputs  int       `json:"audio_outputs,omitempty"`
+	Video         bool      `json:"video"`
+	Snapshot      bool      `json:"snapshot"`
+	Events        bool      `json:"events"`
+	VideoIntercom bool      `json:"video_intercom"`
+	DoorUnlock    bool      `json:"door_unlock"`
+	ProbedAt      time.Time `json:"probed_at"`
+}
+
+// HandleStatus reports drain mode, the play-file queue, the circuit breaker, the device's capabilities
+// and the WebRTC call without contacting the device
+func (h *Handler) HandleStatus(w http.ResponseWriter, r *http.Request) {
+	breaker := h.hikClient.BreakerState()
+	resp := StatusResponse{
+		Draining:         h.abortManager.IsDraining(),
+		ActiveOperations: h.abortManager.ActiveOperationCount(),
+		QueuedRequests:   h.abortManager.QueueLength(),
+		CircuitBreaker: CircuitBreakerStatus{
+			Open:           breaker.Open,
+			Failures:       breaker.Failures,
+			RetryInSeconds: breaker.RetryIn.Seconds(),
+		},
+		Capabilities: capabilitiesResponse(h.hikClient.Capabilities()),
+		Call:         h.webrtcHandler.CurrentCall(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func capabilitiesResponse(caps *hikvision.DeviceCapabilities) *DeviceCapabilitiesResponse {
+	if caps == nil {
+		return nil
+	}
+
+	codecs := caps.AudioCodecs
+	if codecs == nil {
+		codecs = []string{}
+	}
+	return &DeviceCapabilitiesResponse{
+		TwoWayAudio:   caps.TwoWayAudio,
+		AudioChannels: caps.AudioChannels,
+		AudioCodecs:   codecs,
+		AudioInputs:   caps.AudioInputs,
+		AudioOutputs:  caps.AudioOutputs,
+		Video:         caps.Video,
+		Snapshot:      caps.Snapshot,
+		Events:        caps.Events,
+		VideoIntercom: caps.VideoIntercom,
+		DoorUnlock:    caps.DoorUnlock,
+		ProbedAt:      caps.ProbedAt,
+	}
+}
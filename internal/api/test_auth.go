@@ -0,0 +1,43 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/acardace/hikvision-doorbell-server/internal/hikvision"
+)
+
+// testAuthTimeout bounds the single request made by /api/device/test-auth
+const testAuthTimeout = 10 * time.Second
+
+// TestAuthResponse reports whether the doorbell accepted the configured credentials
+type TestAuthResponse struct {
+	Result hikvision.AuthResult `json:"result"` // ok, unauthorized, unreachable or device_error
+	Error  string               `json:"error,omitempty"`
+}
+
+// HandleTestAuth checks the configured credentials with one read-only request, telling
+// rejected credentials apart from an unreachable doorbell. Nothing on the device changes.
+func (h *Handler) HandleTestAuth(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), testAuthTimeout)
+	defer cancel()
+
+	result, err := h.hikClient.TestAuth(ctx)
+	resp := TestAuthResponse{Result: result}
+	status := http.StatusOK
+	if err != nil {
+		resp.Error = err.Error()
+		status = http.StatusBadGateway
+		if result == hikvision.AuthUnreachable {
+			status = http.StatusServiceUnavailable
+		}
+	}
+	log.Printf("[TestAuth] Result: %s", result)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// VideoStreamResponse describes a video stream the doorbell serves over RTSP
+type VideoStreamResponse struct {
+	ID        string  `json:"id"`
+	Name      string  `json:"name,omitempty"`
+	Codec     string  `json:"codec,omitempty"`
+	Width     int     `json:"width,omitempty"`
+	Height    int     `json:"height,omitempty"`
+	FrameRate float64 `json:"frame_rate,omitempty"`
+	RTSPURL   string  `json:"rtsp_url"`
+}
+
+// HandleVideoStreams lists the doorbell's video streams so players can connect to
+// them directly; the server does not proxy video
+func (h *Handler) HandleVideoStreams(w http.ResponseWriter, r *http.Request) {
+	// Skip the round trip if the startup probe found no video
+	if caps := h.hikClient.Capabilities(); caps != nil && !caps.Video {
+		http.Error(w, "Video streaming is not supported by this device", http.StatusNotFound)
+		return
+	}
+
+	streams, err := h.hikClient.GetVideoStreams(r.Context())
+	if err != nil {
+		log.Printf("[Video] Failed to list video streams: %v", err)
+		http.Error(w, "Failed to list video streams", http.StatusBadGateway)
+		return
+	}
+
+	resp := make([]VideoStreamResponse, 0, len(streams))
+	for _, s := range streams {
+		resp = append(resp, VideoStreamResponse{
+			ID:        s.ID,
+			Name:      s.Name,
+			Codec:     s.Codec,
+			Width:     s.Width,
+			Height:    s.Height,
+			FrameRate: s.FrameRate,
+			RTSPURL:   s.RTSPURL,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
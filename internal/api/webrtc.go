@@ -3,32 +3,52 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/acardace/hikvision-doorbell-server/internal/audio"
 	"github.com/acardace/hikvision-doorbell-server/internal/hikvision"
+	"github.com/acardace/hikvision-doorbell-server/internal/lifecycle"
 	"github.com/acardace/hikvision-doorbell-server/internal/logger"
+	"github.com/acardace/hikvision-doorbell-server/internal/retry"
 	"github.com/acardace/hikvision-doorbell-server/internal/session"
 	"github.com/acardace/hikvision-doorbell-server/internal/streaming"
+	"github.com/acardace/hikvision-doorbell-server/internal/webhook"
 	"github.com/pion/webrtc/v4"
 )
 
+// deviceRequestTimeout bounds channel discovery and session open when handling an offer
+const deviceRequestTimeout = 5 * time.Second
+
 type WebRTCHandler struct {
 	config         *WebRTCConfig
-	hikClient      *hikvision.Client
+	hikClient      hikvision.DeviceClient
 	sessionManager session.SessionManager
 	audioStreamer  streaming.AudioStreamer
 	abortManager   *AbortManager
 	peerConnection *webrtc.PeerConnection
 	activeSession  *session.AudioSession
-	activeOp       *Operation // Track active WebRTC operation
-	mu             sync.Mutex
+	activeOp       *Operation         // Track active WebRTC operation
+	activeResult   *operationResult   // Audit record for the active session, logged on cleanup
+	call           *Call              // Active call, or the last one after it ends
+	mu             sync.Mutex         // Serializes offers, reloads and Close
 	cancelFunc     context.CancelFunc // Cancel function for goroutines
+	notifier       *webhook.Notifier  // Session start/end webhooks (nil disables)
+	tasks          *lifecycle.Group   // Streaming and keep-alive goroutines of sessions
+
+	// sessionMu guards the active session's resources above and serializes cleanup,
+	// which is triggered from HandleOffer, pion callbacks and streaming goroutines
+	sessionMu sync.Mutex
 }
 
-func NewWebRTCHandler(hikClient *hikvision.Client, sessionManager session.SessionManager, abortManager *AbortManager) *WebRTCHandler {
+func NewWebRTCHandler(hikClient hikvision.DeviceClient, sessionManager session.SessionManager, abortManager *AbortManager, notifier *webhook.Notifier) *WebRTCHandler {
 	config := NewWebRTCConfig()
 	config.LoadFromEnv()
 
@@ -37,6 +57,8 @@ func NewWebRTCHandler(hikClient *hikvision.Client, sessionManager session.Sessio
 		hikClient:      hikClient,
 		sessionManager: sessionManager,
 		abortManager:   abortManager,
+		notifier:       notifier,
+		tasks:          lifecycle.New(),
 	}
 }
 
@@ -45,9 +67,22 @@ func (h *WebRTCHandler) HandleOffer(w http.ResponseWriter, r *http.Request) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
+	result := newOperationResult("/api/webrtc/offer")
+
+	// Reject new sessions while draining
+	if h.abortManager.IsDraining() {
+		logger.Log.Warn("rejected WebRTC offer: server is draining", slog.String("component", "webrtc"))
+		result.fail(errCategoryDraining, errors.New("server is draining"))
+		result.log()
+		http.Error(w, "Server is draining", http.StatusServiceUnavailable)
+		return
+	}
+
 	// Check if there's already an active WebRTC session
 	if h.abortManager.HasActiveWebRTC() {
 		logger.Log.Warn("rejected WebRTC offer: session already active", slog.String("component", "webrtc"))
+		result.fail(errCategoryBusy, errors.New("session already active"))
+		result.log()
 		http.Error(w, "WebRTC session already active", http.StatusConflict)
 		return
 	}
@@ -55,11 +90,68 @@ func (h *WebRTCHandler) HandleOffer(w http.ResponseWriter, r *http.Request) {
 	// Create context for managing goroutines lifecycle
 	// Use Background() instead of r.Context() so streaming continues after HTTP handler returns
 	ctx, cancel := context.WithCancel(context.Background())
-	h.cancelFunc = cancel
 
 	// Register WebRTC operation with abort manager FIRST
 	// This ensures AbortPlayFileOperations won't affect this WebRTC session
-	h.activeOp = h.abortManager.Register(OperationTypeWebRTC, cancel)
+	op := h.abortManager.Register(OperationTypeWebRTC, requestSessionID(w, r), cancel)
+	// The session outlives this handler, so device errors tear it down directly
+	op.teardown = func() { h.cleanupSession(op) }
+	// Cancellation (abort, shutdown) tears the session down too. Only the
+	// client-to-device goroutine would otherwise clean up, and a listen-only session
+	// never starts it, leaving aborts waiting on op.Cleanup forever.
+	context.AfterFunc(ctx, func() { h.cleanupSession(op) })
+	result.trackSession(h.notifier, "webrtc", op.SessionID)
+	call := newCall(op.SessionID, h.notifier)
+
+	h.sessionMu.Lock()
+	h.cancelFunc = cancel
+	h.activeOp = op
+	h.activeResult = result
+	h.sessionMu.Unlock()
+
+	// Tear everything down if we fail before sending an answer
+	answered := false
+	defer func() {
+		if !answered {
+			h.cleanupSession(op)
+		}
+	}()
+
+	// Optional per-session codec override (?codec=PCMA or X-Audio-Codec header)
+	pcConfig := h.config
+	codecOverride, err := h.codecOverride(r)
+	if err != nil {
+		logger.Log.Warn("rejected WebRTC offer: invalid codec override",
+			slog.String("component", "webrtc"),
+			slog.String("error", err.Error()))
+		result.fail(errCategoryBadRequest, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if codecOverride != nil {
+		logger.Log.Info("using codec override for this session",
+			slog.String("component", "webrtc"),
+			slog.String("codec", codecOverride.RTPName))
+		pcConfig = h.config.WithCodecs([]audio.Codec{*codecOverride})
+	}
+
+	// Optional per-session bandwidth cap (?max_bitrate=<kbps> or X-Max-Bitrate header)
+	maxBitrate, err := h.bitrateCap(r, pcConfig.MaxBitrate)
+	if err != nil {
+		logger.Log.Warn("rejected WebRTC offer: invalid bandwidth cap",
+			slog.String("component", "webrtc"),
+			slog.String("error", err.Error()))
+		result.fail(errCategoryBadRequest, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Optional microphone selection (?audio_input=<id> or X-Audio-Input header) for
+	// devices with several inputs; the channel bound to that input is opened
+	audioInputID := r.URL.Query().Get("audio_input")
+	if audioInputID == "" {
+		audioInputID = r.Header.Get("X-Audio-Input")
+	}
 
 	// Abort any ongoing play-file operations to free up the channel
 	// WebRTC connections take precedence
@@ -72,6 +164,11 @@ func (h *WebRTCHandler) HandleOffer(w http.ResponseWriter, r *http.Request) {
 		logger.Log.Error("failed to decode SDP offer",
 			slog.String("component", "webrtc"),
 			slog.String("error", err.Error()))
+		result.fail(errCategoryBadRequest, err)
+		if isBodyTooLarge(err) {
+			http.Error(w, "Offer too large", http.StatusRequestEntityTooLarge)
+			return
+		}
 		http.Error(w, "Invalid offer", http.StatusBadRequest)
 		return
 	}
@@ -79,19 +176,91 @@ func (h *WebRTCHandler) HandleOffer(w http.ResponseWriter, r *http.Request) {
 	logger.Log.Info("received SDP offer",
 		slog.String("component", "webrtc"),
 		slog.String("type", offer.Type.String()))
+	logger.Log.Debug("SDP offer contents",
+		slog.String("component", "webrtc"),
+		slog.String("sdp", offer.SDP))
 
-	// Create peer connection using configuration
-	peerConnection, err := h.config.CreatePeerConnection()
+	// Listen-only clients offer recvonly and never send a track
+	clientSends := offerSendsAudio(offer)
+	logger.Log.Info("client audio direction",
+		slog.String("component", "webrtc"),
+		slog.Bool("client_sends_audio", clientSends))
+
+	// Devices that separate monitor from talk only carry audio in the opened direction,
+	// so a recvonly offer opens the channel listen-only
+	mode := session.AudioModeBoth
+	if !clientSends {
+		mode = session.AudioModeListen
+	}
+
+	// Acquire the doorbell channel before answering so an unreachable or busy
+	// device fails fast instead of leaving the client waiting
+	logger.Log.Info("acquiring audio session",
+		slog.String("component", "webrtc"),
+		slog.String("mode", string(mode)),
+		slog.String("audio_input_id", audioInputID))
+	sess, err := h.acquireChannel(ctx, pcConfig.OpenRetry, mode, audioInputID)
 	if err != nil {
-		http.Error(w, "Failed to create peer connection", http.StatusInternalServerError)
+		logger.Log.Error("failed to acquire audio session",
+			slog.String("component", "webrtc"),
+			slog.String("error", err.Error()))
+		result.fail(deviceErrorCategory(err), err)
+		if writeChannelUnavailable(w, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			http.Error(w, "Doorbell did not respond in time", http.StatusGatewayTimeout)
+		case errors.Is(err, session.ErrUnknownAudioInput):
+			http.Error(w, "No audio channel for audio input "+audioInputID, http.StatusBadRequest)
+		case errors.Is(err, hikvision.ErrCircuitOpen):
+			http.Error(w, "Doorbell unreachable, retrying later", http.StatusServiceUnavailable)
+		default:
+			http.Error(w, "Failed to open doorbell audio channel: "+err.Error(), http.StatusBadGateway)
+		}
 		return
 	}
+	if !h.attach(op, func() {
+		h.activeSession = sess
+		h.call = call
+		call.ring(sess)
+	}) {
+		h.sessionManager.ReleaseChannel(context.Background(), sess.ChannelID)
+		result.fail(errCategoryCancelled, errSessionTornDown)
+		http.Error(w, "Session was aborted", http.StatusServiceUnavailable)
+		return
+	}
+	result.setChannel(sess.ChannelID)
 
-	h.peerConnection = peerConnection
+	// A forced codec must be one the channel can carry; the deferred cleanup releases
+	// the channel when it isn't
+	if codecOverride != nil {
+		if err := h.checkCodecOverride(ctx, *codecOverride, sess); err != nil {
+			logger.Log.Warn("rejected WebRTC offer: codec override not supported by channel",
+				slog.String("component", "webrtc"),
+				slog.String("channel_id", sess.ChannelID),
+				slog.String("error", err.Error()))
+			result.fail(errCategoryBadRequest, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 
-	// Create outgoing audio track for sending audio from doorbell to client
+	// Create outgoing audio track for sending audio from doorbell to client. It is
+	// created before the peer connection so a failure here has nothing to close.
+	// The track uses the channel's codec when the client can take it; otherwise the
+	// streamer converts between the G.711 laws.
+	sendCodec := trackCodec(offer, pcConfig.AllowedCodecs, sess.Codec)
+	logger.Log.Info("selected codec for device audio",
+		slog.String("component", "webrtc"),
+		slog.String("codec", sendCodec.RTPName),
+		slog.String("channel_codec", sess.Codec))
 	audioTrack, err := webrtc.NewTrackLocalStaticSample(
-		webrtc.RTPCodecCapability{MimeType: audio.CodecMimeType},
+		webrtc.RTPCodecCapability{
+			MimeType:  sendCodec.RTPMimeType(),
+			ClockRate: audio.SampleRate,
+			Channels:  1,
+		},
 		"audio",
 		"doorbell-audio",
 	)
@@ -99,75 +268,122 @@ func (h *WebRTCHandler) HandleOffer(w http.ResponseWriter, r *http.Request) {
 		logger.Log.Error("failed to create audio track",
 			slog.String("component", "webrtc"),
 			slog.String("error", err.Error()))
+		result.fail(errCategoryInternal, err)
 		http.Error(w, "Failed to create audio track", http.StatusInternalServerError)
 		return
 	}
 
-	// Add track to peer connection
-	_, err = peerConnection.AddTrack(audioTrack)
+	// Create peer connection using configuration
+	peerConnection, err := pcConfig.CreatePeerConnection()
+	if err != nil {
+		result.fail(errCategoryInternal, err)
+		http.Error(w, "Failed to create peer connection", http.StatusInternalServerError)
+		return
+	}
+
+	// Add track to peer connection. The handler only takes ownership of the peer
+	// connection once it is set up, so a failure here must close it directly.
+	rtpSender, err := peerConnection.AddTrack(audioTrack)
 	if err != nil {
 		logger.Log.Error("failed to add track to peer connection",
 			slog.String("component", "webrtc"),
 			slog.String("error", err.Error()))
+		peerConnection.Close()
+		result.fail(errCategoryInternal, err)
 		http.Error(w, "Failed to add track", http.StatusInternalServerError)
 		return
 	}
 
+	// From here on every error path closes the peer connection (and releases the
+	// channel) through the deferred cleanupSession
+	if !h.attach(op, func() { h.peerConnection = peerConnection }) {
+		peerConnection.Close()
+		result.fail(errCategoryCancelled, errSessionTornDown)
+		http.Error(w, "Session was aborted", http.StatusServiceUnavailable)
+		return
+	}
+	call.addTrack(trackDeviceToClient, audioTrack.ID(), audioTrack.Codec().MimeType)
+
+	// Log the candidate pair ICE settles on for debugging
+	rtpSender.Transport().ICETransport().OnSelectedCandidatePairChange(func(pair *webrtc.ICECandidatePair) {
+		logger.Log.Debug("selected ICE candidate pair",
+			slog.String("component", "webrtc"),
+			slog.String("local_type", pair.Local.Typ.String()),
+			slog.String("local_address", pair.Local.Address),
+			slog.String("remote_type", pair.Remote.Typ.String()),
+			slog.String("remote_address", pair.Remote.Address))
+	})
+
+	// Device audio is streamed to every client; client audio only when the offer sends it
+	// Goroutines use a local reference since cleanup() clears h.audioStreamer
+	streamer := streaming.NewHikvisionAudioStreamer(h.hikClient)
+	streamer.SetFramesPerPacket(int(pcConfig.PacketDuration / audio.SampleDuration))
+
+	// Device audio sent before the peer connects is lost, so it is held back until then
+	// and the start of it replayed from the reader's buffer (hikvision.replay_buffer)
+	connected := make(chan struct{})
+	var connectedOnce sync.Once
+	streamer.HoldUntil(connected)
+
 	// Handle incoming audio track (from browser/client to device)
+	// Only the first audio track is used; clients offering several audio m-lines
+	// (e.g. mic plus a secondary source) have their extra tracks ignored. The track is
+	// claimed by its receiver, so browsers that fire OnTrack again for the same stream
+	// (e.g. on renegotiation) can't start a second goroutine writing to the device.
+	var primaryReceiver atomic.Pointer[webrtc.RTPReceiver]
 	peerConnection.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
 		logger.Log.Info("received remote track",
 			slog.String("component", "webrtc"),
 			slog.String("kind", track.Kind().String()),
-			slog.String("codec", track.Codec().MimeType))
-
-		// Start session if not already active
-		if h.activeSession == nil {
-			logger.Log.Info("acquiring audio session", slog.String("component", "webrtc"))
-
-			// Acquire session using session manager
-			sess, err := h.sessionManager.AcquireChannel(ctx)
-			if err != nil {
-				logger.Log.Error("failed to acquire audio session",
-					slog.String("component", "webrtc"),
-					slog.String("error", err.Error()))
-				return
-			}
-			h.activeSession = sess
+			slog.String("codec", track.Codec().MimeType),
+			slog.String("track_id", track.ID()))
 
-			// Create a fresh audio streamer for this session
-			h.audioStreamer = streaming.NewHikvisionAudioStreamer(h.hikClient)
+		if track.Kind() != webrtc.RTPCodecTypeAudio {
+			logger.Log.Warn("ignoring non-audio track",
+				slog.String("component", "webrtc"),
+				slog.String("kind", track.Kind().String()))
+			return
+		}
 
-			// Start audio streaming
-			if err := h.audioStreamer.Start(ctx, sess); err != nil {
-				logger.Log.Error("failed to start audio streaming",
+		if !primaryReceiver.CompareAndSwap(nil, receiver) {
+			if primaryReceiver.Load() == receiver {
+				logger.Log.Debug("ignoring duplicate OnTrack for the active audio track",
 					slog.String("component", "webrtc"),
-					slog.String("error", err.Error()))
+					slog.String("track_id", track.ID()))
 				return
 			}
+			logger.Log.Warn("ignoring additional audio track, only the first audio track is used",
+				slog.String("component", "webrtc"),
+				slog.String("track_id", track.ID()))
+			return
+		}
 
-			// Start goroutine to stream device audio to client
-			go func() {
-				if err := h.audioStreamer.StreamDeviceToClient(ctx, audioTrack); err != nil {
-					logger.Log.Error("device-to-client streaming error",
-						slog.String("component", "webrtc"),
-						slog.String("error", err.Error()))
-				}
-			}()
+		if !clientSends {
+			logger.Log.Warn("ignoring audio track from client that offered recvonly",
+				slog.String("component", "webrtc"),
+				slog.String("track_id", track.ID()))
+			return
 		}
 
+		call.addTrack(trackClientToDevice, track.ID(), track.Codec().MimeType)
+
 		// Start goroutine to stream client audio to device
-		go func() {
+		h.tasks.GoWithin(ctx, "webrtc client-to-device", func(ctx context.Context) {
 			defer func() {
 				logger.Log.Info("track ended, cleaning up session", slog.String("component", "webrtc"))
-				h.cleanup()
+				h.cleanupSession(op)
 			}()
 
-			if err := h.audioStreamer.StreamClientToDevice(ctx, track); err != nil {
+			if err := streamer.StreamClientToDevice(ctx, track); err != nil {
 				logger.Log.Error("client-to-device streaming error",
 					slog.String("component", "webrtc"),
 					slog.String("error", err.Error()))
+				if errors.Is(err, streaming.ErrDeviceStream) && ctx.Err() == nil {
+					result.fail(errCategoryDevice, err)
+					h.abortManager.DeviceError(op, sess.ChannelID, err)
+				}
 			}
-		}()
+		})
 	})
 
 	// Handle connection state changes
@@ -176,10 +392,17 @@ func (h *WebRTCHandler) HandleOffer(w http.ResponseWriter, r *http.Request) {
 			slog.String("component", "webrtc"),
 			slog.String("state", state.String()))
 
+		if state == webrtc.PeerConnectionStateConnected {
+			connectedOnce.Do(func() { close(connected) })
+			call.connect()
+		}
+		if state == webrtc.PeerConnectionStateFailed {
+			result.fail(errCategoryConnection, errors.New("peer connection failed"))
+		}
 		if state == webrtc.PeerConnectionStateFailed ||
 			state == webrtc.PeerConnectionStateClosed ||
 			state == webrtc.PeerConnectionStateDisconnected {
-			h.cleanup()
+			h.cleanupSession(op)
 		}
 	})
 
@@ -189,13 +412,16 @@ func (h *WebRTCHandler) HandleOffer(w http.ResponseWriter, r *http.Request) {
 		logger.Log.Error("failed to set remote description",
 			slog.String("component", "webrtc"),
 			slog.String("error", err.Error()))
+		result.fail(errCategoryBadRequest, err)
 		http.Error(w, "Failed to set remote description", http.StatusInternalServerError)
 		return
 	}
 
 	// Log ICE candidates for debugging
+	var candidateCount atomic.Int32
 	peerConnection.OnICECandidate(func(candidate *webrtc.ICECandidate) {
 		if candidate != nil {
+			candidateCount.Add(1)
 			logger.Log.Debug("generated ICE candidate",
 				slog.String("component", "webrtc"),
 				slog.String("type", candidate.Typ.String()),
@@ -222,6 +448,7 @@ func (h *WebRTCHandler) HandleOffer(w http.ResponseWriter, r *http.Request) {
 		logger.Log.Error("failed to create SDP answer",
 			slog.String("component", "webrtc"),
 			slog.String("error", err.Error()))
+		result.fail(errCategoryInternal, err)
 		http.Error(w, "Failed to create answer", http.StatusInternalServerError)
 		return
 	}
@@ -232,24 +459,350 @@ func (h *WebRTCHandler) HandleOffer(w http.ResponseWriter, r *http.Request) {
 		logger.Log.Error("failed to set local description",
 			slog.String("component", "webrtc"),
 			slog.String("error", err.Error()))
+		result.fail(errCategoryInternal, err)
 		http.Error(w, "Failed to set local description", http.StatusInternalServerError)
 		return
 	}
 
 	// Wait for ICE gathering to complete
+	// Bounded by a timeout so a networking misconfiguration can't wedge the request
 	logger.Log.Info("waiting for ICE gathering to complete", slog.String("component", "webrtc"))
-	<-gatherComplete
+	select {
+	case <-gatherComplete:
+	case <-time.After(pcConfig.ICEGatherTimeout):
+		if candidateCount.Load() == 0 {
+			logger.Log.Error("ICE gathering timed out without any candidates",
+				slog.String("component", "webrtc"),
+				slog.Duration("timeout", pcConfig.ICEGatherTimeout))
+			result.fail(errCategoryTimeout, errors.New("ICE gathering timed out"))
+			http.Error(w, "ICE gathering timed out", http.StatusGatewayTimeout)
+			return
+		}
+		logger.Log.Warn("ICE gathering timed out, answering with partial candidates",
+			slog.String("component", "webrtc"),
+			slog.Duration("timeout", pcConfig.ICEGatherTimeout),
+			slog.Int("candidates", int(candidateCount.Load())))
+	}
+
+	// Log the negotiated codec(s) and the final answer for debugging
+	for _, codec := range rtpSender.GetParameters().Codecs {
+		logger.Log.Debug("negotiated codec",
+			slog.String("component", "webrtc"),
+			slog.String("mime_type", codec.MimeType),
+			slog.Int("clock_rate", int(codec.ClockRate)),
+			slog.Int("payload_type", int(codec.PayloadType)))
+	}
+	logger.Log.Debug("SDP answer contents",
+		slog.String("component", "webrtc"),
+		slog.String("sdp", peerConnection.LocalDescription().SDP))
+
+	// Start streaming on the channel acquired above
+	if err := streamer.Start(ctx, sess); err != nil {
+		logger.Log.Error("failed to start audio streaming",
+			slog.String("component", "webrtc"),
+			slog.String("error", err.Error()))
+		streamer.Stop() // Close whatever streams were opened before the failure
+		result.fail(errCategoryDevice, err)
+		h.abortManager.DeviceError(op, sess.ChannelID, err)
+		http.Error(w, "Failed to start audio streaming", http.StatusInternalServerError)
+		return
+	}
+	if !h.attach(op, func() { h.audioStreamer = streamer }) {
+		streamer.Stop()
+		result.fail(errCategoryCancelled, errSessionTornDown)
+		http.Error(w, "Session was aborted", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Keep the device's control session alive for the length of the call
+	if interval := pcConfig.KeepAliveInterval; interval > 0 {
+		h.tasks.GoWithin(ctx, "webrtc keep-alive", func(ctx context.Context) {
+			h.keepAlive(ctx, interval)
+		})
+	}
+
+	// Start goroutine to stream device audio to client
+	h.tasks.GoWithin(ctx, "webrtc device-to-client", func(ctx context.Context) {
+		if err := streamer.StreamDeviceToClient(ctx, audioTrack); err != nil {
+			logger.Log.Error("device-to-client streaming error",
+				slog.String("component", "webrtc"),
+				slog.String("error", err.Error()))
+			if errors.Is(err, streaming.ErrDeviceStream) && ctx.Err() == nil {
+				result.fail(errCategoryDevice, err)
+				h.abortManager.DeviceError(op, sess.ChannelID, err)
+			}
+		}
+	})
+
+	// Advertise the bandwidth cap so the browser keeps its audio under it too
+	answerDesc := *peerConnection.LocalDescription()
+	if maxBitrate > 0 {
+		answerDesc.SDP = capAudioBandwidth(answerDesc.SDP, maxBitrate)
+		logger.Log.Info("applied bandwidth cap to SDP answer",
+			slog.String("component", "webrtc"),
+			slog.Int("max_bitrate_kbps", maxBitrate))
+	}
+
+	// Tell the browser to expect the longer packets when frames are aggregated
+	if pcConfig.PacketDuration > audio.SampleDuration {
+		answerDesc.SDP = setAudioPtime(answerDesc.SDP, pcConfig.PacketDuration)
+	}
 
 	// Send answer back to client (now with all ICE candidates)
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	json.NewEncoder(w).Encode(peerConnection.LocalDescription())
+	json.NewEncoder(w).Encode(answerDesc)
+	answered = true
 
 	logger.Log.Info("SDP answer sent successfully", slog.String("component", "webrtc"))
 }
 
-// cleanup closes the session and cleans up resources
+// acquireChannel opens a doorbell channel for an offer, retrying per policy while the
+// device looks momentarily busy. Each attempt is bounded by deviceRequestTimeout.
+// Errors a retry can't fix (unknown input, open circuit, cancellation) return at once.
+func (h *WebRTCHandler) acquireChannel(ctx context.Context, policy retry.Policy, mode session.AudioMode, audioInputID string) (*session.AudioSession, error) {
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, deviceRequestTimeout)
+		sess, err := h.sessionManager.AcquireChannel(attemptCtx, mode, audioInputID)
+		cancel()
+		if err == nil {
+			return sess, nil
+		}
+
+		permanent := errors.Is(err, session.ErrUnknownAudioInput) || errors.Is(err, session.ErrNoChannelsConfigured) ||
+			errors.Is(err, hikvision.ErrCircuitOpen)
+		if permanent || !policy.Allows(attempt) || ctx.Err() != nil {
+			return nil, err
+		}
+		logger.Log.Debug("failed to open audio channel, retrying",
+			slog.String("component", "webrtc"),
+			slog.Int("attempt", attempt),
+			slog.Int("max_attempts", policy.MaxAttempts),
+			slog.String("error", err.Error()))
+		if policy.Wait(ctx, attempt) != nil {
+			return nil, err
+		}
+	}
+}
+
+// codecOverride returns the codec requested for this session via the codec query
+// parameter or X-Audio-Codec header, or nil if none was requested. The codec must be
+// one of the server's allowed codecs.
+func (h *WebRTCHandler) codecOverride(r *http.Request) (*audio.Codec, error) {
+	name := r.URL.Query().Get("codec")
+	if name == "" {
+		name = r.Header.Get("X-Audio-Codec")
+	}
+	if name == "" {
+		return nil, nil
+	}
+
+	for _, codec := range h.config.AllowedCodecs {
+		if strings.EqualFold(codec.RTPName, name) {
+			return &codec, nil
+		}
+	}
+	return nil, fmt.Errorf("codec %s is not allowed (allowed: %s)", name, strings.Join(codecNames(h.config.AllowedCodecs), ","))
+}
+
+// checkCodecOverride verifies the channel opened for sess supports a forced codec.
+// The channel's reported codec list decides; when the device doesn't report one, the
+// override is accepted if the streamer can convert it to the channel's codec.
+func (h *WebRTCHandler) checkCodecOverride(ctx context.Context, codec audio.Codec, sess *session.AudioSession) error {
+	capsCtx, cancel := context.WithTimeout(ctx, deviceRequestTimeout)
+	caps, err := h.sessionManager.ChannelCapabilities(capsCtx, sess.ChannelID)
+	cancel()
+	if err != nil {
+		logger.Log.Warn("failed to get channel capabilities, checking codec override against the channel codec",
+			slog.String("component", "webrtc"),
+			slog.String("channel_id", sess.ChannelID),
+			slog.String("error", err.Error()))
+	} else if len(caps.Codecs) > 0 {
+		for _, name := range caps.Codecs {
+			if name == codec.Name {
+				return nil
+			}
+		}
+		return fmt.Errorf("codec %s is not supported by channel %s (supported: %s)", codec.RTPName, sess.ChannelID, strings.Join(caps.Codecs, ","))
+	}
+
+	channelCodec := sess.Codec
+	if channelCodec == "" {
+		channelCodec = audio.DefaultCodec
+	}
+	if _, ok := audio.LookupCodec(channelCodec); !ok {
+		return fmt.Errorf("codec %s can't be converted to channel %s's codec %s", codec.RTPName, sess.ChannelID, channelCodec)
+	}
+	return nil
+}
+
+// codecNames returns the RTP names of codecs
+func codecNames(codecs []audio.Codec) []string {
+	names := make([]string, 0, len(codecs))
+	for _, codec := range codecs {
+		names = append(names, codec.RTPName)
+	}
+	return names
+}
+
+// trackCodec picks the allowed codec device audio is sent to the client in: the
+// channel's own codec if the offer lists it, so the audio goes out unchanged, else the
+// first allowed codec the offer lists. If the offer lists none it falls back to the
+// first allowed codec and lets negotiation fail as before.
+func trackCodec(offer webrtc.SessionDescription, allowed []audio.Codec, channelCodec string) audio.Codec {
+	offered := offerAudioCodecs(offer, allowed)
+
+	candidates := allowed
+	for _, codec := range allowed {
+		if codec.Name == channelCodec {
+			candidates = append([]audio.Codec{codec}, allowed...)
+			break
+		}
+	}
+	for _, codec := range candidates {
+		if offered == nil || offered[codec.Name] {
+			return codec
+		}
+	}
+	return allowed[0]
+}
+
+// offerAudioCodecs returns the names of the allowed codecs listed on the offer's audio
+// m-lines, by rtpmap or static payload type, or nil if the SDP can't be inspected
+func offerAudioCodecs(offer webrtc.SessionDescription, allowed []audio.Codec) map[string]bool {
+	parsed, err := offer.Unmarshal()
+	if err != nil {
+		return nil
+	}
+
+	offered := make(map[string]bool)
+	for _, media := range parsed.MediaDescriptions {
+		if media.MediaName.Media != "audio" {
+			continue
+		}
+		for _, codec := range allowed {
+			for _, format := range media.MediaName.Formats {
+				if format == strconv.Itoa(int(codec.PayloadType)) {
+					offered[codec.Name] = true
+				}
+			}
+			for _, attr := range media.Attributes {
+				if attr.Key != "rtpmap" {
+					continue
+				}
+				// e.g. "8 PCMA/8000"
+				_, encoding, _ := strings.Cut(attr.Value, " ")
+				name, _, _ := strings.Cut(encoding, "/")
+				if strings.EqualFold(name, codec.RTPName) {
+					offered[codec.Name] = true
+				}
+			}
+		}
+	}
+	return offered
+}
+
+// offerSendsAudio reports whether the offer has an audio m-line the client sends on
+// (sendrecv or sendonly). An m-line without a direction attribute defaults to sendrecv.
+func offerSendsAudio(offer webrtc.SessionDescription) bool {
+	parsed, err := offer.Unmarshal()
+	if err != nil {
+		// Assume full duplex if the SDP can't be inspected; SetRemoteDescription will reject it anyway
+		return true
+	}
+
+	for _, media := range parsed.MediaDescriptions {
+		if media.MediaName.Media != "audio" {
+			continue
+		}
+		if _, ok := media.Attribute("recvonly"); ok {
+			continue
+		}
+		if _, ok := media.Attribute("inactive"); ok {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// keepAlive issues a harmless ISAPI request every interval until ctx ends with the
+// session. Some firmware drops a two-way audio session when no control traffic flows,
+// even while audio is streaming. Failed pings are logged but don't end the call.
+func (h *WebRTCHandler) keepAlive(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// Query the device directly; the session manager's channel cache would skip the request
+			pingCtx, cancel := context.WithTimeout(ctx, deviceRequestTimeout)
+			_, err := h.hikClient.GetTwoWayAudioChannelsQuiet(pingCtx)
+			cancel()
+
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				logger.Log.Warn("session keep-alive failed",
+					slog.String("component", "webrtc"),
+					slog.String("error", err.Error()))
+				continue
+			}
+			logger.Log.Debug("session keep-alive sent", slog.String("component", "webrtc"))
+		}
+	}
+}
+
+// errSessionTornDown is reported when a session is cleaned up while its offer is still being handled
+var errSessionTornDown = errors.New("session was torn down during negotiation")
+
+// attach runs set under sessionMu if op is still the active session. If the session was
+// already torn down (e.g. a failed connection callback fired mid-negotiation) it returns
+// false and the caller must close the resource itself, since cleanup will not see it.
+func (h *WebRTCHandler) attach(op *Operation, set func()) bool {
+	h.sessionMu.Lock()
+	defer h.sessionMu.Unlock()
+
+	if h.activeOp != op {
+		return false
+	}
+	set()
+	return true
+}
+
+// cleanupSession tears down the session started for op. It is safe to call
+// concurrently and repeatedly: calls for an already cleaned-up (or superseded)
+// session are ignored, so late callbacks can't tear down a newer session.
+func (h *WebRTCHandler) cleanupSession(op *Operation) {
+	h.sessionMu.Lock()
+	defer h.sessionMu.Unlock()
+
+	if h.activeOp != op {
+		return
+	}
+	h.cleanupLocked()
+}
+
+// CloseSession tears down the WebRTC session started for op, if it is still active
+func (h *WebRTCHandler) CloseSession(op *Operation) {
+	h.cleanupSession(op)
+}
+
+// cleanup tears down whatever session is currently active
 func (h *WebRTCHandler) cleanup() {
+	h.sessionMu.Lock()
+	defer h.sessionMu.Unlock()
+
+	h.cleanupLocked()
+}
+
+// cleanupLocked closes the session and cleans up resources in a fixed order:
+// goroutines, streams, device channel, peer connection, then the abort manager.
+// Each step clears its field so repeated calls are no-ops. Caller must hold sessionMu.
+func (h *WebRTCHandler) cleanupLocked() {
 	// Cancel all goroutines first
 	if h.cancelFunc != nil {
 		h.cancelFunc()
@@ -258,7 +811,18 @@ func (h *WebRTCHandler) cleanup() {
 
 	// Stop audio streaming
 	if h.audioStreamer != nil {
+		if h.activeResult != nil {
+			h.activeResult.addBytes(h.audioStreamer.BytesTransferred())
+		}
+		underruns, silence := h.audioStreamer.Underruns()
+		logger.Log.Info("session audio summary",
+			slog.String("component", "webrtc"),
+			slog.String("session_id", h.activeOp.SessionID),
+			slog.Int64("underruns", underruns),
+			slog.Duration("silence", silence),
+			slog.Int64("reader_restarts", h.audioStreamer.ReaderRestarts()))
 		h.audioStreamer.Stop()
+		h.audioStreamer = nil
 	}
 
 	// Release audio session
@@ -285,11 +849,41 @@ func (h *WebRTCHandler) cleanup() {
 		h.abortManager.Unregister(h.activeOp)
 		h.activeOp = nil
 	}
+
+	// One audit line per session, covering both failed offers and ended calls. The
+	// call itself is kept so its outcome stays visible until the next offer.
+	if h.activeResult != nil {
+		if h.call != nil {
+			h.call.end(h.activeResult.errorCategory())
+		}
+		h.activeResult.log()
+		h.activeResult = nil
+	}
+}
+
+// ReloadConfig re-reads WebRTC settings from the environment for new connections and
+// reports whether the public IP changed. Existing peer connections keep the settings
+// they were created with.
+func (h *WebRTCHandler) ReloadConfig() bool {
+	config := NewWebRTCConfig()
+	config.LoadFromEnv()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	changed := config.PublicIP != h.config.PublicIP
+	h.config = config
+	return changed
 }
 
-// Close closes all WebRTC resources
-func (h *WebRTCHandler) Close() {
+// Close closes all WebRTC resources and reports whether a session was active
+func (h *WebRTCHandler) Close() bool {
 	h.mu.Lock()
 	defer h.mu.Unlock()
+
+	h.sessionMu.Lock()
+	active := h.activeOp != nil
+	h.sessionMu.Unlock()
+
 	h.cleanup()
+	return active
 }
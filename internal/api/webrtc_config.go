@@ -1,11 +1,17 @@
 package api
 
 import (
+	"fmt"
 	"log/slog"
 	"os"
+	"path"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/acardace/hikvision-doorbell-server/internal/audio"
 	"github.com/acardace/hikvision-doorbell-server/internal/logger"
+	"github.com/acardace/hikvision-doorbell-server/internal/retry"
 	"github.com/pion/webrtc/v4"
 )
 
@@ -20,12 +26,52 @@ type WebRTCConfig struct {
 	// PublicIPFile is the path to a file containing the public IP
 	// (useful when IP is set by init containers in Kubernetes)
 	PublicIPFile string
+
+	// AllowedCodecs restricts the codecs registered with the MediaEngine.
+	// The first entry is used for the outgoing doorbell audio track.
+	AllowedCodecs []audio.Codec
+
+	// ICEGatherTimeout bounds how long an offer waits for ICE gathering (default: 10s)
+	ICEGatherTimeout time.Duration
+
+	// KeepAliveInterval is how often an active session pings the device over ISAPI so
+	// firmware that reaps idle control sessions keeps the call up (0 disables, the default)
+	KeepAliveInterval time.Duration
+
+	// MaxBitrate caps the audio bandwidth advertised in the SDP answer, in kbit/s
+	// (0 leaves it uncapped, the default). Sessions can request a lower cap.
+	MaxBitrate int
+
+	// OpenRetry retries opening the doorbell channel for an offer when the device is
+	// momentarily busy, e.g. still releasing the previous session (default: 3 attempts, 500ms apart)
+	OpenRetry retry.Policy
+
+	// PacketDuration is how much doorbell audio each RTP packet sent to the client
+	// carries, a multiple of 20ms up to maxPacketDuration (default: 20ms). Longer
+	// packets add latency but cut the packet rate on constrained links.
+	PacketDuration time.Duration
+
+	// Interfaces and ExcludeInterfaces limit the network interfaces ICE gathers host
+	// candidates on, as name patterns such as "eth*" (path.Match syntax). An interface
+	// is used if it matches Interfaces (or Interfaces is empty) and doesn't match
+	// ExcludeInterfaces. Both empty gathers on every interface, the default.
+	Interfaces        []string
+	ExcludeInterfaces []string
 }
 
+// maxPacketDuration bounds PacketDuration; longer packets add too much latency for a call
+const maxPacketDuration = 120 * time.Millisecond
+
 // NewWebRTCConfig creates a new WebRTC configuration with defaults
 func NewWebRTCConfig() *WebRTCConfig {
+	defaultCodecs, _ := audio.ParseCodecList(audio.DefaultAllowedCodecs)
+
 	return &WebRTCConfig{
-		Port: 50000, // Default port
+		Port:             50000, // Default port
+		AllowedCodecs:    defaultCodecs,
+		ICEGatherTimeout: 10 * time.Second,
+		OpenRetry:        retry.Policy{MaxAttempts: 3, BaseDelay: 500 * time.Millisecond},
+		PacketDuration:   audio.SampleDuration,
 	}
 }
 
@@ -51,6 +97,109 @@ func (c *WebRTCConfig) LoadFromEnv() error {
 		}
 	}
 
+	// Load allowed codecs (e.g. "PCMU,PCMA")
+	if list := os.Getenv("ALLOWED_CODECS"); list != "" {
+		if codecs, err := audio.ParseCodecList(list); err == nil {
+			c.AllowedCodecs = codecs
+		} else {
+			logger.Log.Warn("invalid ALLOWED_CODECS, using default",
+				slog.String("component", "webrtc_config"),
+				slog.String("value", list),
+				slog.String("default", audio.DefaultAllowedCodecs),
+				slog.String("error", err.Error()))
+		}
+	}
+
+	// Load ICE gathering timeout (e.g. "5s")
+	if timeout := os.Getenv("WEBRTC_ICE_GATHER_TIMEOUT"); timeout != "" {
+		if d, err := time.ParseDuration(timeout); err == nil && d > 0 {
+			c.ICEGatherTimeout = d
+		} else {
+			logger.Log.Warn("invalid WEBRTC_ICE_GATHER_TIMEOUT, using default",
+				slog.String("component", "webrtc_config"),
+				slog.String("value", timeout),
+				slog.Duration("default", c.ICEGatherTimeout))
+		}
+	}
+
+	// Load session keep-alive interval (e.g. "30s")
+	if interval := os.Getenv("WEBRTC_KEEPALIVE_INTERVAL"); interval != "" {
+		if d, err := time.ParseDuration(interval); err == nil && d >= 0 {
+			c.KeepAliveInterval = d
+		} else {
+			logger.Log.Warn("invalid WEBRTC_KEEPALIVE_INTERVAL, keep-alive disabled",
+				slog.String("component", "webrtc_config"),
+				slog.String("value", interval))
+		}
+	}
+
+	// Load the bandwidth cap in kbit/s (e.g. "32")
+	if bitrate := os.Getenv("WEBRTC_MAX_BITRATE"); bitrate != "" {
+		if kbps, err := strconv.Atoi(bitrate); err == nil && kbps >= 0 {
+			c.MaxBitrate = kbps
+		} else {
+			logger.Log.Warn("invalid WEBRTC_MAX_BITRATE, bandwidth left uncapped",
+				slog.String("component", "webrtc_config"),
+				slog.String("value", bitrate))
+		}
+	}
+
+	// Load channel open attempts (1 disables retries) and the delay between them
+	if attempts := os.Getenv("WEBRTC_OPEN_ATTEMPTS"); attempts != "" {
+		if n, err := strconv.Atoi(attempts); err == nil && n >= 1 {
+			c.OpenRetry.MaxAttempts = n
+		} else {
+			logger.Log.Warn("invalid WEBRTC_OPEN_ATTEMPTS, using default",
+				slog.String("component", "webrtc_config"),
+				slog.String("value", attempts),
+				slog.Int("default", c.OpenRetry.MaxAttempts))
+		}
+	}
+	if delay := os.Getenv("WEBRTC_OPEN_RETRY_DELAY"); delay != "" {
+		if d, err := time.ParseDuration(delay); err == nil && d >= 0 {
+			c.OpenRetry.BaseDelay = d
+		} else {
+			logger.Log.Warn("invalid WEBRTC_OPEN_RETRY_DELAY, using default",
+				slog.String("component", "webrtc_config"),
+				slog.String("value", delay),
+				slog.Duration("default", c.OpenRetry.BaseDelay))
+		}
+	}
+
+	// Load the RTP packet duration (e.g. "40ms"), a multiple of the 20ms G.711 frame
+	if ptime := os.Getenv("WEBRTC_PTIME"); ptime != "" {
+		if d, err := time.ParseDuration(ptime); err == nil && d > 0 && d <= maxPacketDuration && d%audio.SampleDuration == 0 {
+			c.PacketDuration = d
+		} else {
+			logger.Log.Warn("invalid WEBRTC_PTIME, using default",
+				slog.String("component", "webrtc_config"),
+				slog.String("value", ptime),
+				slog.Duration("default", c.PacketDuration))
+		}
+	}
+
+	// Load interface allow and deny lists (e.g. "eth0,wlan*" and "docker*,br-*,tun*")
+	if list := os.Getenv("WEBRTC_INTERFACES"); list != "" {
+		if patterns, err := parseInterfacePatterns(list); err == nil {
+			c.Interfaces = patterns
+		} else {
+			logger.Log.Warn("invalid WEBRTC_INTERFACES, gathering on all interfaces",
+				slog.String("component", "webrtc_config"),
+				slog.String("value", list),
+				slog.String("error", err.Error()))
+		}
+	}
+	if list := os.Getenv("WEBRTC_EXCLUDE_INTERFACES"); list != "" {
+		if patterns, err := parseInterfacePatterns(list); err == nil {
+			c.ExcludeInterfaces = patterns
+		} else {
+			logger.Log.Warn("invalid WEBRTC_EXCLUDE_INTERFACES, no interfaces excluded",
+				slog.String("component", "webrtc_config"),
+				slog.String("value", list),
+				slog.String("error", err.Error()))
+		}
+	}
+
 	if c.PublicIP != "" {
 		logger.Log.Info("loaded WebRTC public IP",
 			slog.String("component", "webrtc_config"),
@@ -63,6 +212,43 @@ func (c *WebRTCConfig) LoadFromEnv() error {
 	return nil
 }
 
+// parseInterfacePatterns splits a comma-separated list of interface name patterns,
+// rejecting malformed ones
+func parseInterfacePatterns(list string) ([]string, error) {
+	var patterns []string
+	for _, p := range strings.Split(list, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("bad pattern %q: %w", p, err)
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+// interfaceAllowed reports whether ICE may gather candidates on the named interface
+func (c *WebRTCConfig) interfaceAllowed(name string) bool {
+	matches := func(patterns []string) bool {
+		for _, p := range patterns {
+			if ok, _ := path.Match(p, name); ok {
+				return true
+			}
+		}
+		return false
+	}
+	return (len(c.Interfaces) == 0 || matches(c.Interfaces)) && !matches(c.ExcludeInterfaces)
+}
+
+// WithCodecs returns a copy of the configuration restricted to codecs
+func (c *WebRTCConfig) WithCodecs(codecs []audio.Codec) *WebRTCConfig {
+	copied := *c
+	copied.AllowedCodecs = codecs
+	return &copied
+}
+
 // CreateAPI creates a WebRTC API with the configured settings
 func (c *WebRTCConfig) CreateAPI() (*webrtc.API, error) {
 	settingEngine := webrtc.SettingEngine{}
@@ -89,25 +275,40 @@ func (c *WebRTCConfig) CreateAPI() (*webrtc.API, error) {
 		settingEngine.SetNAT1To1IPs([]string{c.PublicIP}, webrtc.ICECandidateTypeHost)
 	}
 
-	// Create MediaEngine with only PCMU codec
-	mediaEngine := &webrtc.MediaEngine{}
-	if err := mediaEngine.RegisterCodec(webrtc.RTPCodecParameters{
-		RTPCodecCapability: webrtc.RTPCodecCapability{
-			MimeType:    "audio/PCMU",
-			ClockRate:   8000,
-			Channels:    1,
-			SDPFmtpLine: "",
-		},
-		PayloadType: 0,
-	}, webrtc.RTPCodecTypeAudio); err != nil {
-		logger.Log.Error("failed to register PCMU codec",
+	// Skip interfaces that can't reach clients (Docker bridges, VPN tunnels)
+	if len(c.Interfaces) > 0 || len(c.ExcludeInterfaces) > 0 {
+		logger.Log.Info("filtering ICE interfaces",
 			slog.String("component", "webrtc_config"),
-			slog.String("error", err.Error()))
-		return nil, err
+			slog.String("include", strings.Join(c.Interfaces, ",")),
+			slog.String("exclude", strings.Join(c.ExcludeInterfaces, ",")))
+		settingEngine.SetInterfaceFilter(c.interfaceAllowed)
 	}
 
-	logger.Log.Info("configured WebRTC to use PCMU codec only",
-		slog.String("component", "webrtc_config"))
+	// Create MediaEngine with only the allowed codecs (PCMU by default)
+	mediaEngine := &webrtc.MediaEngine{}
+	names := make([]string, 0, len(c.AllowedCodecs))
+	for _, codec := range c.AllowedCodecs {
+		if err := mediaEngine.RegisterCodec(webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{
+				MimeType:    codec.RTPMimeType(),
+				ClockRate:   audio.SampleRate,
+				Channels:    1,
+				SDPFmtpLine: "",
+			},
+			PayloadType: webrtc.PayloadType(codec.PayloadType),
+		}, webrtc.RTPCodecTypeAudio); err != nil {
+			logger.Log.Error("failed to register codec",
+				slog.String("component", "webrtc_config"),
+				slog.String("codec", codec.RTPName),
+				slog.String("error", err.Error()))
+			return nil, err
+		}
+		names = append(names, codec.RTPName)
+	}
+
+	logger.Log.Info("configured WebRTC codecs",
+		slog.String("component", "webrtc_config"),
+		slog.String("codecs", strings.Join(names, ",")))
 
 	return webrtc.NewAPI(
 		webrtc.WithSettingEngine(settingEngine),
@@ -0,0 +1,145 @@
+package audio
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Codec describes an audio codec supported by the doorbell's two-way audio channels
+type Codec struct {
+	// Name is the Hikvision audioCompressionType name (e.g. "G.711ulaw")
+	Name string
+
+	// FFmpegFormat is the raw output format passed to ffmpeg's -f flag
+	FFmpegFormat string
+
+	// FFmpegCodec is the encoder passed to ffmpeg's -acodec flag
+	FFmpegCodec string
+
+	// SampleRate is the codec's sample rate in Hz
+	SampleRate int
+
+	// BytesPerSecond is the encoded data rate used to compute playback duration and pacing
+	BytesPerSecond int
+
+	// RTPName is the codec's RTP encoding name (e.g. "PCMU")
+	RTPName string
+
+	// PayloadType is the static RTP payload type
+	PayloadType uint8
+
+	// Silence is the encoded value of a zero sample
+	Silence byte
+
+	// WAVFormat is the WAVE format tag used when wrapping the codec in a WAV container
+	WAVFormat uint16
+}
+
+// RTPMimeType returns the WebRTC MIME type (e.g. "audio/PCMU")
+func (c Codec) RTPMimeType() string {
+	return "audio/" + c.RTPName
+}
+
+// Supported codecs, keyed by Hikvision audioCompressionType
+var codecs = map[string]Codec{
+	"G.711ulaw": {
+		Name:           "G.711ulaw",
+		FFmpegFormat:   "mulaw",
+		FFmpegCodec:    "pcm_mulaw",
+		SampleRate:     SampleRate,
+		BytesPerSecond: SampleRate * BytesPerSample,
+		RTPName:        "PCMU",
+		PayloadType:    0,
+		Silence:        0xFF,
+		WAVFormat:      7, // WAVE_FORMAT_MULAW
+	},
+	"G.711alaw": {
+		Name:           "G.711alaw",
+		FFmpegFormat:   "alaw",
+		FFmpegCodec:    "pcm_alaw",
+		SampleRate:     SampleRate,
+		BytesPerSecond: SampleRate * BytesPerSample,
+		RTPName:        "PCMA",
+		PayloadType:    8,
+		Silence:        0xD5,
+		WAVFormat:      6, // WAVE_FORMAT_ALAW
+	},
+}
+
+// DefaultCodec is the codec used when none is configured
+const DefaultCodec = "G.711ulaw"
+
+// LookupCodec returns the codec with the given Hikvision name
+func LookupCodec(name string) (Codec, bool) {
+	c, ok := codecs[name]
+	return c, ok
+}
+
+// Duration returns how long n bytes of encoded audio take to play
+func (c Codec) Duration(n int) time.Duration {
+	return time.Duration(n) * time.Second / time.Duration(c.BytesPerSecond)
+}
+
+// SilenceBytes returns d worth of encoded silence
+func (c Codec) SilenceBytes(d time.Duration) []byte {
+	data := make([]byte, int(d.Seconds()*float64(c.BytesPerSecond)))
+	for i := range data {
+		data[i] = c.Silence
+	}
+	return data
+}
+
+// DefaultAllowedCodecs is the RTP codec list used when ALLOWED_CODECS is not set
+const DefaultAllowedCodecs = "PCMU"
+
+// LookupRTPCodec returns the codec with the given RTP encoding name (case-insensitive)
+func LookupRTPCodec(rtpName string) (Codec, bool) {
+	for _, c := range codecs {
+		if strings.EqualFold(c.RTPName, rtpName) {
+			return c, true
+		}
+	}
+	return Codec{}, false
+}
+
+// ParseCodecList parses a comma-separated list of RTP codec names such as "PCMU,PCMA".
+// Order is preserved so the first entry can be treated as preferred.
+func ParseCodecList(list string) ([]Codec, error) {
+	var result []Codec
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		c, ok := LookupRTPCodec(name)
+		if !ok {
+			return nil, fmt.Errorf("unsupported codec in list: %s", name)
+		}
+		result = append(result, c)
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("codec list is empty")
+	}
+	return result, nil
+}
+
+// FrameSize returns the number of bytes in one SampleDuration packet for a negotiated
+// RTP codec, along with the packet duration. Only sample-based G.711 codecs (PCMU/PCMA)
+// are supported; zero clockRate/channels fall back to the G.711 defaults.
+func FrameSize(mimeType string, clockRate uint32, channels uint16) (int, time.Duration, error) {
+	if !strings.EqualFold(mimeType, "audio/PCMU") && !strings.EqualFold(mimeType, "audio/PCMA") {
+		return 0, 0, fmt.Errorf("unsupported codec for framing: %s", mimeType)
+	}
+
+	if clockRate == 0 {
+		clockRate = SampleRate
+	}
+	if channels == 0 {
+		channels = 1
+	}
+
+	samples := int(clockRate) * int(SampleDuration) / int(time.Second)
+	return samples * int(channels) * BytesPerSample, SampleDuration, nil
+}
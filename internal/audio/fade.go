@@ -0,0 +1,44 @@
+package audio
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// ApplyFade returns a copy of G.711 audio encoded with codec whose amplitude ramps up
+// from silence over fadeIn and down to silence over the last fadeOut. If the two ramps
+// are longer than the audio they are shortened in proportion so they meet without
+// overlapping. data is left untouched, since it may be shared (e.g. a cached conversion).
+func ApplyFade(codec Codec, data []byte, fadeIn, fadeOut time.Duration) ([]byte, error) {
+	var decode func(byte) int16
+	var encode func(int16) byte
+	switch codec.Name {
+	case "G.711ulaw":
+		decode, encode = MulawToLinear, LinearToMulaw
+	case "G.711alaw":
+		decode, encode = AlawToLinear, LinearToAlaw
+	default:
+		return nil, fmt.Errorf("cannot fade audio for codec %s", codec.Name)
+	}
+
+	data = slices.Clone(data)
+	n := len(data)
+	in := int(fadeIn.Seconds() * float64(codec.SampleRate))
+	out := int(fadeOut.Seconds() * float64(codec.SampleRate))
+	if in+out > n {
+		in = n * in / (in + out)
+		out = n - in
+	}
+
+	scale := func(i int, gain float64) {
+		data[i] = encode(int16(float64(decode(data[i])) * gain))
+	}
+	for i := 0; i < in; i++ {
+		scale(i, float64(i)/float64(in))
+	}
+	for i := 0; i < out; i++ {
+		scale(n-1-i, float64(i)/float64(out))
+	}
+	return data, nil
+}
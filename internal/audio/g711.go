@@ -0,0 +1,107 @@
+package audio
+
+// MulawToLinear decodes a G.711 µ-law sample to 16-bit linear PCM
+func MulawToLinear(u byte) int16 {
+	u = ^u
+	sign := u & 0x80
+	exponent := (u >> 4) & 0x07
+	mantissa := u & 0x0F
+
+	sample := ((int32(mantissa) << 3) + 0x84) << exponent
+	sample -= 0x84
+
+	if sign != 0 {
+		return int16(-sample)
+	}
+	return int16(sample)
+}
+
+// LinearToMulaw encodes a 16-bit linear PCM sample as G.711 µ-law
+func LinearToMulaw(sample int16) byte {
+	const bias = 0x84
+	const clip = 32635
+
+	s := int32(sample)
+	sign := byte(0)
+	if s < 0 {
+		s = -s
+		sign = 0x80
+	}
+	if s > clip {
+		s = clip
+	}
+	s += bias
+
+	exponent := byte(7)
+	for mask := int32(0x4000); s&mask == 0 && exponent > 0; mask >>= 1 {
+		exponent--
+	}
+	mantissa := byte(s>>(exponent+3)) & 0x0F
+
+	return ^(sign | exponent<<4 | mantissa)
+}
+
+// LinearToAlaw encodes a 16-bit linear PCM sample as G.711 A-law
+func LinearToAlaw(sample int16) byte {
+	s := int32(sample) >> 3 // A-law works on 13-bit samples
+	sign := byte(0x80)
+	if s < 0 {
+		s = -s - 1
+		sign = 0
+	}
+	if s > 0xFFF {
+		s = 0xFFF
+	}
+
+	var encoded byte
+	if s < 32 {
+		encoded = byte(s >> 1)
+	} else {
+		exponent := byte(1)
+		for v := s >> 5; v > 1; v >>= 1 {
+			exponent++
+		}
+		encoded = exponent<<4 | byte(s>>exponent)&0x0F
+	}
+
+	return (sign | encoded) ^ 0x55
+}
+
+// AlawToLinear decodes a G.711 A-law sample to 16-bit linear PCM
+func AlawToLinear(a byte) int16 {
+	a ^= 0x55
+	sign := a & 0x80
+	exponent := (a >> 4) & 0x07
+	mantissa := int32(a & 0x0F)
+
+	var sample int32
+	if exponent == 0 {
+		sample = mantissa<<4 + 8
+	} else {
+		sample = (mantissa<<4 + 0x108) << (exponent - 1)
+	}
+
+	if sign == 0 {
+		return int16(-sample)
+	}
+	return int16(sample)
+}
+
+// ConvertG711 re-encodes G.711 audio from one law to the other into dst, which must be
+// at least len(data) long, and returns the converted slice. Audio that is already in
+// the target codec is returned as is.
+func ConvertG711(dst, data []byte, from, to Codec) []byte {
+	if from.Name == to.Name {
+		return data
+	}
+
+	decode, encode := MulawToLinear, LinearToAlaw
+	if from.Name == "G.711alaw" {
+		decode, encode = AlawToLinear, LinearToMulaw
+	}
+	dst = dst[:len(data)]
+	for i, b := range data {
+		dst[i] = encode(decode(b))
+	}
+	return dst
+}
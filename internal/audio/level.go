@@ -0,0 +1,49 @@
+package audio
+
+import "math"
+
+// MulawRMS returns the RMS level of µ-law encoded samples, normalized to 0..1 of full scale
+func MulawRMS(data []byte) float64 {
+	if len(data) == 0 {
+		return 0
+	}
+
+	var sum float64
+	for _, b := range data {
+		s := float64(MulawToLinear(b)) / 32768
+		sum += s * s
+	}
+
+	return math.Sqrt(sum / float64(len(data)))
+}
+
+// LevelToDBFS converts a normalized RMS level to dBFS (-Inf for silence)
+func LevelToDBFS(level float64) float64 {
+	if level <= 0 {
+		return math.Inf(-1)
+	}
+	return 20 * math.Log10(level)
+}
+
+// AlawRMS returns the RMS level of A-law encoded samples, normalized to 0..1 of full scale
+func AlawRMS(data []byte) float64 {
+	if len(data) == 0 {
+		return 0
+	}
+
+	var sum float64
+	for _, b := range data {
+		s := float64(AlawToLinear(b)) / 32768
+		sum += s * s
+	}
+
+	return math.Sqrt(sum / float64(len(data)))
+}
+
+// RMS returns the RMS level of samples encoded with c, normalized to 0..1 of full scale
+func (c Codec) RMS(data []byte) float64 {
+	if c.Name == "G.711alaw" {
+		return AlawRMS(data)
+	}
+	return MulawRMS(data)
+}
@@ -0,0 +1,33 @@
+package audio
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// GenerateTone returns a sine tone at the given frequency and duration, encoded with codec.
+// amplitude is a fraction of full scale (0..1).
+func GenerateTone(codec Codec, freq float64, duration time.Duration, amplitude float64) ([]byte, error) {
+	var encode func(int16) byte
+	switch codec.Name {
+	case "G.711ulaw":
+		encode = LinearToMulaw
+	case "G.711alaw":
+		encode = LinearToAlaw
+	default:
+		return nil, fmt.Errorf("cannot generate tone for codec %s", codec.Name)
+	}
+
+	if freq <= 0 || freq >= SampleRate/2 {
+		return nil, fmt.Errorf("frequency must be between 0 and %d Hz", SampleRate/2)
+	}
+
+	samples := int(duration.Seconds() * SampleRate)
+	data := make([]byte, samples)
+	for i := range data {
+		v := amplitude * math.Sin(2*math.Pi*freq*float64(i)/SampleRate)
+		data[i] = encode(int16(v * math.MaxInt16))
+	}
+	return data, nil
+}
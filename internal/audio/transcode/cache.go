@@ -0,0 +1,97 @@
+package transcode
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+
+	"github.com/acardace/hikvision-doorbell-server/internal/audio"
+)
+
+// Cache keeps converted audio keyed by the checksum of the upload, evicting the least
+// recently used entries once the total size exceeds maxBytes
+type Cache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	order    *list.List // Front is most recently used
+	entries  map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key    string
+	data   []byte
+	report Report
+}
+
+// NewCache creates a cache holding up to maxBytes of converted audio
+func NewCache(maxBytes int64) *Cache {
+	return &Cache{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// ToCodec behaves like the package-level ToCodec but returns cached output for input
+// that was converted to the same codec and resample quality before. The returned report has Cached set on a hit.
+func (c *Cache) ToCodec(ctx context.Context, input []byte, codec audio.Codec) ([]byte, *Report, error) {
+	sum := sha256.Sum256(input)
+	key := codec.Name + ":" + ResampleQuality() + ":" + hex.EncodeToString(sum[:])
+
+	if data, report, ok := c.get(key); ok {
+		return data, report, nil
+	}
+
+	data, report, err := ToCodec(ctx, input, codec)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c.put(key, data, *report)
+	return data, report, nil
+}
+
+// get returns a copy of the cached report for key and marks it recently used
+func (c *Cache) get(key string) ([]byte, *Report, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, nil, false
+	}
+	c.order.MoveToFront(elem)
+
+	entry := elem.Value.(*cacheEntry)
+	report := entry.report
+	report.Cached = true
+	return entry.data, &report, true
+}
+
+// put stores data under key, evicting old entries to stay within maxBytes.
+// Entries larger than the whole cache are not stored.
+func (c *Cache) put(key string, data []byte, report Report) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if int64(len(data)) > c.maxBytes {
+		return
+	}
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, data: data, report: report})
+	c.size += int64(len(data))
+
+	for c.size > c.maxBytes {
+		oldest := c.order.Back()
+		entry := oldest.Value.(*cacheEntry)
+		c.order.Remove(oldest)
+		delete(c.entries, entry.key)
+		c.size -= int64(len(entry.data))
+	}
+}
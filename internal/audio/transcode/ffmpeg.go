@@ -0,0 +1,233 @@
+package transcode
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/acardace/hikvision-doorbell-server/internal/audio"
+)
+
+// ErrFFmpegNotFound is returned when ffmpeg or ffprobe is not installed
+var ErrFFmpegNotFound = errors.New("ffmpeg/ffprobe not found in PATH")
+
+// Report describes the input that was converted and what was done to it
+type Report struct {
+	InputCodec      string `json:"input_codec"`
+	InputSampleRate int    `json:"input_sample_rate"`
+	InputChannels   int    `json:"input_channels"`
+	InputLayout     string `json:"input_layout,omitempty"`
+	Downmix         string `json:"downmix,omitempty"` // ffmpeg pan filter used, empty for mono input
+	Resampled       bool   `json:"resampled"`
+	ResampleQuality string `json:"resample_quality,omitempty"` // Preset used, set when resampled
+	OutputCodec     string `json:"output_codec"`
+	OutputRate      int    `json:"output_sample_rate"`
+	OutputBytes     int    `json:"output_bytes"`
+	Cached          bool   `json:"cached"` // Served from the conversion cache
+}
+
+// ToCodec converts an audio file in any format ffmpeg understands to mono audio at
+// the G.711 sample rate, encoded with codec, through the default Transcoder
+func ToCodec(ctx context.Context, input []byte, codec audio.Codec) ([]byte, *Report, error) {
+	out, err := New().Transcode(ctx, bytes.NewReader(input), FormatAuto, FormatOf(codec))
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := io.ReadAll(out)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	report := ReportOf(out)
+	if report == nil {
+		report = &Report{OutputRate: audio.SampleRate}
+	}
+	report.OutputCodec = codec.Name
+	report.OutputBytes = len(data)
+	return data, report, nil
+}
+
+// FFmpeg transcodes with the ffmpeg binary. Output is always mono at the G.711 sample
+// rate; multichannel input is downmixed with an explicit pan filter instead of relying
+// on ffmpeg's default -ac matrix.
+type FFmpeg struct{}
+
+// Transcode runs ffmpeg over the whole input and returns its output with a Report
+func (FFmpeg) Transcode(ctx context.Context, in io.Reader, from, to Format) (io.Reader, error) {
+	if to == FormatAuto {
+		return nil, fmt.Errorf("output format is required")
+	}
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return nil, ErrFFmpegNotFound
+	}
+
+	input, err := io.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		OutputCodec: string(to),
+		OutputRate:  audio.SampleRate,
+	}
+
+	var src ffmpegInput
+	var inputArgs []string
+	if from.IsRaw() {
+		// Headerless input can't be probed; it is mono at the G.711 rate by definition
+		src = ffmpegInput{arg: "pipe:0", data: input}
+		inputArgs = []string{"-f", string(from), "-ar", strconv.Itoa(audio.SampleRate), "-ac", "1"}
+		report.InputCodec = rawCodecs[from]
+		report.InputSampleRate = audio.SampleRate
+		report.InputChannels = 1
+	} else {
+		if _, err := exec.LookPath("ffprobe"); err != nil {
+			return nil, ErrFFmpegNotFound
+		}
+
+		var cleanup func()
+		src, cleanup, err = prepareInput(input)
+		if err != nil {
+			return nil, err
+		}
+		defer cleanup()
+
+		stream, err := probe(ctx, src, from)
+		if err != nil {
+			return nil, err
+		}
+		if from != FormatAuto {
+			inputArgs = []string{"-f", string(from)}
+		}
+		report.InputCodec = stream.CodecName
+		report.InputSampleRate = stream.sampleRate()
+		report.InputChannels = stream.Channels
+		report.InputLayout = stream.ChannelLayout
+		report.Downmix = downmixFilter(stream.Channels, stream.ChannelLayout)
+	}
+
+	report.Resampled = report.InputSampleRate != audio.SampleRate
+	if report.Resampled {
+		report.ResampleQuality = ResampleQuality()
+	}
+
+	filters := []string{}
+	if report.Downmix != "" {
+		filters = append(filters, report.Downmix)
+	}
+	filters = append(filters, resampleFilter(audio.SampleRate))
+
+	args := append([]string{"-hide_banner", "-loglevel", "error"}, inputArgs...)
+	args = append(args,
+		"-i", src.arg,
+		"-vn",
+		"-af", strings.Join(filters, ","),
+		"-ar", strconv.Itoa(audio.SampleRate),
+		"-ac", "1",
+	)
+	if codec, ok := rawCodecs[to]; ok {
+		args = append(args, "-acodec", codec)
+	}
+	args = append(args, "-f", string(to), "pipe:1")
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stdin = src.stdin()
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg conversion failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	report.OutputBytes = stdout.Len()
+	return &Output{Reader: bytes.NewReader(stdout.Bytes()), Report: report}, nil
+}
+
+// probeStream holds the ffprobe fields we need for the first audio stream
+type probeStream struct {
+	CodecName     string `json:"codec_name"`
+	SampleRate    string `json:"sample_rate"`
+	Channels      int    `json:"channels"`
+	ChannelLayout string `json:"channel_layout"`
+}
+
+func (s probeStream) sampleRate() int {
+	rate, _ := strconv.Atoi(s.SampleRate)
+	return rate
+}
+
+// probe returns the first audio stream of input, read as format unless it is FormatAuto
+func probe(ctx context.Context, in ffmpegInput, format Format) (*probeStream, error) {
+	args := []string{
+		"-v", "error",
+		"-select_streams", "a:0",
+		"-show_entries", "stream=codec_name,sample_rate,channels,channel_layout",
+		"-of", "json",
+	}
+	if format != FormatAuto {
+		args = append(args, "-f", string(format))
+	}
+	cmd := exec.CommandContext(ctx, "ffprobe", append(args, in.arg)...)
+	cmd.Stdin = in.stdin()
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffprobe failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var result struct {
+		Streams []probeStream `json:"streams"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+	if len(result.Streams) == 0 {
+		return nil, fmt.Errorf("no audio stream found in input")
+	}
+
+	return &result.Streams[0], nil
+}
+
+// downmixFilter returns a pan filter that mixes all channels into one with normalized
+// gains ("<" scales the sum so it cannot clip). The LFE channel is left out since it
+// is meant for a subwoofer and only adds rumble on a doorbell speaker. Returns "" for mono.
+func downmixFilter(channels int, layout string) string {
+	if channels <= 1 {
+		return ""
+	}
+
+	lfe := lfeIndex(layout)
+	var inputs []string
+	for i := 0; i < channels; i++ {
+		if i == lfe {
+			continue
+		}
+		inputs = append(inputs, fmt.Sprintf("c%d", i))
+	}
+
+	return "pan=mono|c0<" + strings.Join(inputs, "+")
+}
+
+// lfeIndex returns the position of the LFE channel in an ffmpeg ".1" layout, or -1
+func lfeIndex(layout string) int {
+	// ffmpeg orders channels FL FR [FC] LFE ..., so LFE follows the front channels
+	switch {
+	case strings.HasPrefix(layout, "2.1"):
+		return 2
+	case strings.HasPrefix(layout, "3.1"), strings.HasPrefix(layout, "4.1"),
+		strings.HasPrefix(layout, "5.1"), strings.HasPrefix(layout, "6.1"),
+		strings.HasPrefix(layout, "7.1"):
+		return 3
+	default:
+		return -1
+	}
+}
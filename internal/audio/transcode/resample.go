@@ -0,0 +1,77 @@
+package transcode
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultResampleQuality is ffmpeg's own resampler with its default filter
+const DefaultResampleQuality = "balanced"
+
+// resampleOptions maps each quality preset to the aresample options it adds. Higher
+// presets use a longer filter with a lower cutoff, which removes more of the content
+// above 4 kHz that would otherwise alias when downsampling to 8 kHz, at some CPU cost.
+var resampleOptions = map[string]string{
+	"fast":     "filter_size=8:phase_shift=6",
+	"balanced": "",
+	"high":     "filter_size=64:phase_shift=12:cutoff=0.91",
+	"soxr":     "resampler=soxr:precision=28", // Needs ffmpeg built with libsoxr
+}
+
+var (
+	resampleMu      sync.RWMutex
+	resampleQuality = DefaultResampleQuality
+)
+
+// ResampleQualities lists the accepted quality presets
+func ResampleQualities() []string {
+	qualities := make([]string, 0, len(resampleOptions))
+	for quality := range resampleOptions {
+		qualities = append(qualities, quality)
+	}
+	sort.Strings(qualities)
+	return qualities
+}
+
+// SetResampleQuality sets the preset server-side conversions resample with (empty
+// uses DefaultResampleQuality)
+func SetResampleQuality(quality string) error {
+	if quality == "" {
+		quality = DefaultResampleQuality
+	}
+	if _, ok := resampleOptions[quality]; !ok {
+		return fmt.Errorf("unknown resample quality %q (one of %s)", quality, strings.Join(ResampleQualities(), ", "))
+	}
+
+	resampleMu.Lock()
+	resampleQuality = quality
+	resampleMu.Unlock()
+	return nil
+}
+
+// ResampleQuality returns the preset server-side conversions resample with
+func ResampleQuality() string {
+	resampleMu.RLock()
+	defer resampleMu.RUnlock()
+	return resampleQuality
+}
+
+// ResampleFilter returns the aresample filter converting to rate with a quality preset
+func ResampleFilter(rate int, quality string) (string, error) {
+	options, ok := resampleOptions[quality]
+	if !ok {
+		return "", fmt.Errorf("unknown resample quality %q (one of %s)", quality, strings.Join(ResampleQualities(), ", "))
+	}
+	if options == "" {
+		return fmt.Sprintf("aresample=%d", rate), nil
+	}
+	return fmt.Sprintf("aresample=%d:%s", rate, options), nil
+}
+
+// resampleFilter is ResampleFilter with the configured preset, which is always valid
+func resampleFilter(rate int) string {
+	filter, _ := ResampleFilter(rate, ResampleQuality())
+	return filter
+}
@@ -0,0 +1,170 @@
+package transcode
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Container is an audio file format recognized from its leading bytes
+type Container string
+
+const (
+	// ContainerRaw means no known container was found; the data is assumed to be headerless audio
+	ContainerRaw  Container = "raw"
+	ContainerWAV  Container = "wav"
+	ContainerMP3  Container = "mp3"
+	ContainerOgg  Container = "ogg"
+	ContainerFLAC Container = "flac"
+	ContainerMP4  Container = "mp4"
+	ContainerAAC  Container = "aac"
+	ContainerWebM Container = "webm"
+)
+
+// demuxers maps each container to the ffmpeg demuxer that reads it
+var demuxers = map[Container]string{
+	ContainerWAV:  "wav",
+	ContainerMP3:  "mp3",
+	ContainerOgg:  "ogg",
+	ContainerFLAC: "flac",
+	ContainerMP4:  "mp4",
+	ContainerAAC:  "aac",
+	ContainerWebM: "webm",
+}
+
+// Sniff identifies the container of an audio file from its magic numbers.
+// Anything it doesn't recognize is reported as ContainerRaw.
+func Sniff(data []byte) Container {
+	switch {
+	case len(data) >= 12 && bytes.HasPrefix(data, []byte("RIFF")) && string(data[8:12]) == "WAVE":
+		return ContainerWAV
+	case bytes.HasPrefix(data, []byte("OggS")):
+		return ContainerOgg
+	case bytes.HasPrefix(data, []byte("fLaC")):
+		return ContainerFLAC
+	case len(data) >= 8 && string(data[4:8]) == "ftyp":
+		return ContainerMP4
+	case bytes.HasPrefix(data, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		return ContainerWebM
+	case bytes.HasPrefix(data, []byte("ID3")), isMP3Stream(data):
+		return ContainerMP3
+	case isADTSStream(data):
+		return ContainerAAC
+	default:
+		return ContainerRaw
+	}
+}
+
+// MPEG audio Layer III bitrates in kbit/s, indexed by the header's bitrate index
+var (
+	mp3Bitrates1 = [15]int{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320}
+	mp3Bitrates2 = [15]int{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160}
+)
+
+// mp3FrameLength returns the length of the MPEG Layer III frame whose header starts
+// data, or 0 if it isn't a valid header
+func mp3FrameLength(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1]&0xE0 != 0xE0 {
+		return 0
+	}
+
+	version := (data[1] >> 3) & 0x03 // 0: MPEG 2.5, 2: MPEG 2, 3: MPEG 1
+	layer := (data[1] >> 1) & 0x03   // 1: Layer III
+	bitrateIndex := data[2] >> 4
+	rateIndex := (data[2] >> 2) & 0x03
+	if version == 1 || layer != 1 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+		return 0
+	}
+
+	sampleRate := [3]int{44100, 48000, 32000}[rateIndex]
+	bitrate := mp3Bitrates1[bitrateIndex]
+	samplesPerByte := 144
+	switch version {
+	case 2:
+		sampleRate /= 2
+		bitrate = mp3Bitrates2[bitrateIndex]
+		samplesPerByte = 72
+	case 0:
+		sampleRate /= 4
+		bitrate = mp3Bitrates2[bitrateIndex]
+		samplesPerByte = 72
+	}
+
+	padding := int(data[2]>>1) & 0x01
+	return samplesPerByte*bitrate*1000/sampleRate + padding
+}
+
+// isMP3Stream reports whether data starts with two consecutive MP3 frames. A single
+// header check is not enough since raw µ-law silence (0xFF) looks like a sync word.
+func isMP3Stream(data []byte) bool {
+	n := mp3FrameLength(data)
+	return n > 0 && mp3FrameLength(data[min(n, len(data)):]) > 0
+}
+
+// adtsFrameLength returns the length of the ADTS (raw AAC) frame whose header starts
+// data, or 0 if it isn't a valid header
+func adtsFrameLength(data []byte) int {
+	if len(data) < 7 || data[0] != 0xFF || data[1]&0xF6 != 0xF0 {
+		return 0
+	}
+	if (data[2]>>2)&0x0F > 12 { // Sampling frequency index
+		return 0
+	}
+
+	n := int(data[3]&0x03)<<11 | int(data[4])<<3 | int(data[5])>>5
+	if n < 7 {
+		return 0
+	}
+	return n
+}
+
+// isADTSStream reports whether data starts with two consecutive ADTS frames
+func isADTSStream(data []byte) bool {
+	n := adtsFrameLength(data)
+	return n > 0 && adtsFrameLength(data[min(n, len(data)):]) > 0
+}
+
+var (
+	demuxersOnce sync.Once
+	ffmpegDemux  string // Output of ffmpeg -demuxers, empty if ffmpeg is missing
+)
+
+// CanDecode reports whether the installed ffmpeg has a demuxer for c.
+// The list is read from ffmpeg once and reused.
+func CanDecode(ctx context.Context, c Container) (bool, error) {
+	demuxer, ok := demuxers[c]
+	if !ok {
+		return false, nil
+	}
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return false, ErrFFmpegNotFound
+	}
+
+	demuxersOnce.Do(func() {
+		out, err := exec.CommandContext(ctx, "ffmpeg", "-hide_banner", "-demuxers").Output()
+		if err == nil {
+			ffmpegDemux = string(out)
+		}
+	})
+
+	// If the list couldn't be read, let the conversion itself report the problem
+	if ffmpegDemux == "" {
+		return true, nil
+	}
+
+	// Lines look like " D  mov,mp4,m4a,3gp,3g2,mj2 QuickTime / MOV"
+	for _, line := range strings.Split(ffmpegDemux, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.Contains(fields[0], "D") {
+			continue
+		}
+		for _, name := range strings.Split(fields[1], ",") {
+			if name == demuxer {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,170 @@
+package transcode
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/acardace/hikvision-doorbell-server/internal/audio"
+)
+
+// Format identifies an audio encoding. The raw formats are headerless mono audio at
+// the G.711 sample rate; any other value is passed to ffmpeg as a container format
+// name (e.g. "wav", "mp3", "ogg").
+type Format string
+
+const (
+	// FormatAuto lets ffmpeg detect the input format (input only)
+	FormatAuto Format = ""
+
+	// FormatMulaw is raw G.711 µ-law
+	FormatMulaw Format = "mulaw"
+
+	// FormatAlaw is raw G.711 A-law
+	FormatAlaw Format = "alaw"
+
+	// FormatPCM16 is raw signed 16-bit little-endian linear PCM
+	FormatPCM16 Format = "s16le"
+)
+
+// rawCodecs maps the raw formats to the ffmpeg encoder that produces them
+var rawCodecs = map[Format]string{
+	FormatMulaw: "pcm_mulaw",
+	FormatAlaw:  "pcm_alaw",
+	FormatPCM16: "pcm_s16le",
+}
+
+// IsRaw reports whether f is one of the headerless 8 kHz mono formats
+func (f Format) IsRaw() bool {
+	_, ok := rawCodecs[f]
+	return ok
+}
+
+// FormatOf returns the raw format of a G.711 codec
+func FormatOf(codec audio.Codec) Format {
+	return Format(codec.FFmpegFormat)
+}
+
+// Transcoder converts audio from one format to another
+type Transcoder interface {
+	Transcode(ctx context.Context, in io.Reader, from, to Format) (io.Reader, error)
+}
+
+// Output is what the transcoders in this package return: the converted audio along
+// with a report of what was done to it
+type Output struct {
+	*bytes.Reader
+	Report *Report
+}
+
+// ReportOf returns the report of a Transcode result, or nil if the transcoder that
+// produced it doesn't provide one
+func ReportOf(r io.Reader) *Report {
+	if out, ok := r.(*Output); ok {
+		return out.Report
+	}
+	return nil
+}
+
+// New returns the default transcoder: conversions between raw formats are done in Go,
+// anything else goes through ffmpeg
+func New() Transcoder {
+	return &auto{g711: G711{}, ffmpeg: FFmpeg{}}
+}
+
+// auto dispatches to the pure-Go path when it can handle the conversion
+type auto struct {
+	g711   G711
+	ffmpeg FFmpeg
+}
+
+func (a *auto) Transcode(ctx context.Context, in io.Reader, from, to Format) (io.Reader, error) {
+	if from.IsRaw() && to.IsRaw() {
+		return a.g711.Transcode(ctx, in, from, to)
+	}
+	return a.ffmpeg.Transcode(ctx, in, from, to)
+}
+
+// G711 converts between the raw formats (µ-law, A-law and 16-bit linear PCM) without
+// ffmpeg. The sample rate and channel count are unchanged.
+type G711 struct{}
+
+// Transcode decodes every sample of in to linear PCM and re-encodes it as to
+func (G711) Transcode(ctx context.Context, in io.Reader, from, to Format) (io.Reader, error) {
+	if !from.IsRaw() || !to.IsRaw() {
+		return nil, fmt.Errorf("G.711 transcoder only converts raw formats, not %q to %q", from, to)
+	}
+
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+	out := data
+	if from != to {
+		samples, err := decodeRaw(data, from)
+		if err != nil {
+			return nil, err
+		}
+		out = encodeRaw(samples, to)
+	}
+
+	report := &Report{
+		InputCodec:      rawCodecs[from],
+		InputSampleRate: audio.SampleRate,
+		InputChannels:   1,
+		OutputCodec:     string(to),
+		OutputRate:      audio.SampleRate,
+		OutputBytes:     len(out),
+	}
+	return &Output{Reader: bytes.NewReader(out), Report: report}, nil
+}
+
+// decodeRaw decodes raw audio to linear PCM samples
+func decodeRaw(data []byte, f Format) ([]int16, error) {
+	switch f {
+	case FormatMulaw, FormatAlaw:
+		decode := audio.MulawToLinear
+		if f == FormatAlaw {
+			decode = audio.AlawToLinear
+		}
+		samples := make([]int16, len(data))
+		for i, b := range data {
+			samples[i] = decode(b)
+		}
+		return samples, nil
+	case FormatPCM16:
+		if len(data)%2 != 0 {
+			return nil, fmt.Errorf("16-bit PCM input has an odd number of bytes (%d)", len(data))
+		}
+		samples := make([]int16, len(data)/2)
+		for i := range samples {
+			samples[i] = int16(binary.LittleEndian.Uint16(data[2*i:]))
+		}
+		return samples, nil
+	default:
+		return nil, fmt.Errorf("unsupported raw format %q", f)
+	}
+}
+
+// encodeRaw encodes linear PCM samples as raw audio in f, which must be a raw format
+func encodeRaw(samples []int16, f Format) []byte {
+	if f == FormatPCM16 {
+		out := make([]byte, 2*len(samples))
+		for i, s := range samples {
+			binary.LittleEndian.PutUint16(out[2*i:], uint16(s))
+		}
+		return out
+	}
+
+	encode := audio.LinearToMulaw
+	if f == FormatAlaw {
+		encode = audio.LinearToAlaw
+	}
+	out := make([]byte, len(samples))
+	for i, s := range samples {
+		out[i] = encode(s)
+	}
+	return out
+}
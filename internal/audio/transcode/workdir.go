@@ -0,0 +1,101 @@
+package transcode
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// tempPattern names the temp files conversions create, so leftovers can be found
+const tempPattern = "doorbell-convert-*"
+
+var (
+	workDirMu sync.RWMutex
+	workDir   string // Empty uses os.TempDir()
+)
+
+// SetWorkDir sets the directory conversions write temp files to (empty uses
+// os.TempDir()) and removes temp files a previous run left behind in it
+func SetWorkDir(dir string) error {
+	if dir != "" {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return fmt.Errorf("invalid work directory: %w", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("invalid work directory %s: not a directory", dir)
+		}
+	}
+
+	workDirMu.Lock()
+	workDir = dir
+	workDirMu.Unlock()
+
+	leftovers, _ := filepath.Glob(filepath.Join(WorkDir(), tempPattern))
+	for _, path := range leftovers {
+		if err := os.Remove(path); err == nil {
+			log.Printf("[Transcode] Removed stale temp file %s", path)
+		}
+	}
+	return nil
+}
+
+// WorkDir returns the directory conversions write temp files to
+func WorkDir() string {
+	workDirMu.RLock()
+	defer workDirMu.RUnlock()
+
+	if workDir == "" {
+		return os.TempDir()
+	}
+	return workDir
+}
+
+// ffmpegInput is how ffmpeg and ffprobe read an upload: from stdin, or from a temp
+// file for containers that need seeking
+type ffmpegInput struct {
+	arg  string // Value for -i: "pipe:0" or the temp file path
+	data []byte // Fed on stdin when reading from the pipe
+}
+
+// stdin returns the reader to attach to the command's stdin, nil when reading a file
+func (in ffmpegInput) stdin() io.Reader {
+	if in.arg != "pipe:0" {
+		return nil
+	}
+	return bytes.NewReader(in.data)
+}
+
+// prepareInput streams input through a pipe when possible. MP4/M4A files usually keep
+// their index at the end and can't be decoded from a pipe, so they are written to a
+// temp file in WorkDir. The returned cleanup removes it and must always be called.
+func prepareInput(input []byte) (ffmpegInput, func(), error) {
+	if Sniff(input) != ContainerMP4 {
+		return ffmpegInput{arg: "pipe:0", data: input}, func() {}, nil
+	}
+
+	f, err := os.CreateTemp(WorkDir(), tempPattern)
+	if err != nil {
+		return ffmpegInput{}, nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	cleanup := func() {
+		if err := os.Remove(f.Name()); err != nil && !os.IsNotExist(err) {
+			log.Printf("[Transcode] Failed to remove temp file %s: %v", f.Name(), err)
+		}
+	}
+
+	_, err = f.Write(input)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		cleanup()
+		return ffmpegInput{}, nil, fmt.Errorf("failed to write temp file: %w", err)
+	}
+
+	return ffmpegInput{arg: f.Name()}, cleanup, nil
+}
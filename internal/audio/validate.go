@@ -0,0 +1,60 @@
+package audio
+
+import "fmt"
+
+// minValidateBytes is the shortest audio ValidateG711 checks; shorter clips give too
+// few samples for the byte statistics to mean anything
+const minValidateBytes = 1600 // 200ms of G.711
+
+// ValidateG711 reports whether data plausibly holds audio encoded with codec, to catch
+// other formats uploaded as raw G.711 (which the doorbell plays as static). It is a
+// heuristic that rejects obvious mistakes, not proof that data is valid:
+//   - text, which is almost all printable ASCII, unlike G.711 where half the samples
+//     have the top bit set
+//   - mostly zero bytes, which G.711 never encodes silence as
+//   - 16-bit PCM, whose high and low bytes are distributed very differently, while the
+//     even and odd bytes of 8-bit G.711 follow the same distribution
+func ValidateG711(codec Codec, data []byte) error {
+	if len(data) < minValidateBytes {
+		return nil
+	}
+
+	var printable, zeros int
+	var even, odd [256]int
+	for i, b := range data {
+		if (b >= 0x20 && b < 0x7F) || b == '\t' || b == '\n' || b == '\r' {
+			printable++
+		}
+		if b == 0 {
+			zeros++
+		}
+		if i%2 == 0 {
+			even[b]++
+		} else {
+			odd[b]++
+		}
+	}
+
+	n := float64(len(data))
+	if float64(printable)/n > 0.95 {
+		return fmt.Errorf("data looks like text, not %s audio", codec.Name)
+	}
+	if codec.Silence != 0 && float64(zeros)/n > 0.5 {
+		return fmt.Errorf("data is mostly zero bytes, not %s audio (%s silence is 0x%02X)", codec.Name, codec.Name, codec.Silence)
+	}
+
+	// Total variation distance between the even and odd byte distributions
+	evenTotal, oddTotal := float64((len(data)+1)/2), float64(len(data)/2)
+	distance := 0.0
+	for b := range even {
+		d := float64(even[b])/evenTotal - float64(odd[b])/oddTotal
+		if d < 0 {
+			d = -d
+		}
+		distance += d
+	}
+	if distance/2 > 0.5 {
+		return fmt.Errorf("data looks like 16-bit PCM, not 8-bit %s audio", codec.Name)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+// WAVStreamingSize is used as the data length for WAV streams of unknown length.
+// Players treat it as "until end of file".
+const WAVStreamingSize = 0xFFFFFFFF
+
+// WAVHeader returns a 44-byte RIFF/WAVE header for mono audio in codec c
+// with dataSize bytes of sample data
+func (c Codec) WAVHeader(dataSize uint32) []byte {
+	const headerSize = 44
+
+	riffSize := uint32(WAVStreamingSize)
+	if dataSize != WAVStreamingSize {
+		riffSize = dataSize + headerSize - 8
+	}
+
+	h := make([]byte, headerSize)
+	copy(h[0:], "RIFF")
+	binary.LittleEndian.PutUint32(h[4:], riffSize)
+	copy(h[8:], "WAVE")
+
+	copy(h[12:], "fmt ")
+	binary.LittleEndian.PutUint32(h[16:], 16) // fmt chunk size
+	binary.LittleEndian.PutUint16(h[20:], c.WAVFormat)
+	binary.LittleEndian.PutUint16(h[22:], 1) // mono
+	binary.LittleEndian.PutUint32(h[24:], uint32(c.SampleRate))
+	binary.LittleEndian.PutUint32(h[28:], uint32(c.BytesPerSecond))
+	binary.LittleEndian.PutUint16(h[32:], BytesPerSample) // block align
+	binary.LittleEndian.PutUint16(h[34:], 8*BytesPerSample)
+
+	copy(h[36:], "data")
+	binary.LittleEndian.PutUint32(h[40:], dataSize)
+	return h
+}
+
+// WAVInfo describes a WAV file as read from its header
+type WAVInfo struct {
+	Format         uint16
+	Channels       uint16
+	SampleRate     int
+	BytesPerSecond int
+	DataSize       int64 // Size of the data chunk; WAVStreamingSize if unknown
+}
+
+// Duration returns the playback length of the data chunk (0 for streamed files)
+func (i WAVInfo) Duration() time.Duration {
+	if i.BytesPerSecond == 0 || i.DataSize == WAVStreamingSize {
+		return 0
+	}
+	return time.Duration(i.DataSize * int64(time.Second) / int64(i.BytesPerSecond))
+}
+
+// ReadWAVInfo parses the RIFF header of a WAV file up to the start of its data chunk
+func ReadWAVInfo(r io.Reader) (WAVInfo, error) {
+	var info WAVInfo
+
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return info, err
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return info, errors.New("not a WAV file")
+	}
+
+	haveFmt := false
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return info, err
+		}
+		size := int64(binary.LittleEndian.Uint32(chunk[4:]))
+
+		switch string(chunk[0:4]) {
+		case "fmt ":
+			if size < 16 {
+				return info, errors.New("invalid WAV fmt chunk")
+			}
+			var fmtChunk [16]byte
+			if _, err := io.ReadFull(r, fmtChunk[:]); err != nil {
+				return info, err
+			}
+			info.Format = binary.LittleEndian.Uint16(fmtChunk[0:])
+			info.Channels = binary.LittleEndian.Uint16(fmtChunk[2:])
+			info.SampleRate = int(binary.LittleEndian.Uint32(fmtChunk[4:]))
+			info.BytesPerSecond = int(binary.LittleEndian.Uint32(fmtChunk[8:]))
+			size -= 16
+			haveFmt = true
+		case "data":
+			if !haveFmt {
+				return info, errors.New("WAV data chunk before fmt chunk")
+			}
+			info.DataSize = size
+			return info, nil
+		}
+
+		// Chunks are padded to an even size
+		if _, err := io.CopyN(io.Discard, r, size+size%2); err != nil {
+			return info, err
+		}
+	}
+}
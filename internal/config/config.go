@@ -1,25 +1,406 @@
 package config
 
 import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/acardace/hikvision-doorbell-server/internal/audio"
+	"github.com/acardace/hikvision-doorbell-server/internal/retry"
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
 	Server    ServerConfig    `yaml:"server"`
 	Hikvision HikvisionConfig `yaml:"hikvision"`
+	PlayFile  PlayFileConfig  `yaml:"play_file"`
+	Log       LogConfig       `yaml:"log"`
+	Webhook   WebhookConfig   `yaml:"webhook"`
+
+	// path is the file the configuration was loaded from
+	path string
 }
 
 type ServerConfig struct {
+	// Host and Port are the listen address (empty Host binds all interfaces).
+	// LISTEN_ADDR (e.g. "192.168.1.5:8080") overrides both.
 	Host string `yaml:"host"`
 	Port int    `yaml:"port"`
+
+	// MaxBodyBytes limits request bodies on all API routes
+	MaxBodyBytes int64 `yaml:"max_body_bytes"`
+
+	// PlayFileMaxBodyBytes overrides MaxBodyBytes for play-file uploads
+	PlayFileMaxBodyBytes int64 `yaml:"play_file_max_body_bytes"`
+
+	// CORSOrigins lists the origins allowed to call the API ("*" allows any origin)
+	CORSOrigins []string `yaml:"cors_origins"`
+
+	// Compression gzips JSON responses for clients that accept it
+	Compression bool `yaml:"compression"`
+
+	// AdminToken is the bearer token required by /api/admin endpoints (empty disables them)
+	AdminToken string `yaml:"admin_token"`
+
+	// WorkDir is where conversions write temp files (empty uses the system temp directory)
+	WorkDir string `yaml:"work_dir"`
+
+	// RecordingDir is where WAV recordings are served from by /api/recordings (empty disables)
+	RecordingDir string `yaml:"recording_dir"`
+
+	// AudioSocket is a Unix socket path where live doorbell audio is served to local
+	// clients (empty disables)
+	AudioSocket string `yaml:"audio_socket"`
+
+	// UnixSocket is a Unix socket path the API is also served on (empty disables).
+	// UnixSocketMode is the socket file's permissions as an octal string (default "0660").
+	UnixSocket     string `yaml:"unix_socket"`
+	UnixSocketMode string `yaml:"unix_socket_mode"`
+
+	// DisableTCP serves the API only on UnixSocket
+	DisableTCP bool `yaml:"disable_tcp"`
 }
 
 type HikvisionConfig struct {
 	Host     string `yaml:"host"`
 	Username string `yaml:"username"`
 	Password string `yaml:"password"`
+
+	// UsernameFile and PasswordFile read credentials from files (e.g. mounted secrets).
+	// They can also be set with DEVICE_USERNAME_FILE / DEVICE_PASSWORD_FILE.
+	UsernameFile string `yaml:"username_file"`
+	PasswordFile string `yaml:"password_file"`
+
+	// ReaderStallTimeout restarts the audio reader if the device stops sending data
+	// for this long (e.g. "10s"). Zero disables the watchdog.
+	ReaderStallTimeout time.Duration `yaml:"reader_stall_timeout"`
+
+	// ReplayBuffer is how much recent doorbell audio is kept and replayed to a WebRTC
+	// client once it connects, so it hears what was said while connecting (0 disables,
+	// at most maxReplayBuffer)
+	ReplayBuffer time.Duration `yaml:"replay_buffer"`
+
+	// StreamSocket tunes the TCP connection live audio is written to the device over
+	StreamSocket StreamSocketConfig `yaml:"stream_socket"`
+
+	// RTSPPort is the device's RTSP port, used in video stream URLs (0 uses 554)
+	RTSPPort int `yaml:"rtsp_port"`
+
+	// Proxy is an http:// proxy URL for reaching the device (empty uses HTTP_PROXY / NO_PROXY)
+	Proxy string `yaml:"proxy"`
+
+	// ChannelCacheTTL is how long the channel list is reused between operations (0 disables)
+	ChannelCacheTTL time.Duration `yaml:"channel_cache_ttl"`
+
+	// ReopenDelay is how long a closed channel is left before it is opened again,
+	// for devices that release channels asynchronously (0 disables)
+	ReopenDelay time.Duration `yaml:"reopen_delay"`
+
+	// ChannelPolicy picks among free channels: "first" (the first free one) or
+	// "round_robin" (the next free one after the channel used last)
+	ChannelPolicy string `yaml:"channel_policy"`
+
+	// Retry tunes reconnects of the audio streams and retries of ISAPI requests
+	Retry RetryConfig `yaml:"retry"`
+
+	// CircuitBreaker fails device requests fast after repeated connection failures
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"`
+
+	// AudioData selects which query parameters the firmware expects on audioData URLs
+	AudioData AudioDataConfig `yaml:"audio_data"`
+
+	// ChannelHooks are extra requests around opening and closing channels, for
+	// intercoms that require a channel to be claimed before it can be opened
+	ChannelHooks ChannelHooksConfig `yaml:"channel_hooks"`
+
+	// ArmMode locates the scene/arming mode setting served by /api/device/arm-mode
+	ArmMode ArmModeConfig `yaml:"arm_mode"`
+}
+
+type StreamSocketConfig struct {
+	// NoDelay sets TCP_NODELAY so small audio writes are not batched (default true)
+	NoDelay bool `yaml:"no_delay"`
+
+	// WriteBuffer is the kernel send buffer size in bytes (0 keeps the system default)
+	WriteBuffer int `yaml:"write_buffer"`
+}
+
+type ArmModeConfig struct {
+	// Path is the ISAPI document holding the mode
+	Path string `yaml:"path"`
+
+	// Element is the element of that document whose text is the mode
+	Element string `yaml:"element"`
+}
+
+type ChannelHooksConfig struct {
+	// Claim is called before a channel is opened
+	Claim ChannelHookConfig `yaml:"claim"`
+
+	// Release is called after a channel is closed (or fails to open after a claim)
+	Release ChannelHookConfig `yaml:"release"`
+}
+
+type ChannelHookConfig struct {
+	// Method is the HTTP method (default PUT)
+	Method string `yaml:"method"`
+
+	// Path is the ISAPI path to call; "{id}" is replaced with the channel ID (empty disables)
+	Path string `yaml:"path"`
+}
+
+// validate checks the hook path is absolute
+func (c ChannelHookConfig) validate(name string) error {
+	if c.Path != "" && !strings.HasPrefix(c.Path, "/") {
+		return fmt.Errorf("invalid channel_hooks.%s path %q: must start with /", name, c.Path)
+	}
+	return nil
+}
+
+type RetryConfig struct {
+	// Stream applies to audio stream connects and reader reconnects after errors
+	Stream retry.Policy `yaml:"stream"`
+
+	// Request applies to ISAPI requests the device answers with a bare 401
+	Request retry.Policy `yaml:"request"`
+}
+
+type CircuitBreakerConfig struct {
+	// Failures is how many consecutive connection failures open the breaker (0 disables)
+	Failures int `yaml:"failures"`
+
+	// Cooldown is how long requests fail fast before the device is probed again
+	Cooldown time.Duration `yaml:"cooldown"`
+}
+
+type AudioDataConfig struct {
+	// Profile is a built-in parameter set: default, session-id or no-session-id
+	Profile string `yaml:"profile"`
+
+	// ReaderSessionID and WriterSessionID override the profile when set
+	ReaderSessionID *bool `yaml:"reader_session_id"`
+	WriterSessionID *bool `yaml:"writer_session_id"`
+
+	// ChannelID adds channelID=<id> to audioData URLs
+	ChannelID bool `yaml:"channel_id"`
+
+	// SessionIDHeader sends the session ID in this header instead of the query string
+	SessionIDHeader string `yaml:"session_id_header"`
+}
+
+// ListenAddr returns the host:port the server binds to
+func (c ServerConfig) ListenAddr() string {
+	return net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+}
+
+// applyListenAddr overrides Host and Port from LISTEN_ADDR, if set, and validates them
+func (c *ServerConfig) applyListenAddr() error {
+	if addr := os.Getenv("LISTEN_ADDR"); addr != "" {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return fmt.Errorf("invalid LISTEN_ADDR %q: %w", addr, err)
+		}
+		c.Host = host
+		c.Port, err = strconv.Atoi(port)
+		if err != nil {
+			return fmt.Errorf("invalid LISTEN_ADDR %q: port must be a number", addr)
+		}
+	}
+
+	if c.Port < 1 || c.Port > 65535 {
+		return fmt.Errorf("invalid server port %d: must be between 1 and 65535", c.Port)
+	}
+	return nil
+}
+
+// UnixSocketPerm parses UnixSocketMode
+func (c ServerConfig) UnixSocketPerm() (os.FileMode, error) {
+	mode, err := strconv.ParseUint(c.UnixSocketMode, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("invalid server.unix_socket_mode %q: must be octal permissions such as 0660", c.UnixSocketMode)
+	}
+	return os.FileMode(mode), nil
+}
+
+// validateUnixSocket checks the Unix socket options are consistent
+func (c ServerConfig) validateUnixSocket() error {
+	if c.DisableTCP && c.UnixSocket == "" {
+		return fmt.Errorf("server.disable_tcp requires server.unix_socket")
+	}
+	if c.UnixSocket != "" && c.UnixSocket == c.AudioSocket {
+		return fmt.Errorf("server.unix_socket and server.audio_socket must be different paths")
+	}
+	_, err := c.UnixSocketPerm()
+	return err
+}
+
+// loadCredentialFiles overrides Username/Password with the contents of their files, if set
+func (c *HikvisionConfig) loadCredentialFiles() error {
+	if file := os.Getenv("DEVICE_USERNAME_FILE"); file != "" {
+		c.UsernameFile = file
+	}
+	if file := os.Getenv("DEVICE_PASSWORD_FILE"); file != "" {
+		c.PasswordFile = file
+	}
+
+	if c.UsernameFile != "" {
+		data, err := os.ReadFile(c.UsernameFile)
+		if err != nil {
+			return fmt.Errorf("failed to read username file: %w", err)
+		}
+		c.Username = trimNewline(string(data))
+	}
+
+	if c.PasswordFile != "" {
+		data, err := os.ReadFile(c.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("failed to read password file: %w", err)
+		}
+		c.Password = trimNewline(string(data))
+	}
+
+	return nil
+}
+
+// trimNewline strips the single trailing newline editors and echo add to a secret file.
+// Other whitespace is kept since it may be part of the credential.
+func trimNewline(s string) string {
+	if trimmed, ok := strings.CutSuffix(s, "\r\n"); ok {
+		return trimmed
+	}
+	return strings.TrimSuffix(s, "\n")
+}
+
+type PlayFileConfig struct {
+	// DisablePacing pushes file audio to the device as fast as the connection accepts it,
+	// relying on the device to buffer and clock playback
+	DisablePacing bool `yaml:"disable_pacing"`
+
+	// Codec is the format uploaded files are expected in (and the CLI conversion target),
+	// using Hikvision audioCompressionType names such as "G.711ulaw" or "G.711alaw"
+	Codec string `yaml:"codec"`
+
+	// FallbackCodecs are tried in order when converting an upload to Codec fails (for
+	// example when ffmpeg lacks the encoder); ones the device doesn't list are skipped
+	FallbackCodecs []string `yaml:"fallback_codecs"`
+
+	// PreRoll is silence played before each file so devices whose amplifier takes a
+	// moment to power up don't clip the start (0 disables)
+	PreRoll time.Duration `yaml:"pre_roll"`
+
+	// ValidateRaw rejects uploads played without conversion whose bytes don't look like
+	// G.711 (text, 16-bit PCM, zero-filled data) instead of playing them as static
+	ValidateRaw bool `yaml:"validate_raw"`
+
+	// FadeIn and FadeOut ramp the file's volume up at the start and down at the end so
+	// announcements don't start and stop abruptly (0 disables)
+	FadeIn  time.Duration `yaml:"fade_in"`
+	FadeOut time.Duration `yaml:"fade_out"`
+
+	// ResampleQuality is the resampler preset conversions use: fast, balanced, high or
+	// soxr (needs ffmpeg built with libsoxr)
+	ResampleQuality string `yaml:"resample_quality"`
+
+	// ConversionCacheBytes caps the cache of converted uploads keyed by checksum (0 disables)
+	ConversionCacheBytes int64 `yaml:"conversion_cache_bytes"`
+
+	// MaxDecodedBytes caps the audio decoded from a JSON (base64) play-file request (0 disables)
+	MaxDecodedBytes int64 `yaml:"max_decoded_bytes"`
+
+	// AllowedTypes lists the MIME types ("audio/*" for any subtype) and file extensions
+	// (".wav") accepted for upload; anything else is rejected with 415 (empty accepts all)
+	AllowedTypes []string `yaml:"allowed_types"`
+
+	// MaxQueue is how many play-file requests may wait for a busy channel; beyond that they
+	// get 503. 0 disables queueing and busy requests get 409 right away.
+	MaxQueue int `yaml:"max_queue"`
+
+	// QueueTimeout is how long a queued request waits before giving up with 503
+	// (overridable per request with ?queue_timeout=)
+	QueueTimeout time.Duration `yaml:"queue_timeout"`
+
+	// RecordTail is how long a record=true request keeps recording after playback ends,
+	// to capture the visitor's response
+	RecordTail time.Duration `yaml:"record_tail"`
+}
+
+// maxReplayBuffer caps hikvision.replay_buffer; a longer replay delays live audio by as much
+const maxReplayBuffer = 5 * time.Second
+
+// DefaultAllowedTypes accepts common audio formats plus raw G.711
+var DefaultAllowedTypes = []string{
+	"audio/*",
+	".wav", ".mp3", ".ogg", ".oga", ".opus", ".flac", ".m4a", ".aac", ".webm",
+	".raw", ".pcm", ".ulaw", ".alaw", ".g711", ".bin",
+}
+
+// normalizeAllowedTypes lowercases the allowlist and checks each entry is a MIME type or extension
+func (c *PlayFileConfig) normalizeAllowedTypes() error {
+	for i, entry := range c.AllowedTypes {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if !strings.HasPrefix(entry, ".") && !strings.Contains(entry, "/") {
+			return fmt.Errorf("invalid play_file.allowed_types entry %q: must be a MIME type or an extension starting with .", c.AllowedTypes[i])
+		}
+		c.AllowedTypes[i] = entry
+	}
+	return nil
+}
+
+type WebhookConfig struct {
+	// URL receives a JSON POST when a WebRTC or play-file session starts and ends (empty disables)
+	URL string `yaml:"url"`
+
+	// Timeout bounds each delivery attempt
+	Timeout time.Duration `yaml:"timeout"`
+
+	// Retry applies to deliveries that fail with a connection error, 5xx or 429
+	Retry retry.Policy `yaml:"retry"`
+}
+
+// validate checks the webhook URL is an absolute http(s) URL
+func (c WebhookConfig) validate() error {
+	if c.URL == "" {
+		return nil
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook url %q: must be an http:// or https:// URL", c.URL)
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("invalid webhook timeout: must be positive")
+	}
+	return nil
+}
+
+type LogConfig struct {
+	// Level is the minimum log level (debug, info, warn, error)
+	Level string `yaml:"level"`
+
+	// Format is the log output format (text or json)
+	Format string `yaml:"format"`
+
+	// File writes logs to this path instead of stdout; send SIGHUP to reopen it after rotation
+	File string `yaml:"file"`
+}
+
+// SlogLevel parses Level into a slog.Level
+func (c LogConfig) SlogLevel() (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Level)); err != nil {
+		return level, fmt.Errorf("invalid log level %q: %w", c.Level, err)
+	}
+	return level, nil
+}
+
+// Path returns the file the configuration was loaded from
+func (c *Config) Path() string {
+	return c.path
 }
 
 func Load(path string) (*Config, error) {
@@ -28,10 +409,138 @@ func Load(path string) (*Config, error) {
 		return nil, err
 	}
 
-	var cfg Config
+	cfg := Config{
+		Server: ServerConfig{
+			Port:                 8080,
+			MaxBodyBytes:         1 << 20,  // 1 MB
+			PlayFileMaxBodyBytes: 10 << 20, // 10 MB
+			CORSOrigins:          []string{"*"},
+			Compression:          true,
+			UnixSocketMode:       "0660",
+		},
+		Hikvision: HikvisionConfig{
+			ChannelCacheTTL: 2 * time.Second,
+			ReopenDelay:     250 * time.Millisecond,
+			ChannelPolicy:   "first",
+			Retry: RetryConfig{
+				Stream:  retry.None,
+				Request: retry.Policy{MaxAttempts: 2},
+			},
+			CircuitBreaker: CircuitBreakerConfig{
+				Cooldown: 30 * time.Second,
+			},
+			AudioData: AudioDataConfig{
+				Profile: "default",
+			},
+			ArmMode: ArmModeConfig{
+				Path:    "/ISAPI/VideoIntercom/scene/nowMode",
+				Element: "nowMode",
+			},
+			StreamSocket: StreamSocketConfig{
+				NoDelay: true,
+			},
+		},
+		PlayFile: PlayFileConfig{
+			Codec:           audio.DefaultCodec,
+			MaxDecodedBytes: 7 << 20, // 7 MB, what a 10 MB body of base64 can hold
+			AllowedTypes:    slices.Clone(DefaultAllowedTypes),
+			QueueTimeout:    30 * time.Second,
+			RecordTail:      10 * time.Second,
+			ResampleQuality: "balanced",
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "text",
+		},
+		Webhook: WebhookConfig{
+			Timeout: 5 * time.Second,
+			Retry: retry.Policy{
+				MaxAttempts: 3,
+				BaseDelay:   time.Second,
+				MaxDelay:    30 * time.Second,
+				Multiplier:  2,
+			},
+		},
+		path: path,
+	}
 	if err := yaml.Unmarshal(data, &cfg); err != nil {
 		return nil, err
 	}
 
+	if err := cfg.Server.applyListenAddr(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Server.validateUnixSocket(); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Hikvision.loadCredentialFiles(); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Hikvision.ChannelHooks.Claim.validate("claim"); err != nil {
+		return nil, err
+	}
+	if err := cfg.Hikvision.ChannelHooks.Release.validate("release"); err != nil {
+		return nil, err
+	}
+
+	if am := cfg.Hikvision.ArmMode; !strings.HasPrefix(am.Path, "/") || am.Element == "" {
+		return nil, fmt.Errorf("invalid arm_mode: path must start with / and element must be set")
+	}
+
+	if rb := cfg.Hikvision.ReplayBuffer; rb < 0 || rb > maxReplayBuffer {
+		return nil, fmt.Errorf("invalid replay_buffer: must be between 0 and %s", maxReplayBuffer)
+	}
+
+	if cfg.Hikvision.StreamSocket.WriteBuffer < 0 {
+		return nil, fmt.Errorf("invalid stream_socket: write_buffer must not be negative")
+	}
+
+	if p := cfg.Hikvision.ChannelPolicy; p != "first" && p != "round_robin" {
+		return nil, fmt.Errorf("unsupported channel_policy: %s (must be first or round_robin)", p)
+	}
+
+	if cb := cfg.Hikvision.CircuitBreaker; cb.Failures < 0 || cb.Cooldown < 0 {
+		return nil, fmt.Errorf("invalid circuit_breaker: failures and cooldown must not be negative")
+	}
+
+	if cfg.PlayFile.MaxQueue < 0 || cfg.PlayFile.QueueTimeout <= 0 {
+		return nil, fmt.Errorf("invalid play_file queue: max_queue must not be negative and queue_timeout must be positive")
+	}
+
+	if cfg.PlayFile.RecordTail < 0 {
+		return nil, fmt.Errorf("invalid play_file.record_tail: must not be negative")
+	}
+
+	if cfg.PlayFile.FadeIn < 0 || cfg.PlayFile.FadeOut < 0 {
+		return nil, fmt.Errorf("invalid play_file fade: fade_in and fade_out must not be negative")
+	}
+
+	if err := cfg.PlayFile.normalizeAllowedTypes(); err != nil {
+		return nil, err
+	}
+
+	if _, ok := audio.LookupCodec(cfg.PlayFile.Codec); !ok {
+		return nil, fmt.Errorf("unsupported play_file codec: %s", cfg.PlayFile.Codec)
+	}
+	for _, name := range cfg.PlayFile.FallbackCodecs {
+		if _, ok := audio.LookupCodec(name); !ok {
+			return nil, fmt.Errorf("unsupported play_file.fallback_codecs entry: %s", name)
+		}
+	}
+
+	if err := cfg.Webhook.validate(); err != nil {
+		return nil, err
+	}
+
+	if _, err := cfg.Log.SlogLevel(); err != nil {
+		return nil, err
+	}
+
+	if cfg.Log.Format != "text" && cfg.Log.Format != "json" {
+		return nil, fmt.Errorf("unsupported log format: %s", cfg.Log.Format)
+	}
+
 	return &cfg, nil
 }
@@ -0,0 +1,97 @@
+package hikvision
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// ErrArmModeUnsupported is returned when the device has no scene/arming mode endpoint,
+// or its document lacks the configured mode element
+var ErrArmModeUnsupported = errors.New("device does not support arm/scene mode control")
+
+// ArmModeEndpoint locates the scene/arming mode setting, which differs between models
+type ArmModeEndpoint struct {
+	// Path is the ISAPI document holding the mode, read with GET and written with PUT
+	Path string
+
+	// Element is the name of the element whose text is the mode
+	Element string
+}
+
+// DefaultArmModeEndpoint is the scene mode of Hikvision video intercoms (door stations),
+// e.g. "atHome", "goOut" or "goToBed"
+var DefaultArmModeEndpoint = ArmModeEndpoint{
+	Path:    "/ISAPI/VideoIntercom/scene/nowMode",
+	Element: "nowMode",
+}
+
+// armMode holds the endpoint and the compiled element pattern
+type armMode struct {
+	endpoint ArmModeEndpoint
+	element  *regexp.Regexp
+}
+
+// SetArmModeEndpoint sets where the scene/arming mode is read and written
+func (c *Client) SetArmModeEndpoint(endpoint ArmModeEndpoint) {
+	c.armMode = armMode{endpoint: endpoint, element: elementPattern(endpoint.Element)}
+}
+
+// GetArmMode returns the device's current scene/arming mode
+func (c *Client) GetArmMode(ctx context.Context) (string, error) {
+	body, err := c.getArmModeDocument(ctx, "GetArmMode")
+	if err != nil {
+		return "", err
+	}
+
+	element := c.armMode.element.Find(body)
+	if element == nil {
+		return "", ErrArmModeUnsupported
+	}
+	var mode struct {
+		Text string `xml:",chardata"`
+	}
+	if err := xml.Unmarshal(element, &mode); err != nil {
+		return "", fmt.Errorf("failed to parse arm mode: %w", err)
+	}
+	return strings.TrimSpace(mode.Text), nil
+}
+
+// SetArmMode changes the device's scene/arming mode. The current document is read and
+// sent back with only the mode element changed, so other settings are preserved.
+func (c *Client) SetArmMode(ctx context.Context, mode string) error {
+	body, err := c.getArmModeDocument(ctx, "SetArmMode")
+	if err != nil {
+		return err
+	}
+
+	updated, ok := replaceElementText(body, c.armMode.element, mode)
+	if !ok {
+		return ErrArmModeUnsupported
+	}
+	if err := c.putDocument(ctx, "SetArmMode", c.armMode.endpoint.Path, updated); err != nil {
+		return fmt.Errorf("failed to set arm mode: %w", err)
+	}
+
+	log.Printf("[Hikvision] SetArmMode: Mode set to %s", mode)
+	return nil
+}
+
+// getArmModeDocument fetches the mode document, mapping "not found" answers to
+// ErrArmModeUnsupported
+func (c *Client) getArmModeDocument(ctx context.Context, name string) ([]byte, error) {
+	body, err := c.getDocument(ctx, name, c.armMode.endpoint.Path)
+	var statusErr *statusError
+	if errors.As(err, &statusErr) && (statusErr.status == http.StatusNotFound || statusErr.status == http.StatusForbidden) {
+		return nil, fmt.Errorf("%w: %v", ErrArmModeUnsupported, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get arm mode: %w", err)
+	}
+	return body, nil
+}
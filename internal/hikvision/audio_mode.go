@@ -0,0 +1,47 @@
+package hikvision
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// AudioMode selects which directions of a two-way audio channel are opened.
+// Some devices (notably VTO door stations) distinguish a listen-only monitor
+// mode from talk mode and only carry audio in the direction that was requested.
+type AudioMode string
+
+const (
+	// AudioModeBoth opens full duplex audio, matching the historical behavior
+	AudioModeBoth AudioMode = "both"
+
+	// AudioModeListen opens device-to-client audio only (monitor)
+	AudioModeListen AudioMode = "listen"
+
+	// AudioModeTalk opens client-to-device audio only (speaker)
+	AudioModeTalk AudioMode = "talk"
+)
+
+// ParseAudioMode validates an audio mode name; an empty name selects AudioModeBoth
+func ParseAudioMode(name string) (AudioMode, error) {
+	switch mode := AudioMode(name); mode {
+	case "":
+		return AudioModeBoth, nil
+	case AudioModeBoth, AudioModeListen, AudioModeTalk:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown audio mode %q (valid: both, listen, talk)", name)
+	}
+}
+
+// openURL builds the channel open URL for a mode. Full duplex uses the plain
+// open call; the sub-modes ask the device for a single direction.
+func (c *Client) openURL(channelID string, mode AudioMode) string {
+	u := fmt.Sprintf("http://%s/ISAPI/System/TwoWayAudio/channels/%s/open", c.host, channelID)
+
+	if mode != "" && mode != AudioModeBoth {
+		query := url.Values{}
+		query.Set("mode", string(mode))
+		u += "?" + query.Encode()
+	}
+	return u
+}
@@ -0,0 +1,59 @@
+package hikvision
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// ErrOutputRoutingUnsupported is returned when the device's channel configuration has
+// no audioOutputID to change
+var ErrOutputRoutingUnsupported = errors.New("device does not support audio output routing")
+
+// audioOutputElement matches the audioOutputID element of a TwoWayAudioChannel document
+var audioOutputElement = elementPattern("audioOutputID")
+
+// SetAudioOutput routes a two-way audio channel to outputID. The channel's current
+// configuration is read and sent back with only audioOutputID changed, so fields this
+// client doesn't model are not clobbered.
+func (c *Client) SetAudioOutput(ctx context.Context, channelID, outputID string) error {
+	body, channel, err := c.getTwoWayAudioChannel(ctx, channelID)
+	if err != nil {
+		return err
+	}
+	updated, ok := replaceElementText(body, audioOutputElement, outputID)
+	if !ok {
+		return ErrOutputRoutingUnsupported
+	}
+	if channel.AudioOutputID == outputID {
+		log.Printf("[Hikvision] SetAudioOutput: Channel %s already uses output %s", channelID, outputID)
+		return nil
+	}
+
+	path := fmt.Sprintf("/ISAPI/System/TwoWayAudio/channels/%s", channelID)
+	if err := c.putDocument(ctx, "SetAudioOutput", path, updated); err != nil {
+		return fmt.Errorf("failed to set audio output: %w", err)
+	}
+
+	log.Printf("[Hikvision] SetAudioOutput: Channel %s routed from output %s to %s", channelID, channel.AudioOutputID, outputID)
+	return nil
+}
+
+// getTwoWayAudioChannel fetches one channel's configuration, returning the raw document
+// along with the parsed fields
+func (c *Client) getTwoWayAudioChannel(ctx context.Context, channelID string) ([]byte, *TwoWayAudioChannel, error) {
+	path := fmt.Sprintf("/ISAPI/System/TwoWayAudio/channels/%s", channelID)
+	body, err := c.getDocument(ctx, "GetTwoWayAudioChannel", path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get channel %s: %w", channelID, err)
+	}
+
+	var channel TwoWayAudioChannel
+	if err := xml.Unmarshal(body, &channel); err != nil {
+		log.Printf("[Hikvision] GetTwoWayAudioChannel: Failed to parse XML: %v", err)
+		return nil, nil, fmt.Errorf("failed to parse channel %s: %w", channelID, err)
+	}
+	return body, &channel, nil
+}
@@ -0,0 +1,63 @@
+package hikvision
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+// AuthResult is the outcome of TestAuth
+type AuthResult string
+
+const (
+	// AuthOK means the device accepted the credentials
+	AuthOK AuthResult = "ok"
+
+	// AuthRejected means the device answered 401: wrong username or password, or a
+	// user locked out after too many failed attempts
+	AuthRejected AuthResult = "unauthorized"
+
+	// AuthUnreachable means no answer came back (connection refused, timeout, DNS
+	// failure or the circuit breaker open)
+	AuthUnreachable AuthResult = "unreachable"
+
+	// AuthDeviceError means the device answered with a server error, so the
+	// credentials could not be checked
+	AuthDeviceError AuthResult = "device_error"
+)
+
+// authCheckPath is a small read-only document every ISAPI device serves to
+// authenticated users
+const authCheckPath = "/ISAPI/System/deviceInfo"
+
+// TestAuth makes a single authenticated read-only request and reports whether the
+// credentials were accepted. The error describes why when they weren't.
+func (c *Client) TestAuth(ctx context.Context) (AuthResult, error) {
+	url := fmt.Sprintf("http://%s%s", c.host, authCheckPath)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return AuthUnreachable, err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		log.Printf("[Hikvision] TestAuth: Request failed: %v", err)
+		return AuthUnreachable, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	// Any other answer got past digest authentication, even if this firmware
+	// doesn't serve deviceInfo or the user may not read it
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		log.Printf("[Hikvision] TestAuth: Credentials rejected for user %s", c.username)
+		return AuthRejected, fmt.Errorf("device rejected the credentials for user %s", c.username)
+	case resp.StatusCode >= 500:
+		log.Printf("[Hikvision] TestAuth: Device error: status %d", resp.StatusCode)
+		return AuthDeviceError, fmt.Errorf("device returned status %d", resp.StatusCode)
+	}
+	return AuthOK, nil
+}
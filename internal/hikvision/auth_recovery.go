@@ -0,0 +1,71 @@
+package hikvision
+
+import (
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/acardace/hikvision-doorbell-server/internal/logger"
+	"github.com/icholy/digest"
+)
+
+// wedgedAuthThreshold is how many ISAPI requests in a row must be rejected with 401,
+// after the credentials have worked, before the digest transport is rebuilt
+const wedgedAuthThreshold = 3
+
+// authRecovery watches for the digest transport getting stuck on a stale challenge,
+// where every request is rejected even though the credentials are correct
+type authRecovery struct {
+	verified atomic.Bool  // A request has authenticated since the client was created
+	rejected atomic.Int32 // Requests rejected in a row since the last one that wasn't
+}
+
+// digestRoundTripper sends requests through the client's current digest transport, so
+// the transport can be replaced while requests are in flight
+type digestRoundTripper struct {
+	client *Client
+}
+
+func (t digestRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.client.digest.Load().RoundTrip(req)
+}
+
+// newDigestTransport creates a digest transport with no cached challenge, counting
+// each challenge it answers
+func (c *Client) newDigestTransport() *digest.Transport {
+	return &digest.Transport{
+		Username: c.username,
+		Password: c.password,
+		Transport: &challengeCountingTransport{
+			transport: c.base,
+			counters:  &c.auth,
+		},
+	}
+}
+
+// observeAuth tracks the status of each completed ISAPI request. Once credentials
+// have been accepted, wedgedAuthThreshold rejections in a row are taken to mean the
+// digest state went bad rather than the password changing, so the digest transport
+// is replaced and pooled connections dropped, as a restart would.
+func (c *Client) observeAuth(status int) {
+	if status != http.StatusUnauthorized {
+		c.authRecovery.verified.Store(true)
+		c.authRecovery.rejected.Store(0)
+		return
+	}
+
+	rejected := c.authRecovery.rejected.Add(1)
+	if !c.authRecovery.verified.Load() || rejected < wedgedAuthThreshold {
+		return
+	}
+
+	c.authRecovery.rejected.Store(0)
+	c.digest.Store(c.newDigestTransport())
+	c.base.CloseIdleConnections()
+	total := c.auth.resets.Add(1)
+
+	logger.Log.Warn("requests rejected despite previously valid credentials, rebuilt digest transport",
+		slog.String("component", "hikvision"),
+		slog.Int("rejected", int(rejected)),
+		slog.Int64("total_resets", total))
+}
@@ -0,0 +1,64 @@
+package hikvision
+
+import (
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/acardace/hikvision-doorbell-server/internal/logger"
+)
+
+// AuthStats counts authentication round trips on ISAPI requests, to tell whether
+// time is being lost to repeated digest challenges
+type AuthStats struct {
+	// Challenges is the number of 401 responses carrying a digest challenge
+	Challenges int64
+
+	// Retries is the number of requests resent after a bare 401 (no WWW-Authenticate)
+	Retries int64
+
+	// Resets is the number of times the digest transport was rebuilt after requests
+	// kept being rejected despite previously valid credentials
+	Resets int64
+}
+
+// authCounters holds the live counters behind AuthStats
+type authCounters struct {
+	challenges atomic.Int64
+	retries    atomic.Int64
+	resets     atomic.Int64
+}
+
+// AuthStats returns the authentication counters accumulated since the client was created
+func (c *Client) AuthStats() AuthStats {
+	return AuthStats{
+		Challenges: c.auth.challenges.Load(),
+		Retries:    c.auth.retries.Load(),
+		Resets:     c.auth.resets.Load(),
+	}
+}
+
+// challengeCountingTransport sits beneath the digest transport and counts the
+// challenges it answers, which are otherwise handled invisibly
+type challengeCountingTransport struct {
+	transport http.RoundTripper
+	counters  *authCounters
+}
+
+func (t *challengeCountingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized && resp.Header.Get("WWW-Authenticate") != "" {
+		total := t.counters.challenges.Add(1)
+		logger.Log.Debug("digest auth challenge",
+			slog.String("component", "hikvision"),
+			slog.String("method", req.Method),
+			slog.String("path", req.URL.Path),
+			slog.Int64("total_challenges", total))
+	}
+
+	return resp, err
+}
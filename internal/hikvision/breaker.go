@@ -0,0 +1,139 @@
+package hikvision
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/acardace/hikvision-doorbell-server/internal/logger"
+)
+
+// ErrCircuitOpen is returned without contacting the device while the circuit breaker
+// is open after repeated failures
+var ErrCircuitOpen = errors.New("device unreachable: circuit breaker open")
+
+// BreakerState describes the circuit breaker for health reporting
+type BreakerState struct {
+	Open     bool          // Requests are being short-circuited
+	Failures int           // Consecutive failures seen so far
+	RetryIn  time.Duration // Time left until the next probe is let through (0 when closed)
+}
+
+// circuitBreaker counts consecutive connection failures to the device. Once threshold
+// is reached it rejects requests for cooldown, then lets a single probe through: a
+// success closes the breaker, a failure opens it for another cooldown.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int // 0 disables the breaker
+	cooldown  time.Duration
+	failures  int
+	openedAt  time.Time
+	probing   bool // A half-open probe is in flight
+}
+
+// SetCircuitBreaker short-circuits device requests with ErrCircuitOpen for cooldown
+// after threshold consecutive connection failures. A threshold of 0 disables it.
+func (c *Client) SetCircuitBreaker(threshold int, cooldown time.Duration) {
+	c.breaker.mu.Lock()
+	defer c.breaker.mu.Unlock()
+
+	c.breaker.threshold = threshold
+	c.breaker.cooldown = cooldown
+	c.breaker.failures = 0
+	c.breaker.probing = false
+}
+
+// BreakerState returns the current state of the circuit breaker
+func (c *Client) BreakerState() BreakerState {
+	b := &c.breaker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state := BreakerState{Failures: b.failures}
+	if b.open() {
+		state.Open = true
+		state.RetryIn = max(b.cooldown-time.Since(b.openedAt), 0)
+	}
+	return state
+}
+
+// open reports whether the failure threshold has been reached. Callers hold mu.
+func (b *circuitBreaker) open() bool {
+	return b.threshold > 0 && b.failures >= b.threshold
+}
+
+// allow returns ErrCircuitOpen if a request must not reach the device. After the
+// cooldown, the first caller is let through as a probe and the rest keep failing
+// until it completes.
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.open() {
+		return nil
+	}
+	if b.probing || time.Since(b.openedAt) < b.cooldown {
+		return ErrCircuitOpen
+	}
+
+	b.probing = true
+	logger.Log.Info("circuit breaker half-open, probing device",
+		slog.String("component", "hikvision"))
+	return nil
+}
+
+// record updates the breaker with the outcome of a request that allow let through.
+// Requests cancelled by the caller say nothing about the device's health.
+func (b *circuitBreaker) record(ctx context.Context, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.threshold == 0 {
+		return
+	}
+
+	if err != nil && ctx.Err() != nil {
+		b.probing = false
+		return
+	}
+
+	if err == nil {
+		if b.open() {
+			logger.Log.Info("circuit breaker closed, device reachable again",
+				slog.String("component", "hikvision"))
+		}
+		b.failures = 0
+		b.probing = false
+		return
+	}
+
+	wasProbing := b.probing
+	b.failures++
+	b.probing = false
+	if b.failures == b.threshold || wasProbing {
+		b.openedAt = time.Now()
+		logger.Log.Warn("circuit breaker open, short-circuiting device requests",
+			slog.String("component", "hikvision"),
+			slog.Int("failures", b.failures),
+			slog.Duration("cooldown", b.cooldown))
+	}
+}
+
+// breakerRoundTripper is the outermost transport of ISAPI requests
+type breakerRoundTripper struct {
+	transport http.RoundTripper
+	breaker   *circuitBreaker
+}
+
+func (t *breakerRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.breaker.allow(); err != nil {
+		return nil, err
+	}
+
+	resp, err := t.transport.RoundTrip(req)
+	t.breaker.record(req.Context(), err)
+	return resp, err
+}
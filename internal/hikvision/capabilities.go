@@ -0,0 +1,136 @@
+package hikvision
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// TwoWayAudioChannelCap is the capabilities document of a two-way audio channel.
+// Each element carries the current value and, in its opt attribute, the allowed ones.
+type TwoWayAudioChannelCap struct {
+	XMLName              xml.Name  `xml:"TwoWayAudioChannel"`
+	ID                   string    `xml:"id"`
+	AudioCompressionType CapOption `xml:"audioCompressionType"`
+	AudioSamplingRate    CapOption `xml:"audioSamplingRate"`
+	AudioBitRate         CapOption `xml:"audioBitRate"`
+}
+
+// CapOption is a capabilities element such as <audioCompressionType opt="G.711ulaw,G.711alaw">G.711ulaw</audioCompressionType>
+type CapOption struct {
+	Opt   string `xml:"opt,attr,omitempty"`
+	Value string `xml:",chardata"`
+}
+
+// Options returns the allowed values, falling back to the current value when the
+// device does not list any
+func (o CapOption) Options() []string {
+	var opts []string
+	for _, opt := range strings.Split(o.Opt, ",") {
+		if opt = strings.TrimSpace(opt); opt != "" {
+			opts = append(opts, opt)
+		}
+	}
+	if len(opts) == 0 && strings.TrimSpace(o.Value) != "" {
+		opts = append(opts, strings.TrimSpace(o.Value))
+	}
+	return opts
+}
+
+// ChannelCapabilities lists the codecs and rates a two-way audio channel supports
+type ChannelCapabilities struct {
+	ChannelID   string
+	Codec       string   // Codec currently configured (audioCompressionType name)
+	Codecs      []string // Supported audioCompressionType names (e.g. "G.711ulaw")
+	SampleRates []int    // Supported sample rates in Hz
+	BitRates    []int    // Supported bit rates in kbit/s
+}
+
+// Supports reports whether codec (an audioCompressionType name) is supported
+func (c *ChannelCapabilities) Supports(codec string) bool {
+	for _, name := range c.Codecs {
+		if strings.EqualFold(name, codec) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetTwoWayAudioChannelCapabilities retrieves the codecs and rates a channel supports
+func (c *Client) GetTwoWayAudioChannelCapabilities(ctx context.Context, channelID string) (*ChannelCapabilities, error) {
+	url := fmt.Sprintf("http://%s/ISAPI/System/TwoWayAudio/channels/%s/capabilities", c.host, channelID)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		log.Printf("[Hikvision] GetTwoWayAudioChannelCapabilities: Request failed: %v", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("[Hikvision] GetTwoWayAudioChannelCapabilities: Error response body: %s", string(body))
+		return nil, fmt.Errorf("failed to get channel capabilities: status %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var caps TwoWayAudioChannelCap
+	if err := xml.Unmarshal(body, &caps); err != nil {
+		log.Printf("[Hikvision] GetTwoWayAudioChannelCapabilities: Failed to parse XML: %v", err)
+		return nil, fmt.Errorf("failed to parse channel capabilities: %w", err)
+	}
+
+	result := &ChannelCapabilities{
+		ChannelID:   channelID,
+		Codec:       strings.TrimSpace(caps.AudioCompressionType.Value),
+		Codecs:      caps.AudioCompressionType.Options(),
+		SampleRates: parseSampleRates(caps.AudioSamplingRate.Options()),
+		BitRates:    parseInts(caps.AudioBitRate.Options()),
+	}
+
+	log.Printf("[Hikvision] GetTwoWayAudioChannelCapabilities: Channel %s - Codecs: %v, Sample rates: %v",
+		channelID, result.Codecs, result.SampleRates)
+
+	return result, nil
+}
+
+// parseSampleRates converts sampling rate options to Hz. Firmware reports them either
+// in kHz ("8", "16") or in Hz ("8000"); values that don't parse are skipped.
+func parseSampleRates(opts []string) []int {
+	var rates []int
+	for _, opt := range opts {
+		khz, err := strconv.ParseFloat(opt, 64)
+		if err != nil || khz <= 0 {
+			continue
+		}
+		if khz < 1000 {
+			rates = append(rates, int(khz*1000))
+		} else {
+			rates = append(rates, int(khz))
+		}
+	}
+	return rates
+}
+
+// parseInts converts numeric options, skipping values that don't parse
+func parseInts(opts []string) []int {
+	var values []int
+	for _, opt := range opts {
+		if v, err := strconv.Atoi(opt); err == nil {
+			values = append(values, v)
+		}
+	}
+	return values
+}
@@ -0,0 +1,64 @@
+package hikvision
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// ChannelHook is an extra ISAPI request issued around opening or closing a channel.
+// Some access-control intercoms only accept an open after the channel has been
+// claimed (locked) with a vendor-specific call, and expect a matching release.
+type ChannelHook struct {
+	// Method is the HTTP method (PUT when empty)
+	Method string
+
+	// Path is the ISAPI path, e.g. "/ISAPI/AccessControl/TwoWayAudio/{id}/claim".
+	// "{id}" is replaced with the channel ID. An empty path disables the hook.
+	Path string
+}
+
+// Enabled reports whether the hook has a path to call
+func (h ChannelHook) Enabled() bool {
+	return h.Path != ""
+}
+
+// CallChannelHook issues the hook's request for a channel and checks the device accepted it
+func (c *Client) CallChannelHook(ctx context.Context, hook ChannelHook, channelID string) error {
+	method := hook.Method
+	if method == "" {
+		method = http.MethodPut
+	}
+	path := strings.ReplaceAll(hook.Path, "{id}", channelID)
+	url := fmt.Sprintf("http://%s%s", c.host, path)
+
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		log.Printf("[Hikvision] CallChannelHook: Failed to create request: %v", err)
+		return err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		log.Printf("[Hikvision] CallChannelHook: %s %s failed: %v", method, path, err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("[Hikvision] CallChannelHook: Error response body: %s", string(body))
+		return fmt.Errorf("%s %s failed: status %d, body: %s", method, path, resp.StatusCode, string(body))
+	}
+
+	if err := checkResponseStatus(body); err != nil {
+		log.Printf("[Hikvision] CallChannelHook: Error response body: %s", string(body))
+		return fmt.Errorf("%s %s failed: %w", method, path, err)
+	}
+
+	log.Printf("[Hikvision] CallChannelHook: %s %s succeeded for channel %s", method, path, channelID)
+	return nil
+}
@@ -1,12 +1,21 @@
 package hikvision
 
 import (
+	"context"
 	"encoding/xml"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"net/http"
-
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/acardace/hikvision-doorbell-server/internal/lifecycle"
+	"github.com/acardace/hikvision-doorbell-server/internal/logger"
+	"github.com/acardace/hikvision-doorbell-server/internal/retry"
 	"github.com/icholy/digest"
 )
 
@@ -16,8 +25,59 @@ type Client struct {
 	username string
 	password string
 	client   *http.Client
+
+	// base carries ISAPI requests beneath digest auth; digest answers the challenges
+	// and is replaced when it wedges (see observeAuth)
+	base         *http.Transport
+	digest       atomic.Pointer[digest.Transport]
+	authRecovery authRecovery
+
+	// readerStallTimeout is passed to new AudioStreamReaders (0 disables the watchdog)
+	readerStallTimeout time.Duration
+
+	// replayBuffer is how much recent audio new AudioStreamReaders keep for Replay (0 disables)
+	replayBuffer time.Duration
+
+	// streamSocket tunes the TCP connections of new AudioStreamWriters
+	streamSocket StreamSocketOptions
+
+	// audioDataParams controls query parameters on audioData URLs
+	audioDataParams AudioDataParams
+
+	// proxy selects the HTTP proxy for device requests (nil URL connects directly)
+	proxy func(*http.Request) (*url.URL, error)
+
+	// streamRetry governs audio stream (re)connects, requestRetry the retry of ISAPI
+	// requests answered with a bare 401
+	streamRetry  retry.Policy
+	requestRetry retry.Policy
+
+	// auth counts digest challenges and bare-401 retries on ISAPI requests
+	auth authCounters
+
+	// rtspPort is the port reported in video stream URLs (0 uses DefaultRTSPPort)
+	rtspPort int
+
+	// breaker short-circuits device requests after repeated connection failures
+	breaker circuitBreaker
+
+	// capabilities caches the result of ProbeCapabilities (nil until probed)
+	capabilities atomic.Pointer[DeviceCapabilities]
+
+	// armMode locates the scene/arming mode setting
+	armMode armMode
+
+	// tasks owns the goroutines of every stream reader and writer, so Shutdown can
+	// stop any a caller failed to close
+	tasks *lifecycle.Group
 }
 
+// DefaultStreamRetry makes a single connection attempt, matching the historical behavior
+var DefaultStreamRetry = retry.None
+
+// DefaultRequestRetry retries a bare 401 once, matching the historical behavior
+var DefaultRequestRetry = retry.Policy{MaxAttempts: 2}
+
 // TwoWayAudioChannelList represents the list of available two-way audio channels
 type TwoWayAudioChannelList struct {
 	XMLName  xml.Name             `xml:"TwoWayAudioChannelList"`
@@ -42,10 +102,44 @@ type ResponseStatus struct {
 	SubStatusCode string   `xml:"subStatusCode"`
 }
 
+// IsOK reports whether the status describes a successful request.
+// Hikvision uses statusCode 1 / statusString "OK" / subStatusCode "ok" for success.
+func (s *ResponseStatus) IsOK() bool {
+	if s.StatusCode != 0 && s.StatusCode != 1 {
+		return false
+	}
+	if s.StatusString != "" && !strings.EqualFold(s.StatusString, "OK") {
+		return false
+	}
+	if s.SubStatusCode != "" && !strings.EqualFold(s.SubStatusCode, "ok") {
+		return false
+	}
+	return true
+}
+
+// checkResponseStatus inspects a 200 response body for an embedded ResponseStatus error.
+// Some firmware replies with HTTP 200 but reports the failure in the XML body.
+// Bodies that are not a ResponseStatus document are treated as success.
+func checkResponseStatus(body []byte) error {
+	var status ResponseStatus
+	if err := xml.Unmarshal(body, &status); err != nil {
+		return nil
+	}
+
+	if !status.IsOK() {
+		return fmt.Errorf("device reported error: statusCode %d, statusString %s, subStatusCode %s",
+			status.StatusCode, status.StatusString, status.SubStatusCode)
+	}
+
+	return nil
+}
+
 // AudioSession represents an active two-way audio session
 type AudioSession struct {
 	ChannelID string
 	SessionID string
+	Mode      AudioMode // Directions the channel was opened for
+	Codec     string    // Audio compression type configured on the channel; empty means G.711
 }
 
 // TwoWayAudioSession represents the XML response from opening a channel
@@ -56,69 +150,144 @@ type TwoWayAudioSession struct {
 
 // NewClient creates a new Hikvision ISAPI client
 func NewClient(host, username, password string) *Client {
-	// Create a digest transport that will handle auth challenges
-	transport := &digest.Transport{
-		Username: username,
-		Password: password,
+	c := &Client{
+		host:     host,
+		username: username,
+		password: password,
+		proxy:    http.ProxyFromEnvironment,
+
+		streamRetry:  DefaultStreamRetry,
+		requestRetry: DefaultRequestRetry,
+		streamSocket: DefaultStreamSocketOptions,
+		tasks:        lifecycle.New(),
+	}
+	c.audioDataParams, _ = LookupAudioDataProfile("default")
+	c.SetArmModeEndpoint(DefaultArmModeEndpoint)
+
+	// Base transport resolves the proxy per request so SetProxy applies after construction
+	c.base = http.DefaultTransport.(*http.Transport).Clone()
+	c.base.Proxy = func(req *http.Request) (*url.URL, error) {
+		return c.proxy(req)
 	}
 
+	// Create a digest transport that will handle auth challenges, counting each one it answers
+	c.digest.Store(c.newDigestTransport())
+
 	// Wrap in a custom RoundTripper that logs auth challenges
 	retryTransport := &retryRoundTripper{
-		transport: transport,
+		transport: digestRoundTripper{client: c},
+		client:    c,
 	}
 
-	return &Client{
-		host:     host,
-		username: username,
-		password: password,
-		client: &http.Client{
-			Transport: retryTransport,
+	// Outermost, so a retried request counts as a single success or failure
+	c.client = &http.Client{
+		Transport: &breakerRoundTripper{
+			transport: retryTransport,
+			breaker:   &c.breaker,
 		},
 	}
+
+	return c
+}
+
+// SetRetryPolicies sets the retry policies for audio streams and ISAPI requests
+func (c *Client) SetRetryPolicies(stream, request retry.Policy) {
+	c.streamRetry = stream
+	c.requestRetry = request
+}
+
+// SetReaderStallTimeout configures the watchdog window for audio stream readers.
+// If no data arrives within the window, the reader reconnects. Zero disables the watchdog.
+func (c *Client) SetReaderStallTimeout(timeout time.Duration) {
+	c.readerStallTimeout = timeout
+}
+
+// Shutdown stops the goroutines of all stream readers and writers and waits for them to
+// return, giving up when ctx ends. Streams created afterwards do not start.
+func (c *Client) Shutdown(ctx context.Context) error {
+	return c.tasks.Stop(ctx)
+}
+
+// SetStreamSocketOptions sets the socket options applied to the connection of each
+// audio stream writer
+func (c *Client) SetStreamSocketOptions(opts StreamSocketOptions) {
+	c.streamSocket = opts
+}
+
+// SetReplayBuffer sets how much of the most recently read audio stream readers keep so
+// it can be replayed to a consumer that attaches late. Zero disables it.
+func (c *Client) SetReplayBuffer(d time.Duration) {
+	c.replayBuffer = d
 }
 
 // loggingRoundTripper wraps digest.Transport to log auth attempts
 type retryRoundTripper struct {
 	transport http.RoundTripper
+	client    *Client
 }
 
 func (l *retryRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := l.roundTrip(req)
+	if err == nil {
+		l.client.observeAuth(resp.StatusCode)
+	}
+	return resp, err
+}
+
+// roundTrip sends req, resending it per the request retry policy while the device
+// answers with a bare 401
+func (l *retryRoundTripper) roundTrip(req *http.Request) (*http.Response, error) {
+	policy := l.client.requestRetry
 	resp, err := l.transport.RoundTrip(req)
 
-	if err != nil {
-		log.Printf("[Hikvision] Transport error: %v", err)
-		return resp, err
-	}
+	for attempt := 1; ; attempt++ {
+		if err != nil {
+			log.Printf("[Hikvision] Transport error: %v", err)
+			return resp, err
+		}
 
-	// Handle buggy 401 responses from Hikvision that have empty WWW-Authenticate headers
-	if resp.StatusCode == 401 {
-		wwwAuth := resp.Header.Get("WWW-Authenticate")
-		// If we get 401 with empty auth header, retry once
-		if wwwAuth == "" {
-			resp.Body.Close()
+		// Handle buggy 401 responses from Hikvision that have empty WWW-Authenticate headers
+		if resp.StatusCode != 401 || resp.Header.Get("WWW-Authenticate") != "" || !policy.Allows(attempt) {
+			return resp, err
+		}
+		resp.Body.Close()
 
-			// Clone the request for retry
-			retryReq := req.Clone(req.Context())
-			return l.transport.RoundTrip(retryReq)
+		if err := policy.Wait(req.Context(), attempt); err != nil {
+			return nil, err
 		}
-	}
 
-	return resp, err
+		total := l.client.auth.retries.Add(1)
+		logger.Log.Debug("retrying request after bare 401",
+			slog.String("component", "hikvision"),
+			slog.String("method", req.Method),
+			slog.String("path", req.URL.Path),
+			slog.Int("attempt", attempt+1),
+			slog.Int64("total_retries", total))
+
+		// Clone the request for retry
+		retryReq := req.Clone(req.Context())
+		resp, err = l.transport.RoundTrip(retryReq)
+	}
 }
 
 // GetTwoWayAudioChannels retrieves available two-way audio channels
-func (c *Client) GetTwoWayAudioChannels() (*TwoWayAudioChannelList, error) {
-	return c.getTwoWayAudioChannels(true)
+func (c *Client) GetTwoWayAudioChannels(ctx context.Context) (*TwoWayAudioChannelList, error) {
+	return c.getTwoWayAudioChannels(ctx, true)
 }
 
 // GetTwoWayAudioChannelsQuiet retrieves available two-way audio channels without logging (for health checks)
-func (c *Client) GetTwoWayAudioChannelsQuiet() (*TwoWayAudioChannelList, error) {
-	return c.getTwoWayAudioChannels(false)
+func (c *Client) GetTwoWayAudioChannelsQuiet(ctx context.Context) (*TwoWayAudioChannelList, error) {
+	return c.getTwoWayAudioChannels(ctx, false)
 }
 
-func (c *Client) getTwoWayAudioChannels(verbose bool) (*TwoWayAudioChannelList, error) {
+func (c *Client) getTwoWayAudioChannels(ctx context.Context, verbose bool) (*TwoWayAudioChannelList, error) {
 	url := fmt.Sprintf("http://%s/ISAPI/System/TwoWayAudio/channels", c.host)
-	resp, err := c.client.Get(url)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Do(req)
 	if err != nil {
 		if verbose {
 			log.Printf("[Hikvision] GetTwoWayAudioChannels: Request failed: %v", err)
@@ -159,11 +328,11 @@ func (c *Client) getTwoWayAudioChannels(verbose bool) (*TwoWayAudioChannelList,
 	return &channels, nil
 }
 
-// OpenAudioChannel opens a two-way audio channel and returns the session
-func (c *Client) OpenAudioChannel(channelID string) (*AudioSession, error) {
-	url := fmt.Sprintf("http://%s/ISAPI/System/TwoWayAudio/channels/%s/open", c.host, channelID)
+// OpenAudioChannel opens a two-way audio channel in the given mode and returns the session
+func (c *Client) OpenAudioChannel(ctx context.Context, channelID string, mode AudioMode) (*AudioSession, error) {
+	url := c.openURL(channelID, mode)
 
-	req, err := http.NewRequest("PUT", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "PUT", url, nil)
 	if err != nil {
 		log.Printf("[Hikvision] OpenAudioChannel: Failed to create request: %v", err)
 		return nil, err
@@ -188,25 +357,31 @@ func (c *Client) OpenAudioChannel(channelID string) (*AudioSession, error) {
 		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
 
+	if err := checkResponseStatus(body); err != nil {
+		log.Printf("[Hikvision] OpenAudioChannel: Error response body: %s", string(body))
+		return nil, fmt.Errorf("failed to open channel: %w", err)
+	}
+
 	var sessionResp TwoWayAudioSession
 	if err := xml.Unmarshal(body, &sessionResp); err != nil {
 		log.Printf("[Hikvision] OpenAudioChannel: Failed to parse XML: %v", err)
 		return nil, fmt.Errorf("failed to parse session response: %w", err)
 	}
 
-	log.Printf("[Hikvision] OpenAudioChannel: Session opened - Channel: %s, SessionID: %s", channelID, sessionResp.SessionID)
+	log.Printf("[Hikvision] OpenAudioChannel: Session opened - Channel: %s, Mode: %s, SessionID: %s", channelID, mode, sessionResp.SessionID)
 
 	return &AudioSession{
 		ChannelID: channelID,
 		SessionID: sessionResp.SessionID,
+		Mode:      mode,
 	}, nil
 }
 
 // CloseAudioChannel closes an active two-way audio session
-func (c *Client) CloseAudioChannel(channelID string) error {
+func (c *Client) CloseAudioChannel(ctx context.Context, channelID string) error {
 	url := fmt.Sprintf("http://%s/ISAPI/System/TwoWayAudio/channels/%s/close", c.host, channelID)
 
-	req, err := http.NewRequest("PUT", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "PUT", url, nil)
 	if err != nil {
 		log.Printf("[Hikvision] CloseAudioChannel: Failed to create request: %v", err)
 		return err
@@ -225,6 +400,16 @@ func (c *Client) CloseAudioChannel(channelID string) error {
 		return fmt.Errorf("failed to close channel: status %d", resp.StatusCode)
 	}
 
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if err := checkResponseStatus(body); err != nil {
+		log.Printf("[Hikvision] CloseAudioChannel: Error response body: %s", string(body))
+		return fmt.Errorf("failed to close channel: %w", err)
+	}
+
 	log.Printf("[Hikvision] CloseAudioChannel: Channel %s closed successfully", channelID)
 	return nil
 }
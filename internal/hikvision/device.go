@@ -0,0 +1,95 @@
+package hikvision
+
+import (
+	"context"
+	"io"
+)
+
+// DeviceClient is the subset of ISAPI operations the server relies on.
+// Handlers and session/streaming components depend on this interface rather than
+// *Client so that alternative implementations (mocks, other vendors) can be swapped in.
+type DeviceClient interface {
+	// GetTwoWayAudioChannels retrieves available two-way audio channels
+	GetTwoWayAudioChannels(ctx context.Context) (*TwoWayAudioChannelList, error)
+
+	// GetTwoWayAudioChannelsQuiet retrieves channels without logging (for health checks)
+	GetTwoWayAudioChannelsQuiet(ctx context.Context) (*TwoWayAudioChannelList, error)
+
+	// GetTwoWayAudioChannelCapabilities retrieves the codecs and rates a channel supports
+	GetTwoWayAudioChannelCapabilities(ctx context.Context, channelID string) (*ChannelCapabilities, error)
+
+	// OpenAudioChannel opens a two-way audio channel in the given mode and returns the session
+	OpenAudioChannel(ctx context.Context, channelID string, mode AudioMode) (*AudioSession, error)
+
+	// CloseAudioChannel closes an active two-way audio session
+	CloseAudioChannel(ctx context.Context, channelID string) error
+
+	// SetAudioOutput routes a two-way audio channel to another audio output
+	SetAudioOutput(ctx context.Context, channelID, outputID string) error
+
+	// GetArmMode returns the device's scene/arming mode
+	GetArmMode(ctx context.Context) (string, error)
+
+	// SetArmMode changes the device's scene/arming mode
+	SetArmMode(ctx context.Context, mode string) error
+
+	// CallChannelHook issues a device-specific claim or release request for a channel
+	CallChannelHook(ctx context.Context, hook ChannelHook, channelID string) error
+
+	// NewAudioStreamWriter creates a writer that sends audio to the device speaker
+	// The writer stops when ctx is cancelled
+	NewAudioStreamWriter(ctx context.Context, session *AudioSession) StreamWriter
+
+	// NewAudioStreamReader creates a reader that receives audio from the device microphone
+	// The reader stops when ctx is cancelled
+	NewAudioStreamReader(ctx context.Context, session *AudioSession) StreamReader
+
+	// GetVideoStreams lists the device's video streams and their RTSP URLs
+	GetVideoStreams(ctx context.Context) ([]VideoStream, error)
+
+	// TestAuth makes one authenticated request and reports whether the credentials work
+	TestAuth(ctx context.Context) (AuthResult, error)
+
+	// AuthStats returns how many auth challenges and retries ISAPI requests have needed
+	AuthStats() AuthStats
+
+	// BreakerState returns whether device requests are being short-circuited
+	BreakerState() BreakerState
+
+	// Capabilities returns the features found when the device was probed, or nil
+	Capabilities() *DeviceCapabilities
+}
+
+// StreamWriter sends audio data to a device channel
+type StreamWriter interface {
+	io.Writer
+	Start()
+	Close() error
+
+	// SetPacing enables or disables real-time pacing of writes (before Start)
+	SetPacing(enabled bool)
+
+	// Failed is closed when the stream dies (e.g. the device drops the connection);
+	// Err then returns the cause
+	Failed() <-chan struct{}
+	Err() error
+
+	// Flush waits until everything written so far has been sent to the device
+	Flush(ctx context.Context) error
+}
+
+// StreamReader receives audio data from a device channel
+type StreamReader interface {
+	io.Reader
+	Start()
+	Close() error
+
+	// Replay returns the most recent audio already returned by Read, oldest first, up
+	// to the client's replay buffer length (nil when replay is disabled)
+	Replay() []byte
+
+	// Restarts returns how many times the stall watchdog reconnected the stream
+	Restarts() int64
+}
+
+var _ DeviceClient = (*Client)(nil)
@@ -0,0 +1,151 @@
+package hikvision
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DeviceCap is the subset of /ISAPI/System/capabilities the server looks at. Optional
+// sections are pointers so their presence can be told from their absence.
+type DeviceCap struct {
+	XMLName          xml.Name  `xml:"DeviceCap"`
+	SysCap           SysCap    `xml:"SysCap"`
+	EventCap         *struct{} `xml:"EventCap"`
+	SnapshotCap      *struct{} `xml:"SnapshotCap"`
+	VideoIntercomCap *struct{} `xml:"VideoIntercomCap"`
+}
+
+// SysCap holds the system section of DeviceCap
+type SysCap struct {
+	AudioCap struct {
+		AudioInputNums  int `xml:"audioInputNums"`
+		AudioOutputNums int `xml:"audioOutputNums"`
+	} `xml:"AudioCap"`
+}
+
+// DeviceCapabilities records which features the device answered for when probed at
+// startup, so unsupported endpoints can be skipped instead of failing at request time
+type DeviceCapabilities struct {
+	TwoWayAudio   bool     // Two-way audio channels are listed
+	AudioChannels int      // Number of two-way audio channels
+	AudioCodecs   []string // Codecs supported by at least one channel
+	AudioInputs   int      // Microphones reported by DeviceCap (0 if not reported)
+	AudioOutputs  int      // Speakers reported by DeviceCap (0 if not reported)
+	Video         bool     // Streaming channels are listed
+	Snapshot      bool     // DeviceCap advertises SnapshotCap
+	Events        bool     // DeviceCap advertises EventCap
+	VideoIntercom bool     // DeviceCap advertises VideoIntercomCap
+	DoorUnlock    bool     // /ISAPI/AccessControl/capabilities is answered
+	ProbedAt      time.Time
+}
+
+// Capabilities returns the capabilities found by the last ProbeCapabilities, or nil
+// if the device has not been probed
+func (c *Client) Capabilities() *DeviceCapabilities {
+	return c.capabilities.Load()
+}
+
+// FullDuplex reports whether a channel can capture audio while playing it. ISAPI has
+// no explicit flag for this, so two-way audio is taken to be full duplex unless
+// DeviceCap lists speakers but no microphone.
+func (c *DeviceCapabilities) FullDuplex() bool {
+	if !c.TwoWayAudio {
+		return false
+	}
+	return c.AudioInputs > 0 || c.AudioOutputs == 0
+}
+
+// ProbeCapabilities queries the device's capability documents and caches which
+// features it supports. Endpoints the device doesn't implement only clear the
+// matching feature; an error is returned only if the device can't be reached.
+func (c *Client) ProbeCapabilities(ctx context.Context) (*DeviceCapabilities, error) {
+	caps := &DeviceCapabilities{}
+
+	body, ok, err := c.getOptional(ctx, "/ISAPI/System/capabilities")
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		var deviceCap DeviceCap
+		if err := xml.Unmarshal(body, &deviceCap); err != nil {
+			log.Printf("[Hikvision] ProbeCapabilities: Failed to parse device capabilities: %v", err)
+		} else {
+			caps.AudioInputs = deviceCap.SysCap.AudioCap.AudioInputNums
+			caps.AudioOutputs = deviceCap.SysCap.AudioCap.AudioOutputNums
+			caps.Snapshot = deviceCap.SnapshotCap != nil
+			caps.Events = deviceCap.EventCap != nil
+			caps.VideoIntercom = deviceCap.VideoIntercomCap != nil
+		}
+	}
+
+	if channels, err := c.GetTwoWayAudioChannelsQuiet(ctx); err == nil {
+		caps.TwoWayAudio = len(channels.Channels) > 0
+		caps.AudioChannels = len(channels.Channels)
+		for _, ch := range channels.Channels {
+			chCaps, err := c.GetTwoWayAudioChannelCapabilities(ctx, ch.ID)
+			if err != nil {
+				continue
+			}
+			for _, codec := range chCaps.Codecs {
+				if !containsFold(caps.AudioCodecs, codec) {
+					caps.AudioCodecs = append(caps.AudioCodecs, codec)
+				}
+			}
+		}
+	}
+
+	if body, ok, err := c.getOptional(ctx, "/ISAPI/Streaming/channels"); err == nil && ok {
+		var list StreamingChannelList
+		caps.Video = xml.Unmarshal(body, &list) == nil && len(list.Channels) > 0
+	}
+
+	if _, ok, err := c.getOptional(ctx, "/ISAPI/AccessControl/capabilities"); err == nil {
+		caps.DoorUnlock = ok
+	}
+
+	caps.ProbedAt = time.Now()
+	c.capabilities.Store(caps)
+
+	log.Printf("[Hikvision] ProbeCapabilities: two-way audio: %t (%d channels, codecs %v), video: %t, snapshot: %t, events: %t, video intercom: %t, door unlock: %t",
+		caps.TwoWayAudio, caps.AudioChannels, caps.AudioCodecs, caps.Video, caps.Snapshot, caps.Events, caps.VideoIntercom, caps.DoorUnlock)
+	return caps, nil
+}
+
+// getOptional fetches an ISAPI document the device may not implement. ok is false for
+// any non-200 answer, which is expected and not logged; err is set only when the
+// request itself fails.
+func (c *Client) getOptional(ctx context.Context, path string) (body []byte, ok bool, err error) {
+	url := fmt.Sprintf("http://%s%s", c.host, path)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+
+	body, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read response body: %w", err)
+	}
+	return body, resp.StatusCode == http.StatusOK, nil
+}
+
+// containsFold reports whether list contains s, ignoring case
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
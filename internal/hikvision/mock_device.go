@@ -0,0 +1,408 @@
+package hikvision
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mockFrameSize and mockFrameInterval pace the simulated microphone at G.711 rate
+const (
+	mockFrameSize     = 160
+	mockFrameInterval = 20 * time.Millisecond
+)
+
+// MockDevice simulates a Hikvision doorbell's ISAPI two-way audio endpoints on a local
+// HTTP server, so the server and CLI can run end-to-end without hardware.
+// Audio written to a channel is echoed back to its readers; silence is sent otherwise.
+type MockDevice struct {
+	listener net.Listener
+	server   *http.Server
+
+	mu       sync.Mutex
+	channels []*mockChannel
+	nextID   int
+	armMode  string
+
+	echo     chan []byte
+	stopChan chan struct{}
+	stopOnce sync.Once
+}
+
+type mockChannel struct {
+	id        string
+	enabled   bool
+	sessionID string
+	outputID  string
+}
+
+// StartMockDevice starts a simulated doorbell with the given number of two-way audio channels
+// listening on a random localhost port
+func StartMockDevice(channelCount int) (*MockDevice, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to start mock device: %w", err)
+	}
+
+	d := &MockDevice{
+		listener: listener,
+		armMode:  "atHome",
+		echo:     make(chan []byte, 256),
+		stopChan: make(chan struct{}),
+	}
+	for i := 1; i <= channelCount; i++ {
+		id := fmt.Sprintf("%d", i)
+		d.channels = append(d.channels, &mockChannel{id: id, outputID: id})
+	}
+
+	d.server = &http.Server{Handler: http.HandlerFunc(d.serveHTTP)}
+	go d.server.Serve(listener)
+
+	log.Printf("[MockDevice] Simulating doorbell with %d channel(s) on %s", channelCount, d.Addr())
+	return d, nil
+}
+
+// Addr returns the host:port the mock device listens on
+func (d *MockDevice) Addr() string {
+	return d.listener.Addr().String()
+}
+
+// Close stops the mock device
+func (d *MockDevice) Close() error {
+	d.stopOnce.Do(func() {
+		close(d.stopChan)
+	})
+	return d.server.Close()
+}
+
+func (d *MockDevice) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	const prefix = "/ISAPI/System/TwoWayAudio/channels"
+
+	if r.URL.Path == prefix && r.Method == http.MethodGet {
+		d.handleListChannels(w)
+		return
+	}
+
+	if r.URL.Path == "/ISAPI/System/capabilities" && r.Method == http.MethodGet {
+		d.handleDeviceCapabilities(w)
+		return
+	}
+
+	if r.URL.Path == authCheckPath && r.Method == http.MethodGet {
+		d.handleDeviceInfo(w)
+		return
+	}
+
+	if r.URL.Path == "/ISAPI/Streaming/channels" && r.Method == http.MethodGet {
+		d.handleStreamingChannels(w)
+		return
+	}
+
+	if r.URL.Path == DefaultArmModeEndpoint.Path {
+		d.handleArmMode(w, r)
+		return
+	}
+
+	// Remaining routes are /ISAPI/System/TwoWayAudio/channels/{id}[/{action}]
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, prefix+"/"), "/")
+	if !strings.HasPrefix(r.URL.Path, prefix+"/") || len(parts) > 2 {
+		http.NotFound(w, r)
+		return
+	}
+
+	ch := d.channel(parts[0])
+	if ch == nil {
+		d.writeStatus(w, http.StatusNotFound, 4, "Invalid Operation", "notSupport")
+		return
+	}
+
+	if len(parts) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			d.handleGetChannel(w, ch)
+		case http.MethodPut:
+			d.handlePutChannel(w, r, ch)
+		default:
+			http.NotFound(w, r)
+		}
+		return
+	}
+
+	switch {
+	case parts[1] == "open" && r.Method == http.MethodPut:
+		d.handleOpen(w, r, ch)
+	case parts[1] == "close" && r.Method == http.MethodPut:
+		d.handleClose(w, ch)
+	case parts[1] == "capabilities" && r.Method == http.MethodGet:
+		d.handleCapabilities(w, ch)
+	case parts[1] == "audioData" && r.Method == http.MethodGet:
+		d.handleAudioRead(w, r)
+	case parts[1] == "audioData" && r.Method == http.MethodPut:
+		d.handleAudioWrite(w)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (d *MockDevice) channel(id string) *mockChannel {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, ch := range d.channels {
+		if ch.id == id {
+			return ch
+		}
+	}
+	return nil
+}
+
+func (d *MockDevice) handleListChannels(w http.ResponseWriter) {
+	d.mu.Lock()
+	list := TwoWayAudioChannelList{}
+	for _, ch := range d.channels {
+		list.Channels = append(list.Channels, ch.config())
+	}
+	d.mu.Unlock()
+
+	d.writeXML(w, list)
+}
+
+// config describes the channel as ISAPI reports it. Callers hold d.mu.
+func (ch *mockChannel) config() TwoWayAudioChannel {
+	return TwoWayAudioChannel{
+		ID:                   ch.id,
+		Enabled:              fmt.Sprintf("%t", ch.enabled),
+		AudioInputID:         ch.id,
+		AudioOutputID:        ch.outputID,
+		AudioCompressionType: "G.711ulaw",
+	}
+}
+
+func (d *MockDevice) handleGetChannel(w http.ResponseWriter, ch *mockChannel) {
+	d.mu.Lock()
+	config := ch.config()
+	d.mu.Unlock()
+
+	d.writeXML(w, config)
+}
+
+// handlePutChannel accepts a new audio output for the channel; other fields are ignored
+func (d *MockDevice) handlePutChannel(w http.ResponseWriter, r *http.Request, ch *mockChannel) {
+	var config TwoWayAudioChannel
+	if err := xml.NewDecoder(r.Body).Decode(&config); err != nil || config.AudioOutputID == "" {
+		d.writeStatus(w, http.StatusBadRequest, 4, "Invalid Operation", "badXmlContent")
+		return
+	}
+
+	d.mu.Lock()
+	ch.outputID = config.AudioOutputID
+	d.mu.Unlock()
+
+	log.Printf("[MockDevice] Routed channel %s to audio output %s", ch.id, config.AudioOutputID)
+	d.writeStatus(w, http.StatusOK, 1, "OK", "ok")
+}
+
+func (d *MockDevice) handleOpen(w http.ResponseWriter, r *http.Request, ch *mockChannel) {
+	mode, err := ParseAudioMode(r.URL.Query().Get("mode"))
+	if err != nil {
+		d.writeStatus(w, http.StatusBadRequest, 4, "Invalid Operation", "badParameters")
+		return
+	}
+
+	d.mu.Lock()
+	if ch.enabled {
+		d.mu.Unlock()
+		d.writeStatus(w, http.StatusForbidden, 4, "Device Busy", "deviceBusy")
+		return
+	}
+	d.nextID++
+	ch.enabled = true
+	ch.sessionID = fmt.Sprintf("mock-%d", d.nextID)
+	sessionID := ch.sessionID
+	d.mu.Unlock()
+
+	log.Printf("[MockDevice] Opened channel %s in %s mode (session %s)", ch.id, mode, sessionID)
+	d.writeXML(w, TwoWayAudioSession{SessionID: sessionID})
+}
+
+func (d *MockDevice) handleClose(w http.ResponseWriter, ch *mockChannel) {
+	d.mu.Lock()
+	ch.enabled = false
+	ch.sessionID = ""
+	d.mu.Unlock()
+
+	log.Printf("[MockDevice] Closed channel %s", ch.id)
+	d.writeStatus(w, http.StatusOK, 1, "OK", "ok")
+}
+
+func (d *MockDevice) handleCapabilities(w http.ResponseWriter, ch *mockChannel) {
+	d.writeXML(w, TwoWayAudioChannelCap{
+		ID:                   ch.id,
+		AudioCompressionType: CapOption{Opt: "G.711ulaw,G.711alaw", Value: "G.711ulaw"},
+		AudioSamplingRate:    CapOption{Opt: "8", Value: "8"},
+		AudioBitRate:         CapOption{Opt: "64", Value: "64"},
+	})
+}
+
+// mockSceneMode is the scene mode document of a video intercom
+type mockSceneMode struct {
+	XMLName xml.Name `xml:"SceneNowMode"`
+	NowMode string   `xml:"nowMode"`
+}
+
+// handleArmMode reads or changes the simulated scene mode
+func (d *MockDevice) handleArmMode(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		d.mu.Lock()
+		mode := d.armMode
+		d.mu.Unlock()
+		d.writeXML(w, mockSceneMode{NowMode: mode})
+	case http.MethodPut:
+		var scene mockSceneMode
+		if err := xml.NewDecoder(r.Body).Decode(&scene); err != nil || scene.NowMode == "" {
+			d.writeStatus(w, http.StatusBadRequest, 4, "Invalid Operation", "badXmlContent")
+			return
+		}
+		d.mu.Lock()
+		d.armMode = scene.NowMode
+		d.mu.Unlock()
+		log.Printf("[MockDevice] Scene mode set to %s", scene.NowMode)
+		d.writeStatus(w, http.StatusOK, 1, "OK", "ok")
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleDeviceCapabilities describes a doorbell with one microphone and speaker, video
+// and intercom support; door unlock (access control) is not simulated
+func (d *MockDevice) handleDeviceCapabilities(w http.ResponseWriter) {
+	caps := DeviceCap{VideoIntercomCap: &struct{}{}, SnapshotCap: &struct{}{}}
+	caps.SysCap.AudioCap.AudioInputNums = 1
+	caps.SysCap.AudioCap.AudioOutputNums = 1
+	d.writeXML(w, caps)
+}
+
+func (d *MockDevice) handleDeviceInfo(w http.ResponseWriter) {
+	d.writeXML(w, struct {
+		XMLName    xml.Name `xml:"DeviceInfo"`
+		DeviceName string   `xml:"deviceName"`
+		Model      string   `xml:"model"`
+	}{DeviceName: "Mock Doorbell", Model: "MOCK"})
+}
+
+// handleStreamingChannels reports a main and a sub video stream, though no RTSP server backs them
+func (d *MockDevice) handleStreamingChannels(w http.ResponseWriter) {
+	d.writeXML(w, StreamingChannelList{Channels: []StreamingChannel{
+		{ID: "101", ChannelName: "Main Stream", Enabled: "true", Video: StreamingChannelVideo{
+			Enabled: "true", VideoCodecType: "H.264", ResolutionWidth: 1920, ResolutionHeight: 1080, MaxFrameRate: 2500}},
+		{ID: "102", ChannelName: "Sub Stream", Enabled: "true", Video: StreamingChannelVideo{
+			Enabled: "true", VideoCodecType: "H.264", ResolutionWidth: 640, ResolutionHeight: 480, MaxFrameRate: 1500}},
+	}})
+}
+
+// handleAudioRead streams echoed audio, or silence when nothing was written, at G.711 rate
+func (d *MockDevice) handleAudioRead(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	silence := make([]byte, mockFrameSize)
+	for i := range silence {
+		silence[i] = 0xFF // µ-law zero
+	}
+
+	ticker := time.NewTicker(mockFrameInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-d.stopChan:
+			return
+		case <-ticker.C:
+			frame := silence
+			select {
+			case data := <-d.echo:
+				frame = data
+			default:
+			}
+
+			if _, err := w.Write(frame); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// handleAudioWrite answers the PUT and then reads raw audio from the connection,
+// mirroring how the real device accepts a persistent audio upload
+func (d *MockDevice) handleAudioWrite(w http.ResponseWriter) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+
+	conn, bufrw, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	bufrw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+	bufrw.Flush()
+
+	d.echoFrom(bufrw.Reader)
+}
+
+// echoFrom splits incoming audio into frames and queues them for readers, dropping when full
+func (d *MockDevice) echoFrom(r *bufio.Reader) {
+	for {
+		frame := make([]byte, mockFrameSize)
+		n, err := r.Read(frame)
+		if n > 0 {
+			select {
+			case d.echo <- frame[:n]:
+			default:
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (d *MockDevice) writeXML(w http.ResponseWriter, v any) {
+	body, err := xml.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write(body)
+}
+
+func (d *MockDevice) writeStatus(w http.ResponseWriter, httpStatus, statusCode int, statusString, subStatusCode string) {
+	body, _ := xml.Marshal(ResponseStatus{
+		StatusCode:    statusCode,
+		StatusString:  statusString,
+		SubStatusCode: subStatusCode,
+	})
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(httpStatus)
+	w.Write(body)
+}
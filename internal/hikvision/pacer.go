@@ -0,0 +1,31 @@
+package hikvision
+
+import "time"
+
+// maxPacingLag is how far behind schedule a write may land before the gap is treated as
+// a pause in the input (nothing to send) and the baseline is reset, rather than caught
+// up by sending the next audio in a burst
+const maxPacingLag = 200 * time.Millisecond
+
+// pacer releases audio at its playback rate against a monotonic baseline taken when
+// audio starts flowing. Each write sleeps until everything written since the baseline
+// is due, so scheduler jitter and oversleeping are absorbed by the next write instead
+// of accumulating into drift over long streams.
+type pacer struct {
+	start time.Time     // Baseline (time.Now carries a monotonic reading)
+	sent  time.Duration // Audio written since start
+}
+
+// wait accounts for d of audio just written and sleeps until it is due
+func (p *pacer) wait(d time.Duration) {
+	now := time.Now()
+	if p.start.IsZero() || now.Sub(p.start.Add(p.sent)) > maxPacingLag {
+		p.start = now
+		p.sent = 0
+	}
+
+	p.sent += d
+	if sleep := p.start.Add(p.sent).Sub(now); sleep > 0 {
+		time.Sleep(sleep)
+	}
+}
@@ -0,0 +1,98 @@
+package hikvision
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// SetProxy sets the HTTP proxy used to reach the device. An empty proxyURL uses the
+// HTTP_PROXY / NO_PROXY environment variables, which is the default.
+func (c *Client) SetProxy(proxyURL string) error {
+	if proxyURL == "" {
+		c.proxy = http.ProxyFromEnvironment
+		return nil
+	}
+
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	if u.Scheme != "http" || u.Host == "" {
+		return fmt.Errorf("invalid proxy URL %q: only http:// proxies are supported", proxyURL)
+	}
+
+	c.proxy = http.ProxyURL(u)
+	return nil
+}
+
+// dialDevice opens a raw connection to the device at addr. If a proxy applies, the
+// connection is tunneled through it with CONNECT, since the stream writer writes audio
+// straight onto the socket and that can't pass through a forwarding proxy.
+func (c *Client) dialDevice(ctx context.Context, network, addr string) (net.Conn, error) {
+	var dialer net.Dialer
+
+	proxyURL, err := c.proxy(&http.Request{URL: &url.URL{Scheme: "http", Host: addr}})
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL == nil {
+		return dialer.DialContext(ctx, network, addr)
+	}
+
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), "80")
+	}
+
+	conn, err := dialer.DialContext(ctx, network, proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to proxy %s: %w", proxyAddr, err)
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user := proxyURL.User; user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	// Abort the handshake if ctx ends while waiting on the proxy
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send CONNECT to proxy: %w", err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read CONNECT response from proxy: %w", err)
+	}
+	// Like net/http, leave the body alone on success: it is the tunnel
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		conn.Close()
+		return nil, fmt.Errorf("proxy refused CONNECT to %s: %s", addr, resp.Status)
+	}
+
+	// The device speaks first only after our request, so nothing should be buffered yet
+	if br.Buffered() > 0 {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected data from proxy after CONNECT")
+	}
+
+	return conn, nil
+}
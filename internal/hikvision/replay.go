@@ -0,0 +1,51 @@
+package hikvision
+
+import "sync"
+
+// replayBuffer keeps the most recent audio read from a stream, overwriting the oldest
+// bytes once full, so a consumer that attaches late can be sent what it missed
+type replayBuffer struct {
+	mu   sync.Mutex
+	data []byte // Ring storage; its length is the capacity
+	next int    // Position the next byte is written to
+	full bool   // Whether the ring has wrapped at least once
+}
+
+func newReplayBuffer(size int) *replayBuffer {
+	return &replayBuffer{data: make([]byte, size)}
+}
+
+// write appends p, keeping only the last len(data) bytes
+func (b *replayBuffer) write(p []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(p) >= len(b.data) {
+		copy(b.data, p[len(p)-len(b.data):])
+		b.next, b.full = 0, true
+		return
+	}
+
+	n := copy(b.data[b.next:], p)
+	if n < len(p) {
+		copy(b.data, p[n:])
+	}
+	end := b.next + len(p)
+	if end >= len(b.data) {
+		b.full = true
+	}
+	b.next = end % len(b.data)
+}
+
+// snapshot returns a copy of the buffered audio, oldest byte first
+func (b *replayBuffer) snapshot() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.full {
+		return append([]byte(nil), b.data[:b.next]...)
+	}
+	out := make([]byte, 0, len(b.data))
+	out = append(out, b.data[b.next:]...)
+	return append(out, b.data[:b.next]...)
+}
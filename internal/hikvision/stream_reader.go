@@ -1,63 +1,154 @@
 package hikvision
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/acardace/hikvision-doorbell-server/internal/audio"
+	"github.com/acardace/hikvision-doorbell-server/internal/lifecycle"
 )
 
 // AudioStreamReader continuously reads audio data from the device
 type AudioStreamReader struct {
-	client      *Client
-	session     *AudioSession
-	url         string
-	stopChan    chan struct{}
-	dataChan    chan []byte
-	errChan     chan error
-	closeOnce   sync.Once
-	buffer      []byte // Buffer for partial reads
-	bufferMutex sync.Mutex
-	wg          sync.WaitGroup // Wait for streamLoop to complete
+	client       *Client
+	session      *AudioSession
+	url          string
+	header       http.Header      // Extra request headers (the session ID, if sent as a header)
+	tasks        *lifecycle.Group // Runs streamLoop and watchdog; stopped by Close or client shutdown
+	ctx          context.Context  // The tasks' context, also ended when the parent session context ends
+	dataChan     chan []byte
+	errChan      chan error
+	closeOnce    sync.Once
+	buffer       []byte // Buffer for partial reads
+	bufferMutex  sync.Mutex
+	stallTimeout time.Duration // Restart the connection if no data arrives within this window (0 disables)
+	lastRead     atomic.Int64  // UnixNano timestamp of the last chunk read from the device
+	stalled      atomic.Bool   // Set by the watchdog when it tears down a stalled connection
+	restarts     atomic.Int64  // Number of watchdog-triggered restarts
+	connMutex    sync.Mutex
+	connCancel   context.CancelFunc // Cancels the current GET request
+	replay       *replayBuffer      // Recent audio returned by Read (nil when disabled)
 }
 
 // NewAudioStreamReader creates a new continuous audio stream reader
-func (c *Client) NewAudioStreamReader(session *AudioSession) *AudioStreamReader {
-	url := fmt.Sprintf("http://%s/ISAPI/System/TwoWayAudio/channels/%s/audioData", c.host, session.ChannelID)
-	if session.SessionID != "" {
-		url += "?sessionId=" + session.SessionID
+// The reader stops when ctx is cancelled or Close is called.
+func (c *Client) NewAudioStreamReader(ctx context.Context, session *AudioSession) StreamReader {
+	includeSessionID := c.audioDataParams.ReaderSessionID
+	url := c.audioDataURL(session, includeSessionID)
+	log.Printf("[Hikvision] AudioStreamReader: Session ID placement: %s", c.sessionIDPlacement(includeSessionID))
+
+	tasks := c.tasks.Child(ctx)
+
+	// Size the replay buffer from the channel codec's data rate
+	var replay *replayBuffer
+	if c.replayBuffer > 0 {
+		codec, ok := audio.LookupCodec(session.Codec)
+		if !ok {
+			codec, _ = audio.LookupCodec(audio.DefaultCodec)
+		}
+		if size := int(int64(codec.BytesPerSecond) * int64(c.replayBuffer) / int64(time.Second)); size > 0 {
+			replay = newReplayBuffer(size)
+		}
 	}
 
 	return &AudioStreamReader{
-		client:   c,
-		session:  session,
-		url:      url,
-		stopChan: make(chan struct{}),
-		dataChan: make(chan []byte, 128),
-		errChan:  make(chan error, 1),
+		client:       c,
+		session:      session,
+		url:          url,
+		header:       c.audioDataHeader(session, includeSessionID),
+		tasks:        tasks,
+		ctx:          tasks.Context(),
+		dataChan:     make(chan []byte, 128),
+		errChan:      make(chan error, 1),
+		stallTimeout: c.readerStallTimeout,
+		replay:       replay,
 	}
 }
 
 // Start begins the continuous streaming
 func (a *AudioStreamReader) Start() {
 	log.Printf("[Hikvision] AudioStreamReader: Starting stream for channel %s", a.session.ChannelID)
-	a.wg.Add(1)
-	go a.streamLoop()
+	a.lastRead.Store(time.Now().UnixNano())
+	a.tasks.Go("audio reader stream", func(context.Context) { a.streamLoop() })
+
+	if a.stallTimeout > 0 {
+		a.tasks.Go("audio reader watchdog", func(context.Context) { a.watchdog() })
+	}
 }
 
-// streamLoop continuously reads audio data from a single persistent connection
+// Restarts returns how many times the watchdog restarted a stalled connection
+func (a *AudioStreamReader) Restarts() int64 {
+	return a.restarts.Load()
+}
+
+// streamLoop reads from the device, reconnecting whenever the watchdog tears down a stalled
+// connection, and after errors as allowed by the client's stream retry policy
 func (a *AudioStreamReader) streamLoop() {
-	defer a.wg.Done()
+	failures := 0 // Consecutive failed connections; reset once audio flows
+	for {
+		readBefore := a.lastRead.Load()
+		err := a.readConnection()
+
+		if a.stalled.CompareAndSwap(true, false) {
+			if a.ctx.Err() != nil {
+				return
+			}
+			log.Printf("[Hikvision] AudioStreamReader: Reconnecting stalled stream for channel %s (restart #%d)",
+				a.session.ChannelID, a.restarts.Load())
+			a.lastRead.Store(time.Now().UnixNano())
+			continue
+		}
+
+		if a.lastRead.Load() != readBefore {
+			failures = 0
+		}
+
+		if err != nil && a.ctx.Err() == nil {
+			failures++
+			policy := a.client.streamRetry
+			if policy.Allows(failures) {
+				log.Printf("[Hikvision] AudioStreamReader: Reconnecting channel %s after error (attempt %d): %v",
+					a.session.ChannelID, failures+1, err)
+				if policy.Wait(a.ctx, failures) == nil {
+					continue
+				}
+			}
+		}
+
+		// Errors caused by stopping the reader are not reported to consumers
+		if err != nil && a.ctx.Err() == nil {
+			a.errChan <- err
+		}
+		return
+	}
+}
+
+// readConnection reads audio data from a single persistent connection until it ends.
+// It returns nil on a clean stop or EOF.
+func (a *AudioStreamReader) readConnection() error {
+	ctx, cancel := context.WithCancel(a.ctx)
+	defer cancel()
+
+	a.connMutex.Lock()
+	a.connCancel = cancel
+	a.connMutex.Unlock()
 
 	// Make a single GET request that stays open
-	req, err := http.NewRequest("GET", a.url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", a.url, nil)
 	if err != nil {
 		log.Printf("[Hikvision] AudioStreamReader: Failed to create request: %v", err)
-		a.errChan <- err
-		return
+		return err
 	}
 
+	for name, values := range a.header {
+		req.Header[name] = values
+	}
 	// Set headers like go2rtc does
 	req.Header.Set("Content-Type", "application/octet-stream")
 	req.Header.Set("Content-Length", "0")
@@ -65,16 +156,14 @@ func (a *AudioStreamReader) streamLoop() {
 	resp, err := a.client.client.Do(req)
 	if err != nil {
 		log.Printf("[Hikvision] AudioStreamReader: Request failed: %v", err)
-		a.errChan <- err
-		return
+		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		log.Printf("[Hikvision] AudioStreamReader: Error status %d, body: %s", resp.StatusCode, string(body))
-		a.errChan <- fmt.Errorf("failed to get audio data: status %d, body: %s", resp.StatusCode, string(body))
-		return
+		return fmt.Errorf("failed to get audio data: status %d, body: %s", resp.StatusCode, string(body))
 	}
 
 	log.Printf("[Hikvision] AudioStreamReader: Connected, streaming audio data...")
@@ -85,13 +174,15 @@ func (a *AudioStreamReader) streamLoop() {
 
 	for {
 		select {
-		case <-a.stopChan:
+		case <-a.ctx.Done():
 			log.Printf("[Hikvision] AudioStreamReader: Stopped after %d chunks", chunkCount)
-			return
+			return nil
 		default:
 			n, err := resp.Body.Read(buffer)
 			if n > 0 {
 				chunkCount++
+				a.lastRead.Store(time.Now().UnixNano())
+
 				// Make a copy of the data to send to channel
 				data := make([]byte, n)
 				copy(data, buffer[:n])
@@ -101,25 +192,74 @@ func (a *AudioStreamReader) streamLoop() {
 					if chunkCount%100 == 0 {
 						log.Printf("[Hikvision] AudioStreamReader: Read %d chunks so far", chunkCount)
 					}
-				case <-a.stopChan:
+				case <-a.ctx.Done():
 					log.Printf("[Hikvision] AudioStreamReader: Stopped while sending chunk %d", chunkCount)
-					return
+					return nil
 				}
 			}
 
 			if err != nil {
 				if err == io.EOF {
 					log.Printf("[Hikvision] AudioStreamReader: Stream ended (EOF) after %d chunks", chunkCount)
-				} else {
-					log.Printf("[Hikvision] AudioStreamReader: Read error after %d chunks: %v", chunkCount, err)
-					a.errChan <- err
+					return nil
 				}
-				return
+				if a.ctx.Err() != nil {
+					log.Printf("[Hikvision] AudioStreamReader: Stopped after %d chunks", chunkCount)
+					return nil
+				}
+				log.Printf("[Hikvision] AudioStreamReader: Read error after %d chunks: %v", chunkCount, err)
+				return err
 			}
 		}
 	}
 }
 
+// watchdog tears down the current connection if no data has arrived within stallTimeout
+func (a *AudioStreamReader) watchdog() {
+	interval := a.stallTimeout / 4
+	if interval < 100*time.Millisecond {
+		interval = 100 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.ctx.Done():
+			return
+		case <-ticker.C:
+			// A full channel means the consumer is slow, not that the device stalled
+			if len(a.dataChan) == cap(a.dataChan) {
+				a.lastRead.Store(time.Now().UnixNano())
+				continue
+			}
+
+			idle := time.Since(time.Unix(0, a.lastRead.Load()))
+			if idle < a.stallTimeout {
+				continue
+			}
+
+			restarts := a.restarts.Add(1)
+			log.Printf("[Hikvision] AudioStreamReader: Watchdog fired for channel %s, no data for %s (restart #%d)",
+				a.session.ChannelID, idle.Round(time.Millisecond), restarts)
+
+			a.stalled.Store(true)
+			a.lastRead.Store(time.Now().UnixNano())
+			a.cancelConnection()
+		}
+	}
+}
+
+// cancelConnection aborts the in-flight GET request, if any
+func (a *AudioStreamReader) cancelConnection() {
+	a.connMutex.Lock()
+	defer a.connMutex.Unlock()
+
+	if a.connCancel != nil {
+		a.connCancel()
+	}
+}
+
 // Read implements io.Reader interface with buffering for io.ReadFull support
 func (a *AudioStreamReader) Read(p []byte) (int, error) {
 	a.bufferMutex.Lock()
@@ -129,6 +269,7 @@ func (a *AudioStreamReader) Read(p []byte) (int, error) {
 	if len(a.buffer) > 0 {
 		n := copy(p, a.buffer)
 		a.buffer = a.buffer[n:]
+		a.record(p[:n])
 		return n, nil
 	}
 
@@ -140,19 +281,36 @@ func (a *AudioStreamReader) Read(p []byte) (int, error) {
 		if n < len(data) {
 			a.buffer = data[n:]
 		}
+		a.record(p[:n])
 		return n, nil
 	case err := <-a.errChan:
 		return 0, err
-	case <-a.stopChan:
+	case <-a.ctx.Done():
 		return 0, io.EOF
 	}
 }
 
+// record keeps audio returned by Read for Replay. Audio is recorded as it is consumed
+// rather than as it arrives, so a replay never repeats audio the consumer is yet to read.
+func (a *AudioStreamReader) record(p []byte) {
+	if a.replay != nil {
+		a.replay.write(p)
+	}
+}
+
+// Replay returns up to the replay buffer length of the audio most recently returned by
+// Read, oldest first, or nil if the client has no replay buffer configured
+func (a *AudioStreamReader) Replay() []byte {
+	if a.replay == nil {
+		return nil
+	}
+	return a.replay.snapshot()
+}
+
 // Close stops the audio stream and waits for cleanup to complete
 func (a *AudioStreamReader) Close() error {
 	a.closeOnce.Do(func() {
-		close(a.stopChan)
-		a.wg.Wait() // Wait for streamLoop to complete cleanup
+		a.tasks.Stop(context.Background()) // Also aborts the in-flight GET request
 		log.Printf("[Hikvision] AudioStreamReader: Cleanup complete for channel %s", a.session.ChannelID)
 	})
 	return nil
@@ -10,58 +10,195 @@ import (
 	"sync"
 	"time"
 
+	"github.com/acardace/hikvision-doorbell-server/internal/audio"
+	"github.com/acardace/hikvision-doorbell-server/internal/lifecycle"
 	"github.com/icholy/digest"
 )
 
+// StreamSocketOptions tunes the TCP connection an AudioStreamWriter sends audio over
+type StreamSocketOptions struct {
+	// NoDelay disables Nagle's algorithm so each small audio write is sent at once
+	// instead of being batched with the next
+	NoDelay bool
+
+	// WriteBuffer sets the kernel send buffer size in bytes (0 keeps the system default).
+	// A smaller buffer stops audio queueing up behind a slow link.
+	WriteBuffer int
+}
+
+// DefaultStreamSocketOptions sends writes immediately with the system's send buffer
+var DefaultStreamSocketOptions = StreamSocketOptions{NoDelay: true}
+
 // AudioStreamWriter continuously sends audio data to the device
 type AudioStreamWriter struct {
 	client    *Client
 	session   *AudioSession
 	url       string
-	stopChan  chan struct{}
-	dataChan  chan []byte
-	errChan   chan error
+	header    http.Header      // Extra request headers (the session ID, if sent as a header)
+	tasks     *lifecycle.Group // Runs sendLoop; stopped by Close or client shutdown
+	ctx       context.Context  // The tasks' context, also ended when the parent session context ends
+	dataChan  chan streamChunk
+	failed    chan struct{} // Closed when sendLoop stops on an error
+	failErr   error         // The error that stopped sendLoop, set before failed is closed
 	closeOnce sync.Once
-	wg        sync.WaitGroup // Wait for sendLoop to complete
+	pacing    bool        // Sleep after each write to match the playback rate
+	codec     audio.Codec // Channel codec, whose data rate drives pacing
+}
+
+// streamChunk is an item queued for sendLoop: audio to send, or a flush marker whose
+// channel is closed once everything queued before it has been sent
+type streamChunk struct {
+	data    []byte
+	flushed chan struct{}
 }
 
 // NewAudioStreamWriter creates a new continuous audio stream writer
-func (c *Client) NewAudioStreamWriter(session *AudioSession) *AudioStreamWriter {
-	url := fmt.Sprintf("http://%s/ISAPI/System/TwoWayAudio/channels/%s/audioData", c.host, session.ChannelID)
-	// if session.SessionID != "" {
-	// url += "?sessionId=" + session.SessionID
-	// }
+// The writer stops when ctx is cancelled or Close is called.
+func (c *Client) NewAudioStreamWriter(ctx context.Context, session *AudioSession) StreamWriter {
+	includeSessionID := c.audioDataParams.WriterSessionID
+	url := c.audioDataURL(session, includeSessionID)
+	log.Printf("[Hikvision] AudioStreamWriter: Session ID placement: %s", c.sessionIDPlacement(includeSessionID))
+
+	tasks := c.tasks.Child(ctx)
+
+	codec, ok := audio.LookupCodec(session.Codec)
+	if !ok {
+		codec, _ = audio.LookupCodec(audio.DefaultCodec)
+	}
 
 	return &AudioStreamWriter{
 		client:   c,
 		session:  session,
 		url:      url,
-		stopChan: make(chan struct{}),
-		dataChan: make(chan []byte, 100),
-		errChan:  make(chan error, 1),
+		header:   c.audioDataHeader(session, includeSessionID),
+		tasks:    tasks,
+		ctx:      tasks.Context(),
+		dataChan: make(chan streamChunk, 100),
+		failed:   make(chan struct{}),
+		pacing:   true,
+		codec:    codec,
 	}
 }
 
+// SetPacing enables or disables real-time pacing of writes. It must be called before Start.
+// With pacing disabled, data is pushed as fast as the connection accepts it and the
+// device is relied upon to buffer and clock playback.
+func (w *AudioStreamWriter) SetPacing(enabled bool) {
+	w.pacing = enabled
+}
+
 // Start begins the continuous sending loop
 func (w *AudioStreamWriter) Start() {
 	log.Printf("[Hikvision] AudioStreamWriter: Starting stream for channel %s", w.session.ChannelID)
-	w.wg.Add(1)
-	go w.sendLoop()
+	w.tasks.Go("audio writer send loop", func(context.Context) { w.sendLoop() })
 }
 
 // sendLoop continuously sends audio data via a persistent connection
 func (w *AudioStreamWriter) sendLoop() {
-	defer w.wg.Done()
+	// Establish the connection, retrying per the client's stream retry policy
+	var conn net.Conn
+	var release func()
+	err := w.client.streamRetry.Do(w.ctx, func(attempt int) error {
+		if attempt > 1 {
+			log.Printf("[Hikvision] AudioStreamWriter: Retrying connection for channel %s (attempt %d)", w.session.ChannelID, attempt)
+		}
+		var err error
+		conn, release, err = w.connect()
+		return err
+	})
+	if err != nil {
+		if w.ctx.Err() != nil {
+			log.Printf("[Hikvision] AudioStreamWriter: Stopped while connecting")
+			return
+		}
+		w.fail(err)
+		return
+	}
+
+	log.Printf("[Hikvision] AudioStreamWriter: Connection established, ready to send audio")
+
+	defer release()
+
+	// Now write audio data directly to the connection
+	chunkCount := 0
+	var pace pacer
+	for {
+		select {
+		case <-w.ctx.Done():
+			log.Printf("[Hikvision] AudioStreamWriter: Stopped after %d chunks", chunkCount)
+			return
+
+		case chunk := <-w.dataChan:
+			if chunk.flushed != nil {
+				close(chunk.flushed)
+				continue
+			}
+			data := chunk.data
+			if len(data) == 0 {
+				continue
+			}
+
+			chunkCount++
+			_, err := conn.Write(data)
+			if err != nil {
+				log.Printf("[Hikvision] AudioStreamWriter: Failed to write data: %v", err)
+				w.fail(err)
+				return
+			}
+
+			// Hold back to the codec's playback rate, correcting accumulated drift
+			if w.pacing {
+				pace.wait(w.codec.Duration(len(data)))
+			}
+
+			if chunkCount%100 == 0 {
+				log.Printf("[Hikvision] AudioStreamWriter: Sent %d chunks so far", chunkCount)
+			}
+		}
+	}
+}
+
+// fail records the error that stopped sendLoop and wakes anyone watching Failed
+func (w *AudioStreamWriter) fail(err error) {
+	w.failErr = err
+	close(w.failed)
+}
+
+// Failed returns a channel that is closed once the stream dies, e.g. because the
+// device closed the connection mid-playback. Err then reports why.
+func (w *AudioStreamWriter) Failed() <-chan struct{} {
+	return w.failed
+}
 
-	// Create a custom transport that gives us access to the connection
+// Err returns the error that stopped the stream, or nil while it is healthy
+func (w *AudioStreamWriter) Err() error {
+	select {
+	case <-w.failed:
+		return w.failErr
+	default:
+		return nil
+	}
+}
+
+// connect sends the PUT request that opens the audio stream and returns the raw
+// connection, plus a release func that closes it
+func (w *AudioStreamWriter) connect() (net.Conn, func(), error) {
+	// Create a custom transport that gives us access to the connection.
+	// Proxying is handled by dialDevice (CONNECT tunnel) so the raw writes reach the device.
 	var conn net.Conn
 
 	transport := &http.Transport{
+		Proxy: nil,
 		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-			c, err := net.Dial(network, addr)
+			if err := w.client.breaker.allow(); err != nil {
+				return nil, err
+			}
+			c, err := w.client.dialDevice(ctx, network, addr)
+			w.client.breaker.record(ctx, err)
 			if err != nil {
 				return nil, err
 			}
+			w.applySocketOptions(c)
 			conn = c
 			return c, nil
 		},
@@ -76,14 +213,20 @@ func (w *AudioStreamWriter) sendLoop() {
 		},
 	}
 
+	// Cancelled when this attempt fails or the connection is released
+	ctx, cancel := context.WithCancel(w.ctx)
+
 	// Make the PUT request to establish the connection
-	req, err := http.NewRequest("PUT", w.url, nil)
+	req, err := http.NewRequestWithContext(ctx, "PUT", w.url, nil)
 	if err != nil {
+		cancel()
 		log.Printf("[Hikvision] AudioStreamWriter: Failed to create request: %v", err)
-		w.errChan <- err
-		return
+		return nil, nil, err
 	}
 
+	for name, values := range w.header {
+		req.Header[name] = values
+	}
 	req.Header.Set("Content-Type", "application/octet-stream")
 	req.Header.Set("Content-Length", "0")
 
@@ -117,86 +260,104 @@ func (w *AudioStreamWriter) sendLoop() {
 	case httpResp = <-respChan:
 		// Success
 	case err := <-errChan:
-		w.errChan <- err
-		return
+		cancel()
+		return nil, nil, err
+	case <-w.ctx.Done():
+		cancel()
+		return nil, nil, w.ctx.Err()
 	case <-time.After(5 * time.Second):
+		cancel()
 		log.Printf("[Hikvision] AudioStreamWriter: Timeout waiting for response")
-		w.errChan <- fmt.Errorf("timeout")
-		return
+		return nil, nil, fmt.Errorf("timeout")
 	}
 
 	if conn == nil {
+		cancel()
+		httpResp.Body.Close()
 		log.Printf("[Hikvision] AudioStreamWriter: Connection not established")
-		w.errChan <- fmt.Errorf("connection not established")
-		return
+		return nil, nil, fmt.Errorf("connection not established")
 	}
 
-	log.Printf("[Hikvision] AudioStreamWriter: Connection established, ready to send audio")
-
-	// Defer cleanup
-	defer func() {
-		if httpResp != nil && httpResp.Body != nil {
-			httpResp.Body.Close()
-		}
-		if conn != nil {
-			conn.Close()
-		}
-	}()
-
-	// Now write audio data directly to the connection
-	chunkCount := 0
-	for {
-		select {
-		case <-w.stopChan:
-			log.Printf("[Hikvision] AudioStreamWriter: Stopped after %d chunks", chunkCount)
-			return
-
-		case data := <-w.dataChan:
-			if len(data) == 0 {
-				continue
-			}
+	release := func() {
+		httpResp.Body.Close()
+		conn.Close()
+		cancel()
+	}
+	return conn, release, nil
+}
 
-			chunkCount++
-			_, err := conn.Write(data)
-			if err != nil {
-				log.Printf("[Hikvision] AudioStreamWriter: Failed to write data: %v", err)
-				w.errChan <- err
-				return
-			}
+// applySocketOptions sets the client's stream socket options on conn. Both direct and
+// proxied (CONNECT) connections are plain *net.TCPConn; anything else is left alone.
+func (w *AudioStreamWriter) applySocketOptions(conn net.Conn) {
+	opts := w.client.streamSocket
 
-			// Add delay to match audio playback rate
-			// G.711 is 8000 samples/sec = 8000 bytes/sec
-			// For each chunk, delay = (chunk_size / 8000) seconds
-			chunkDuration := time.Duration(len(data)) * time.Second / 8000
-			time.Sleep(chunkDuration)
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		log.Printf("[Hikvision] AudioStreamWriter: Connection is %T, not TCP; socket options not applied", conn)
+		return
+	}
 
-			if chunkCount%100 == 0 {
-				log.Printf("[Hikvision] AudioStreamWriter: Sent %d chunks so far", chunkCount)
-			}
+	if err := tcpConn.SetNoDelay(opts.NoDelay); err != nil {
+		log.Printf("[Hikvision] AudioStreamWriter: Failed to set TCP_NODELAY=%t: %v", opts.NoDelay, err)
+	}
+	if opts.WriteBuffer > 0 {
+		if err := tcpConn.SetWriteBuffer(opts.WriteBuffer); err != nil {
+			log.Printf("[Hikvision] AudioStreamWriter: Failed to set send buffer to %d bytes: %v", opts.WriteBuffer, err)
 		}
 	}
+	log.Printf("[Hikvision] AudioStreamWriter: Socket options applied (TCP_NODELAY=%t, send buffer=%d)", opts.NoDelay, opts.WriteBuffer)
 }
 
 // Write implements io.Writer interface
 func (w *AudioStreamWriter) Write(p []byte) (n int, err error) {
+	// Don't queue data into a connection that is already dead
+	if err := w.Err(); err != nil {
+		return 0, err
+	}
+
 	data := make([]byte, len(p))
 	copy(data, p)
 
 	select {
-	case w.dataChan <- data:
+	case w.dataChan <- streamChunk{data: data}:
 		return len(p), nil
-	case <-w.stopChan:
+	case <-w.ctx.Done():
 		return 0, io.ErrClosedPipe
-	case err := <-w.errChan:
-		return 0, err
+	case <-w.failed:
+		return 0, w.failErr
+	}
+}
+
+// Flush waits until sendLoop has sent everything written before the call. With pacing
+// enabled that is also when the device has had time to play it.
+func (w *AudioStreamWriter) Flush(ctx context.Context) error {
+	flushed := make(chan struct{})
+	select {
+	case w.dataChan <- streamChunk{flushed: flushed}:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-w.ctx.Done():
+		return io.ErrClosedPipe
+	case <-w.failed:
+		return w.failErr
+	}
+
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-w.ctx.Done():
+		return io.ErrClosedPipe
+	case <-w.failed:
+		return w.failErr
 	}
 }
 
 // Close stops the audio stream writer and waits for cleanup to complete
 func (w *AudioStreamWriter) Close() error {
 	w.closeOnce.Do(func() {
-		close(w.stopChan)
-		w.wg.Wait() // Wait for sendLoop to complete cleanup
+		w.tasks.Stop(context.Background()) // Waits for sendLoop to complete cleanup
 		log.Printf("[Hikvision] AudioStreamWriter: Cleanup complete for channel %s", w.session.ChannelID)
 	})
 	return nil
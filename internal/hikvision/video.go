@@ -0,0 +1,119 @@
+package hikvision
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// DefaultRTSPPort is the device's RTSP port unless configured otherwise
+const DefaultRTSPPort = 554
+
+// StreamingChannelList is the response of /ISAPI/Streaming/channels
+type StreamingChannelList struct {
+	XMLName  xml.Name           `xml:"StreamingChannelList"`
+	Channels []StreamingChannel `xml:"StreamingChannel"`
+}
+
+// StreamingChannel describes one video stream (e.g. 101 main stream, 102 sub stream)
+type StreamingChannel struct {
+	ID          string                `xml:"id"`
+	ChannelName string                `xml:"channelName"`
+	Enabled     string                `xml:"enabled"`
+	Video       StreamingChannelVideo `xml:"Video"`
+}
+
+// StreamingChannelVideo holds the video encoding settings of a streaming channel
+type StreamingChannelVideo struct {
+	Enabled          string `xml:"enabled"`
+	VideoCodecType   string `xml:"videoCodecType"`
+	ResolutionWidth  int    `xml:"videoResolutionWidth"`
+	ResolutionHeight int    `xml:"videoResolutionHeight"`
+	MaxFrameRate     int    `xml:"maxFrameRate"` // In hundredths of a frame per second
+}
+
+// VideoStream is a video stream the device serves over RTSP
+type VideoStream struct {
+	ID        string
+	Name      string
+	Codec     string // e.g. "H.264"
+	Width     int
+	Height    int
+	FrameRate float64
+	RTSPURL   string // Without credentials; players authenticate with the device account
+}
+
+// SetRTSPPort sets the port used in RTSP stream URLs (0 uses DefaultRTSPPort)
+func (c *Client) SetRTSPPort(port int) {
+	c.rtspPort = port
+}
+
+// rtspURL returns the RTSP URL of a streaming channel
+func (c *Client) rtspURL(channelID string) string {
+	host, _, err := net.SplitHostPort(c.host)
+	if err != nil {
+		host = c.host // No port in the configured host
+	}
+
+	port := c.rtspPort
+	if port == 0 {
+		port = DefaultRTSPPort
+	}
+	return fmt.Sprintf("rtsp://%s/Streaming/Channels/%s", net.JoinHostPort(host, strconv.Itoa(port)), channelID)
+}
+
+// GetVideoStreams lists the device's enabled video streams and their RTSP URLs
+func (c *Client) GetVideoStreams(ctx context.Context) ([]VideoStream, error) {
+	url := fmt.Sprintf("http://%s/ISAPI/Streaming/channels", c.host)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		log.Printf("[Hikvision] GetVideoStreams: Request failed: %v", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("[Hikvision] GetVideoStreams: Error response body: %s", string(body))
+		return nil, fmt.Errorf("failed to get video streams: status %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var list StreamingChannelList
+	if err := xml.Unmarshal(body, &list); err != nil {
+		log.Printf("[Hikvision] GetVideoStreams: Failed to parse XML: %v", err)
+		return nil, fmt.Errorf("failed to parse streaming channels: %w", err)
+	}
+
+	streams := make([]VideoStream, 0, len(list.Channels))
+	for _, ch := range list.Channels {
+		if ch.Enabled == "false" || ch.Video.Enabled == "false" {
+			continue
+		}
+		streams = append(streams, VideoStream{
+			ID:        ch.ID,
+			Name:      ch.ChannelName,
+			Codec:     ch.Video.VideoCodecType,
+			Width:     ch.Video.ResolutionWidth,
+			Height:    ch.Video.ResolutionHeight,
+			FrameRate: float64(ch.Video.MaxFrameRate) / 100,
+			RTSPURL:   c.rtspURL(ch.ID),
+		})
+	}
+
+	log.Printf("[Hikvision] GetVideoStreams: Found %d enabled video streams", len(streams))
+	return streams, nil
+}
@@ -0,0 +1,108 @@
+package hikvision
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+)
+
+// Settings are changed by editing the device's own document and sending it back, so
+// elements this client doesn't model are preserved instead of being dropped by a
+// decode/encode round trip through a partial struct.
+
+// elementPattern matches an element with text content, with or without a namespace prefix
+func elementPattern(name string) *regexp.Regexp {
+	name = regexp.QuoteMeta(name)
+	return regexp.MustCompile(`(<(?:[\w.-]+:)?` + name + `>)[^<]*(</(?:[\w.-]+:)?` + name + `>)`)
+}
+
+// replaceElementText sets the text of every element matching pattern to value, escaped.
+// ok is false if the document has no such element.
+func replaceElementText(body []byte, pattern *regexp.Regexp, value string) (updated []byte, ok bool) {
+	if !pattern.Match(body) {
+		return nil, false
+	}
+
+	var escaped bytes.Buffer
+	xml.EscapeText(&escaped, []byte(value))
+	updated = pattern.ReplaceAllFunc(body, func(element []byte) []byte {
+		parts := pattern.FindSubmatch(element)
+		return append(append(append([]byte{}, parts[1]...), escaped.Bytes()...), parts[2]...)
+	})
+	return updated, true
+}
+
+// getDocument fetches an ISAPI document. name identifies the caller in logs.
+func (c *Client) getDocument(ctx context.Context, name, path string) ([]byte, error) {
+	url := fmt.Sprintf("http://%s%s", c.host, path)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		log.Printf("[Hikvision] %s: Request failed: %v", name, err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("[Hikvision] %s: Error response body: %s", name, string(body))
+		return nil, &statusError{path: path, status: resp.StatusCode, body: string(body)}
+	}
+	return body, nil
+}
+
+// putDocument sends an edited ISAPI document back and checks the device accepted it
+func (c *Client) putDocument(ctx context.Context, name, path string, body []byte) error {
+	url := fmt.Sprintf("http://%s%s", c.host, path)
+	req, err := http.NewRequestWithContext(ctx, "PUT", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/xml")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		log.Printf("[Hikvision] %s: Request failed: %v", name, err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("[Hikvision] %s: Error response body: %s", name, string(respBody))
+		return &statusError{path: path, status: resp.StatusCode, body: string(respBody)}
+	}
+	if err := checkResponseStatus(respBody); err != nil {
+		log.Printf("[Hikvision] %s: Error response body: %s", name, string(respBody))
+		return fmt.Errorf("PUT %s failed: %w", path, err)
+	}
+	return nil
+}
+
+// statusError is a non-200 answer to an ISAPI request
+type statusError struct {
+	path   string
+	status int
+	body   string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s failed: status %d, body: %s", e.path, e.status, e.body)
+}
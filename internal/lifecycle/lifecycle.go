@@ -0,0 +1,143 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Group owns a set of background goroutines so they can be stopped and waited for
+// together. Groups form a tree: stopping a group stops its children too, and waiting
+// on a group also waits for goroutines started in its children. A server keeps one
+// root group and gives each long-lived component (a stream reader, a session) a child.
+type Group struct {
+	parent *Group
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	stopped bool           // Set by Stop; no goroutines are started after it
+	running map[string]int // Goroutines still running, by name, for reporting leaks
+}
+
+// New creates a root group
+func New() *Group {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Group{ctx: ctx, cancel: cancel, running: make(map[string]int)}
+}
+
+// Child creates a group whose context ends when ctx ends or g is stopped, whichever
+// comes first. It can be stopped on its own, and g's Stop waits for it as well.
+func (g *Group) Child(ctx context.Context) *Group {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(g.ctx, cancel)
+	return &Group{
+		parent:  g,
+		ctx:     ctx,
+		cancel:  func() { stop(); cancel() },
+		running: make(map[string]int),
+	}
+}
+
+// Context returns the group's context, which is cancelled when the group is stopped
+func (g *Group) Context() context.Context {
+	return g.ctx
+}
+
+// Go runs fn on a new goroutine with the group's context. fn must return once that
+// context is done. name identifies the goroutine if it fails to stop in time. Once
+// the group or one of its ancestors is stopped, fn is not run.
+func (g *Group) Go(name string, fn func(ctx context.Context)) {
+	for p := g; p != nil; p = p.parent {
+		if !p.add(name) {
+			// Undo the ancestors below p that already counted it
+			for q := g; q != p; q = q.parent {
+				q.done(name)
+			}
+			return
+		}
+	}
+	go func() {
+		defer func() {
+			for p := g; p != nil; p = p.parent {
+				p.done(name)
+			}
+		}()
+		fn(g.ctx)
+	}()
+}
+
+// GoWithin is Go for a goroutine that belongs to a shorter-lived operation: its context
+// also ends when ctx does
+func (g *Group) GoWithin(ctx context.Context, name string, fn func(ctx context.Context)) {
+	child := g.Child(ctx)
+	child.Go(name, func(ctx context.Context) {
+		defer child.cancel()
+		fn(ctx)
+	})
+}
+
+// Stop cancels the group's context and waits for its goroutines, including those of its
+// children, to return. If ctx ends first it returns an error naming the ones still running.
+func (g *Group) Stop(ctx context.Context) error {
+	g.mu.Lock()
+	g.stopped = true
+	g.mu.Unlock()
+	g.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("goroutines still running (%v): %w", g.Running(), ctx.Err())
+	}
+}
+
+// Running returns the names of the goroutines still running in the group and its children
+func (g *Group) Running() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	names := make([]string, 0, len(g.running))
+	for name, n := range g.running {
+		if n > 1 {
+			name = fmt.Sprintf("%s x%d", name, n)
+		}
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// add counts a goroutine about to start, unless the group is stopped
+func (g *Group) add(name string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.stopped {
+		return false
+	}
+	g.wg.Add(1)
+	g.running[name]++
+	return true
+}
+
+// done counts a goroutine that returned
+func (g *Group) done(name string) {
+	g.mu.Lock()
+	g.running[name]--
+	if g.running[name] == 0 {
+		delete(g.running, name)
+	}
+	g.mu.Unlock()
+
+	g.wg.Done()
+}
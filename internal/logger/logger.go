@@ -1,44 +1,142 @@
 package logger
 
 import (
+	"context"
+	"io"
+	"log"
 	"log/slog"
 	"os"
+	"sync"
+	"sync/atomic"
 )
 
 var (
-	// Default logger instance
+	// Default logger instance. It is never reassigned: level and format changes swap
+	// what it writes through, so it can be used from any goroutine while they happen.
 	Log *slog.Logger
+
+	// level is shared by every handler, so changing it needs no new handler
+	level = new(slog.LevelVar)
+
+	// current is the text or JSON handler Log writes through
+	current atomic.Pointer[slog.Handler]
+
+	// output is where all log handlers write (stdout unless a log file is configured)
+	output io.Writer = os.Stdout
+
+	// logFile is set when logging to a file, so it can be reopened after rotation
+	logFile *reopenableFile
 )
 
 func init() {
 	// Initialize with a text handler for development
 	// In production, use JSON handler for better log aggregation
-	handler := slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
-	})
-	Log = slog.New(handler)
+	setFormat(false)
+	Log = slog.New(switchHandler{})
 }
 
 // SetLevel changes the logging level
-func SetLevel(level slog.Level) {
-	handler := slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
-		Level: level,
-	})
-	Log = slog.New(handler)
+func SetLevel(l slog.Level) {
+	level.Set(l)
 }
 
 // SetJSON switches to JSON output (recommended for production)
 func SetJSON() {
-	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
-	})
-	Log = slog.New(handler)
+	SetJSONWithLevel(slog.LevelInfo)
 }
 
 // SetJSONWithLevel switches to JSON output with custom level
-func SetJSONWithLevel(level slog.Level) {
-	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: level,
-	})
-	Log = slog.New(handler)
+func SetJSONWithLevel(l slog.Level) {
+	Configure(l, true)
+}
+
+// Configure sets the logging level and output format in one step
+func Configure(l slog.Level, json bool) {
+	level.Set(l)
+	setFormat(json)
+}
+
+// setFormat builds a text or JSON handler on the current output and makes Log use it
+func setFormat(json bool) {
+	var handler slog.Handler
+	if json {
+		handler = slog.NewJSONHandler(output, &slog.HandlerOptions{Level: level})
+	} else {
+		handler = slog.NewTextHandler(output, &slog.HandlerOptions{Level: level})
+	}
+	current.Store(&handler)
+}
+
+// switchHandler forwards to the current handler. Loggers derived with With or
+// WithGroup keep the handler that was current when they were created.
+type switchHandler struct{}
+
+func (switchHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	return (*current.Load()).Enabled(ctx, l)
+}
+
+func (switchHandler) Handle(ctx context.Context, r slog.Record) error {
+	return (*current.Load()).Handle(ctx, r)
+}
+
+func (switchHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return (*current.Load()).WithAttrs(attrs)
+}
+
+func (switchHandler) WithGroup(name string) slog.Handler {
+	return (*current.Load()).WithGroup(name)
+}
+
+// SetFile redirects all log output (including the standard log package) to a file.
+// Call Configure afterwards to rebuild the slog handler on the new output.
+func SetFile(path string) error {
+	f, err := openLogFile(path)
+	if err != nil {
+		return err
+	}
+
+	logFile = &reopenableFile{path: path, file: f}
+	output = logFile
+	log.SetOutput(output)
+	return nil
+}
+
+// Reopen reopens the log file so logging continues after external rotation (e.g. logrotate).
+// It is a no-op when logging to stdout.
+func Reopen() error {
+	if logFile == nil {
+		return nil
+	}
+	return logFile.reopen()
+}
+
+// reopenableFile is an io.Writer over a file that can be swapped for a fresh handle
+type reopenableFile struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+func (r *reopenableFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Write(p)
+}
+
+func (r *reopenableFile) reopen() error {
+	f, err := openLogFile(r.path)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	old := r.file
+	r.file = f
+	r.mu.Unlock()
+
+	return old.Close()
+}
+
+func openLogFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 }
@@ -0,0 +1,87 @@
+package retry
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Policy describes how an operation is retried: how many attempts, and how long to
+// wait between them (exponential backoff with optional jitter).
+type Policy struct {
+	// MaxAttempts is the total number of attempts including the first (0 or 1 = no retries, -1 = unlimited)
+	MaxAttempts int `yaml:"max_attempts"`
+
+	// BaseDelay is the wait before the first retry
+	BaseDelay time.Duration `yaml:"base_delay"`
+
+	// MaxDelay caps the wait between attempts (0 = no cap)
+	MaxDelay time.Duration `yaml:"max_delay"`
+
+	// Multiplier grows the delay after each retry (values below 1 are treated as 1)
+	Multiplier float64 `yaml:"multiplier"`
+
+	// Jitter randomizes each delay by up to this fraction (0..1) to avoid retries in lockstep
+	Jitter float64 `yaml:"jitter"`
+}
+
+// None is a policy that never retries
+var None = Policy{MaxAttempts: 1}
+
+// Allows reports whether another attempt may follow the given number of attempts made so far
+func (p Policy) Allows(attempts int) bool {
+	return p.MaxAttempts < 0 || attempts < p.MaxAttempts
+}
+
+// Delay returns the wait before retry number n (1 for the first retry)
+func (p Policy) Delay(n int) time.Duration {
+	if n < 1 || p.BaseDelay <= 0 {
+		return 0
+	}
+
+	multiplier := math.Max(p.Multiplier, 1)
+	delay := float64(p.BaseDelay) * math.Pow(multiplier, float64(n-1))
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+
+	if p.Jitter > 0 {
+		jitter := math.Min(p.Jitter, 1)
+		delay *= 1 + jitter*(2*rand.Float64()-1)
+	}
+
+	return time.Duration(delay)
+}
+
+// Wait sleeps for the delay before retry number n, returning early with ctx's error if it ends
+func (p Policy) Wait(ctx context.Context, n int) error {
+	delay := p.Delay(n)
+	if delay <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Do calls fn until it succeeds, the policy runs out of attempts, or ctx ends.
+// It returns the last error from fn (or ctx's error if cancelled while waiting).
+func (p Policy) Do(ctx context.Context, fn func(attempt int) error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn(attempt)
+		if err == nil || !p.Allows(attempt) || ctx.Err() != nil {
+			return err
+		}
+		if waitErr := p.Wait(ctx, attempt); waitErr != nil {
+			return err
+		}
+	}
+}
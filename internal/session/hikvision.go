@@ -3,6 +3,8 @@ package session
 import (
 	"context"
 	"log/slog"
+	"sync"
+	"time"
 
 	"github.com/acardace/hikvision-doorbell-server/internal/hikvision"
 	"github.com/acardace/hikvision-doorbell-server/internal/logger"
@@ -10,20 +12,219 @@ import (
 
 // HikvisionSessionManager implements SessionManager for Hikvision devices
 type HikvisionSessionManager struct {
-	client *hikvision.Client
+	client hikvision.DeviceClient
+
+	// Channel list cache, invalidated whenever a channel is opened or closed
+	cacheMu  sync.Mutex
+	cacheTTL time.Duration // 0 disables caching
+	cached   *hikvision.TwoWayAudioChannelList
+	cachedAt time.Time
+	cacheGen uint64 // Bumped on invalidation so fetches started before it aren't cached
+
+	// Cool-down between closing a channel and reopening it, for devices that
+	// release channels asynchronously
+	releaseMu   sync.Mutex
+	reopenDelay time.Duration // 0 disables the cool-down
+	releasedAt  map[string]time.Time
+
+	// Requests wrapping open and close, for devices that need a channel claimed first
+	claimHook   hikvision.ChannelHook
+	releaseHook hikvision.ChannelHook
+
+	// Channel selection; lastIndex is the list position of the channel opened last
+	policyMu  sync.Mutex
+	policy    ChannelPolicy
+	lastIndex int
 }
 
 // NewHikvisionSessionManager creates a new Hikvision session manager
-func NewHikvisionSessionManager(client *hikvision.Client) *HikvisionSessionManager {
+func NewHikvisionSessionManager(client hikvision.DeviceClient) *HikvisionSessionManager {
 	return &HikvisionSessionManager{
-		client: client,
+		client:     client,
+		releasedAt: make(map[string]time.Time),
+		policy:     ChannelPolicyFirst,
+		lastIndex:  -1,
+	}
+}
+
+// SetChannelPolicy sets how AcquireChannel picks among free channels
+func (m *HikvisionSessionManager) SetChannelPolicy(policy ChannelPolicy) {
+	m.policyMu.Lock()
+	defer m.policyMu.Unlock()
+
+	m.policy = policy
+	m.lastIndex = -1
+}
+
+// scanStart returns the list position AcquireChannel starts looking for a free channel at
+func (m *HikvisionSessionManager) scanStart(count int) int {
+	m.policyMu.Lock()
+	defer m.policyMu.Unlock()
+
+	if m.policy != ChannelPolicyRoundRobin {
+		return 0
+	}
+	return (m.lastIndex + 1) % count
+}
+
+// markUsed records the list position of the channel just opened
+func (m *HikvisionSessionManager) markUsed(index int) {
+	m.policyMu.Lock()
+	defer m.policyMu.Unlock()
+
+	m.lastIndex = index
+}
+
+// SetReopenDelay sets how long a released channel cools down before it can be reopened (0 disables)
+func (m *HikvisionSessionManager) SetReopenDelay(delay time.Duration) {
+	m.releaseMu.Lock()
+	defer m.releaseMu.Unlock()
+
+	m.reopenDelay = delay
+}
+
+// waitReopen blocks until the channel's cool-down since its last release has elapsed
+func (m *HikvisionSessionManager) waitReopen(ctx context.Context, channelID string) error {
+	m.releaseMu.Lock()
+	remaining := m.reopenDelay - time.Since(m.releasedAt[channelID])
+	m.releaseMu.Unlock()
+
+	if remaining <= 0 {
+		return nil
+	}
+
+	logger.Log.Debug("waiting for channel cool-down before reopening",
+		slog.String("component", "session_manager"),
+		slog.String("channel_id", channelID),
+		slog.Duration("remaining", remaining))
+
+	timer := time.NewTimer(remaining)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SetChannelHooks sets the requests issued before opening and after closing a channel.
+// Hooks with an empty path are skipped.
+func (m *HikvisionSessionManager) SetChannelHooks(claim, release hikvision.ChannelHook) {
+	m.claimHook = claim
+	m.releaseHook = release
+}
+
+// claim runs the claim hook, if configured, before a channel is opened
+func (m *HikvisionSessionManager) claim(ctx context.Context, channelID string) error {
+	if !m.claimHook.Enabled() {
+		return nil
+	}
+
+	if err := m.client.CallChannelHook(ctx, m.claimHook, channelID); err != nil {
+		logger.Log.Error("failed to claim audio channel",
+			slog.String("component", "session_manager"),
+			slog.String("channel_id", channelID),
+			slog.String("error", err.Error()))
+		return err
+	}
+	return nil
+}
+
+// unclaim runs the release hook, if configured, after a channel is closed
+func (m *HikvisionSessionManager) unclaim(ctx context.Context, channelID string) error {
+	if !m.releaseHook.Enabled() {
+		return nil
 	}
+
+	if err := m.client.CallChannelHook(ctx, m.releaseHook, channelID); err != nil {
+		logger.Log.Error("failed to release audio channel claim",
+			slog.String("component", "session_manager"),
+			slog.String("channel_id", channelID),
+			slog.String("error", err.Error()))
+		return err
+	}
+	return nil
 }
 
-// AcquireChannel finds and opens an available audio channel
-func (m *HikvisionSessionManager) AcquireChannel(ctx context.Context) (*AudioSession, error) {
+// markReleased starts the channel's reopen cool-down
+func (m *HikvisionSessionManager) markReleased(channelID string) {
+	m.releaseMu.Lock()
+	defer m.releaseMu.Unlock()
+
+	m.releasedAt[channelID] = time.Now()
+}
+
+// SetChannelCacheTTL sets how long the device's channel list is reused (0 disables caching)
+func (m *HikvisionSessionManager) SetChannelCacheTTL(ttl time.Duration) {
+	m.cacheMu.Lock()
+	defer m.cacheMu.Unlock()
+
+	m.cacheTTL = ttl
+	m.cached = nil
+	m.cacheGen++
+}
+
+// getChannels returns the channel list, from the cache if it is still fresh
+func (m *HikvisionSessionManager) getChannels(ctx context.Context, quiet bool) (*hikvision.TwoWayAudioChannelList, error) {
+	m.cacheMu.Lock()
+	if m.cached != nil && time.Since(m.cachedAt) < m.cacheTTL {
+		channels := m.cached
+		m.cacheMu.Unlock()
+		return channels, nil
+	}
+	gen := m.cacheGen
+	m.cacheMu.Unlock()
+
+	var channels *hikvision.TwoWayAudioChannelList
+	var err error
+	if quiet {
+		channels, err = m.client.GetTwoWayAudioChannelsQuiet(ctx)
+	} else {
+		channels, err = m.client.GetTwoWayAudioChannels(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m.cacheMu.Lock()
+	if m.cacheTTL > 0 && m.cacheGen == gen {
+		m.cached = channels
+		m.cachedAt = time.Now()
+	}
+	m.cacheMu.Unlock()
+
+	return channels, nil
+}
+
+// invalidateChannels drops the cached channel list
+func (m *HikvisionSessionManager) invalidateChannels() {
+	m.cacheMu.Lock()
+	defer m.cacheMu.Unlock()
+
+	m.cached = nil
+	m.cacheGen++
+}
+
+// CheckDevice verifies the device is reachable, reusing the cached channel list if fresh
+func (m *HikvisionSessionManager) CheckDevice(ctx context.Context) error {
+	_, err := m.getChannels(ctx, true)
+	return err
+}
+
+// AcquireChannel finds and opens an available audio channel in the given mode.
+// A non-empty audioInputID restricts the search to channels bound to that input,
+// returning ErrUnknownAudioInput if there are none. It returns ErrNoChannelsConfigured
+// if the device has no channels and ErrAllChannelsBusy if they are all in use.
+func (m *HikvisionSessionManager) AcquireChannel(ctx context.Context, mode AudioMode, audioInputID string) (*AudioSession, error) {
+	hikMode, err := hikvision.ParseAudioMode(string(mode))
+	if err != nil {
+		return nil, err
+	}
+
 	// Get available channels from device
-	channels, err := m.client.GetTwoWayAudioChannels()
+	channels, err := m.getChannels(ctx, false)
 	if err != nil {
 		logger.Log.Error("failed to get audio channels",
 			slog.String("component", "session_manager"),
@@ -32,51 +233,98 @@ func (m *HikvisionSessionManager) AcquireChannel(ctx context.Context) (*AudioSes
 	}
 
 	if len(channels.Channels) == 0 {
-		logger.Log.Warn("no audio channels available on device",
+		logger.Log.Error("no audio channels configured on device",
 			slog.String("component", "session_manager"))
-		return nil, ErrNoAvailableChannels
+		return nil, ErrNoChannelsConfigured
 	}
 
-	// Find first available channel (Enabled == "false" means available)
-	var channelID string
-	for _, ch := range channels.Channels {
+	// Find an available channel (Enabled == "false" means available), scanning from
+	// the start of the list or, round-robin, from after the channel used last
+	var channelID, codec, inputID string
+	channelIndex := -1
+	inputFound := false
+	start := m.scanStart(len(channels.Channels))
+	for n := range channels.Channels {
+		i := (start + n) % len(channels.Channels)
+		ch := channels.Channels[i]
+		if audioInputID != "" && ch.AudioInputID != audioInputID {
+			continue
+		}
+		inputFound = true
 		if ch.Enabled == "false" {
 			channelID = ch.ID
+			codec = ch.AudioCompressionType
+			inputID = ch.AudioInputID
+			channelIndex = i
 			break
 		}
 	}
 
+	if !inputFound {
+		logger.Log.Warn("no audio channel bound to requested input",
+			slog.String("component", "session_manager"),
+			slog.String("audio_input_id", audioInputID))
+		return nil, ErrUnknownAudioInput
+	}
+
 	if channelID == "" {
 		logger.Log.Warn("no available channels, all in use",
 			slog.String("component", "session_manager"),
 			slog.Int("total_channels", len(channels.Channels)))
-		return nil, ErrNoAvailableChannels
+		return nil, ErrAllChannelsBusy
 	}
 
-	// Open the channel
-	hikSession, err := m.client.OpenAudioChannel(channelID)
+	// Give the device time to finish releasing the channel if it was just closed
+	if err := m.waitReopen(ctx, channelID); err != nil {
+		return nil, err
+	}
+
+	// Some devices reject the open unless the channel was claimed first
+	if err := m.claim(ctx, channelID); err != nil {
+		return nil, err
+	}
+
+	// Open the channel; its state changes even if the open fails partway
+	hikSession, err := m.client.OpenAudioChannel(ctx, channelID, hikMode)
+	m.invalidateChannels()
 	if err != nil {
 		logger.Log.Error("failed to open audio channel",
 			slog.String("component", "session_manager"),
 			slog.String("channel_id", channelID),
 			slog.String("error", err.Error()))
+		m.unclaim(context.Background(), channelID)
 		return nil, err
 	}
+	m.markUsed(channelIndex)
 
 	logger.Log.Info("acquired audio channel",
 		slog.String("component", "session_manager"),
 		slog.String("channel_id", channelID),
-		slog.String("session_id", hikSession.SessionID))
+		slog.String("session_id", hikSession.SessionID),
+		slog.String("mode", string(hikMode)),
+		slog.String("codec", codec),
+		slog.String("audio_input_id", inputID))
 
 	return &AudioSession{
-		ChannelID: hikSession.ChannelID,
-		SessionID: hikSession.SessionID,
+		ChannelID:    hikSession.ChannelID,
+		SessionID:    hikSession.SessionID,
+		Mode:         AudioMode(hikMode),
+		Codec:        codec,
+		AudioInputID: inputID,
 	}, nil
 }
 
 // ReleaseChannel closes an audio channel by its ID
 func (m *HikvisionSessionManager) ReleaseChannel(ctx context.Context, channelID string) error {
-	err := m.client.CloseAudioChannel(channelID)
+	err := m.client.CloseAudioChannel(ctx, channelID)
+	m.invalidateChannels()
+	m.markReleased(channelID)
+
+	// Release the claim even if the close failed, so the device doesn't stay locked
+	if hookErr := m.unclaim(ctx, channelID); hookErr != nil && err == nil {
+		return hookErr
+	}
+
 	if err != nil {
 		logger.Log.Error("failed to close audio channel",
 			slog.String("component", "session_manager"),
@@ -94,7 +342,7 @@ func (m *HikvisionSessionManager) ReleaseChannel(ctx context.Context, channelID
 
 // ListChannels returns all available channels and their status
 func (m *HikvisionSessionManager) ListChannels(ctx context.Context) ([]ChannelInfo, error) {
-	channels, err := m.client.GetTwoWayAudioChannels()
+	channels, err := m.getChannels(ctx, false)
 	if err != nil {
 		logger.Log.Error("failed to get audio channels",
 			slog.String("component", "session_manager"),
@@ -105,10 +353,52 @@ func (m *HikvisionSessionManager) ListChannels(ctx context.Context) ([]ChannelIn
 	result := make([]ChannelInfo, 0, len(channels.Channels))
 	for _, ch := range channels.Channels {
 		result = append(result, ChannelInfo{
-			ID:      ch.ID,
-			Enabled: ch.Enabled == "true",
+			ID:              ch.ID,
+			Enabled:         ch.Enabled == "true",
+			CompressionType: ch.AudioCompressionType,
+			AudioInputID:    ch.AudioInputID,
+			AudioOutputID:   ch.AudioOutputID,
 		})
 	}
 
 	return result, nil
 }
+
+// SetAudioOutput routes a channel to another device audio output
+func (m *HikvisionSessionManager) SetAudioOutput(ctx context.Context, channelID, outputID string) error {
+	err := m.client.SetAudioOutput(ctx, channelID, outputID)
+	m.invalidateChannels()
+	if err != nil {
+		logger.Log.Error("failed to set audio output",
+			slog.String("component", "session_manager"),
+			slog.String("channel_id", channelID),
+			slog.String("output_id", outputID),
+			slog.String("error", err.Error()))
+		return err
+	}
+
+	logger.Log.Info("set audio output",
+		slog.String("component", "session_manager"),
+		slog.String("channel_id", channelID),
+		slog.String("output_id", outputID))
+	return nil
+}
+
+// ChannelCapabilities returns the codecs and rates a channel supports
+func (m *HikvisionSessionManager) ChannelCapabilities(ctx context.Context, channelID string) (*ChannelCapabilities, error) {
+	caps, err := m.client.GetTwoWayAudioChannelCapabilities(ctx, channelID)
+	if err != nil {
+		logger.Log.Error("failed to get channel capabilities",
+			slog.String("component", "session_manager"),
+			slog.String("channel_id", channelID),
+			slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	return &ChannelCapabilities{
+		ChannelID:   caps.ChannelID,
+		Codec:       caps.Codec,
+		Codecs:      caps.Codecs,
+		SampleRates: caps.SampleRates,
+	}, nil
+}
@@ -6,31 +6,89 @@ import (
 )
 
 var (
-	// ErrNoAvailableChannels is returned when all channels are in use
-	ErrNoAvailableChannels = errors.New("no available channels")
+	// ErrNoChannelsConfigured is returned when the device reports no two-way audio
+	// channels at all, which waiting will not fix
+	ErrNoChannelsConfigured = errors.New("no two-way audio channels configured on device")
+
+	// ErrAllChannelsBusy is returned when every channel is in use
+	ErrAllChannelsBusy = errors.New("all audio channels are in use")
+
+	// ErrUnknownAudioInput is returned when no channel is bound to the requested audio input
+	ErrUnknownAudioInput = errors.New("no channel for audio input")
+)
+
+// AudioMode selects which audio directions a channel is acquired for
+type AudioMode string
+
+const (
+	// AudioModeBoth carries audio both ways (full duplex)
+	AudioModeBoth AudioMode = "both"
+
+	// AudioModeListen carries device audio to the client only
+	AudioModeListen AudioMode = "listen"
+
+	// AudioModeTalk carries client audio to the device only
+	AudioModeTalk AudioMode = "talk"
+)
+
+// ChannelPolicy selects which free channel AcquireChannel opens
+type ChannelPolicy string
+
+const (
+	// ChannelPolicyFirst opens the first free channel in the device's list
+	ChannelPolicyFirst ChannelPolicy = "first"
+
+	// ChannelPolicyRoundRobin opens the next free channel after the one used last,
+	// spreading use across channels
+	ChannelPolicyRoundRobin ChannelPolicy = "round_robin"
 )
 
 // AudioSession represents an active audio session with a device
 type AudioSession struct {
 	ChannelID string
 	SessionID string
+	Mode      AudioMode
+	Codec     string // Audio compression type configured on the channel (e.g. "G.711ulaw")
+
+	AudioInputID string // Device audio input (microphone) bound to the channel
 }
 
 // ChannelInfo represents information about an audio channel
 type ChannelInfo struct {
-	ID      string
-	Enabled bool // true if channel is currently in use
+	ID              string
+	Enabled         bool   // true if channel is currently in use
+	CompressionType string // Audio codec configured on the channel (e.g. "G.711ulaw")
+	AudioInputID    string // Device audio input (microphone) bound to the channel
+	AudioOutputID   string // Device audio output (speaker) bound to the channel
+}
+
+// ChannelCapabilities describes the codecs and rates an audio channel supports
+type ChannelCapabilities struct {
+	ChannelID   string
+	Codec       string   // Codec currently configured on the channel
+	Codecs      []string // Supported codecs (e.g. "G.711ulaw", "G.711alaw")
+	SampleRates []int    // Supported sample rates in Hz
 }
 
 // SessionManager manages audio sessions with devices
 // This interface allows for different backend implementations (Hikvision, Dahua, etc.)
 type SessionManager interface {
-	// AcquireChannel finds and opens an available audio channel
-	AcquireChannel(ctx context.Context) (*AudioSession, error)
+	// AcquireChannel finds and opens an available audio channel in the given mode.
+	// A non-empty audioInputID restricts the search to channels bound to that input.
+	AcquireChannel(ctx context.Context, mode AudioMode, audioInputID string) (*AudioSession, error)
 
 	// ReleaseChannel closes an audio channel by its ID
 	ReleaseChannel(ctx context.Context, channelID string) error
 
 	// ListChannels returns all available channels and their status
 	ListChannels(ctx context.Context) ([]ChannelInfo, error)
+
+	// SetAudioOutput routes a channel to another device audio output (speaker or line-out)
+	SetAudioOutput(ctx context.Context, channelID, outputID string) error
+
+	// ChannelCapabilities returns the codecs and rates a channel supports
+	ChannelCapabilities(ctx context.Context, channelID string) (*ChannelCapabilities, error)
+
+	// CheckDevice returns an error if the device is unreachable
+	CheckDevice(ctx context.Context) error
 }
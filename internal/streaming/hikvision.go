@@ -2,8 +2,13 @@ package streaming
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"log/slog"
+	"math"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/acardace/hikvision-doorbell-server/internal/audio"
 	"github.com/acardace/hikvision-doorbell-server/internal/hikvision"
@@ -13,34 +18,62 @@ import (
 	"github.com/pion/webrtc/v4/pkg/media"
 )
 
+// underrunTolerance is how late device audio may arrive before the client is taken to
+// have run out of audio; browser jitter buffers absorb delays shorter than this
+const underrunTolerance = 60 * time.Millisecond
+
 // HikvisionAudioStreamer implements AudioStreamer for Hikvision devices
 type HikvisionAudioStreamer struct {
-	client      *hikvision.Client
-	audioWriter *hikvision.AudioStreamWriter
-	audioReader *hikvision.AudioStreamReader
+	client      hikvision.DeviceClient
+	audioWriter hikvision.StreamWriter
+	audioReader hikvision.StreamReader
+	inputLevel  atomic.Uint64   // math.Float64bits of the smoothed input RMS level
+	levelAt     atomic.Int64    // UnixNano timestamp of the last level update
+	bytesSent   atomic.Int64    // Audio written to the device
+	bytesRecv   atomic.Int64    // Audio read from the device
+	underruns   atomic.Int64    // Times the client ran out of device audio
+	gapNanos    atomic.Int64    // Total length of those gaps
+	frames      int             // Device frames aggregated into each RTP packet sent to the client
+	deviceCodec audio.Codec     // Codec of the channel, which tracks in another codec are converted from/to
+	ready       <-chan struct{} // Closed once the client can receive audio (nil sends at once)
 }
 
 // NewHikvisionAudioStreamer creates a new Hikvision audio streamer
-func NewHikvisionAudioStreamer(client *hikvision.Client) *HikvisionAudioStreamer {
+func NewHikvisionAudioStreamer(client hikvision.DeviceClient) *HikvisionAudioStreamer {
+	defaultCodec, _ := audio.LookupCodec(audio.DefaultCodec)
 	return &HikvisionAudioStreamer{
-		client: client,
+		client:      client,
+		frames:      1,
+		deviceCodec: defaultCodec,
 	}
 }
 
+// SetFramesPerPacket sets how many device audio frames are sent to the client in each
+// RTP packet. More frames mean fewer, larger packets at the cost of latency. Must be
+// called before StreamDeviceToClient.
+func (s *HikvisionAudioStreamer) SetFramesPerPacket(frames int) {
+	s.frames = max(frames, 1)
+}
+
 // Start begins the audio streaming session
 func (s *HikvisionAudioStreamer) Start(ctx context.Context, sess *session.AudioSession) error {
 	// Convert to Hikvision AudioSession
 	hikSession := &hikvision.AudioSession{
 		ChannelID: sess.ChannelID,
 		SessionID: sess.SessionID,
+		Mode:      hikvision.AudioMode(sess.Mode),
+		Codec:     sess.Codec,
+	}
+	if codec, ok := audio.LookupCodec(sess.Codec); ok {
+		s.deviceCodec = codec
 	}
 
 	// Create and start audio writer (for sending to doorbell)
-	s.audioWriter = s.client.NewAudioStreamWriter(hikSession)
+	s.audioWriter = s.client.NewAudioStreamWriter(ctx, hikSession)
 	s.audioWriter.Start()
 
 	// Create and start audio reader (for receiving from doorbell)
-	s.audioReader = s.client.NewAudioStreamReader(hikSession)
+	s.audioReader = s.client.NewAudioStreamReader(ctx, hikSession)
 	s.audioReader.Start()
 
 	logger.Log.Info("started audio streaming session",
@@ -50,12 +83,58 @@ func (s *HikvisionAudioStreamer) Start(ctx context.Context, sess *session.AudioS
 	return nil
 }
 
+// HoldUntil holds device audio back from the client until ready is closed (e.g. when
+// the peer connects), then sends the reader's replay buffer so the client hears what
+// was said while it was connecting. Must be called before StreamDeviceToClient.
+func (s *HikvisionAudioStreamer) HoldUntil(ready <-chan struct{}) {
+	s.ready = ready
+}
+
 // StreamDeviceToClient reads audio from the device and sends to WebRTC client
 func (s *HikvisionAudioStreamer) StreamDeviceToClient(ctx context.Context, track *webrtc.TrackLocalStaticSample) error {
 	defer logger.Log.Info("stopped streaming device to client",
 		slog.String("component", "audio_streamer"))
 
-	buffer := make([]byte, audio.SampleSize)
+	// Derive the frame size and duration from the track's codec instead of assuming G.711 defaults
+	codec := track.Codec()
+	frameSize, frameDuration, err := audio.FrameSize(codec.MimeType, codec.ClockRate, codec.Channels)
+	if err != nil {
+		logger.Log.Error("cannot stream device audio with this codec",
+			slog.String("component", "audio_streamer"),
+			slog.String("codec", codec.MimeType),
+			slog.String("error", err.Error()))
+		return err
+	}
+
+	// Aggregate frames into larger packets when a longer ptime was configured
+	frameSize *= s.frames
+	frameDuration *= time.Duration(s.frames)
+
+	// G.711 frames have the same size in either law, so a track in the other codec only
+	// needs each packet re-encoded
+	trackCodec := s.rtpCodec(codec.MimeType)
+	if trackCodec.Name != s.deviceCodec.Name {
+		logger.Log.Info("converting device audio for the client",
+			slog.String("component", "audio_streamer"),
+			slog.String("device_codec", s.deviceCodec.Name),
+			slog.String("track_codec", trackCodec.Name))
+	}
+
+	logger.Log.Debug("device-to-client framing",
+		slog.String("component", "audio_streamer"),
+		slog.String("codec", codec.MimeType),
+		slog.Int("frame_size", frameSize),
+		slog.Duration("frame_duration", frameDuration))
+
+	buffer := make([]byte, frameSize)
+	converted := make([]byte, frameSize)
+
+	// While held, frames are still read so the device stream and replay buffer stay current
+	held := s.ready
+
+	// playout is when the audio sent so far runs out at the client; a packet read after
+	// that left a gap in the client's stream
+	var playout time.Time
 
 	for {
 		select {
@@ -64,7 +143,7 @@ func (s *HikvisionAudioStreamer) StreamDeviceToClient(ctx context.Context, track
 				slog.String("component", "audio_streamer"))
 			return ctx.Err()
 		default:
-			// Read exactly audio.SampleSize bytes from device
+			// Read exactly one packet's worth of frames from device
 			n, err := io.ReadFull(s.audioReader, buffer)
 			if err != nil {
 				if err != io.EOF && err != io.ErrUnexpectedEOF {
@@ -72,13 +151,37 @@ func (s *HikvisionAudioStreamer) StreamDeviceToClient(ctx context.Context, track
 						slog.String("component", "audio_streamer"),
 						slog.String("error", err.Error()))
 				}
-				return err
+				return fmt.Errorf("%w: %w", ErrDeviceStream, err)
 			}
 
+			s.bytesRecv.Add(int64(n))
+			s.updateInputLevel(buffer[:n])
+
+			if held != nil {
+				select {
+				case <-held:
+					// The replay ends with the frame just read
+					held = nil
+					if err := s.sendReplay(track, trackCodec, frameSize, frameDuration); err != nil {
+						return err
+					}
+				default:
+				}
+				continue
+			}
+
+			if now := time.Now(); playout.Before(now) {
+				if gap := now.Sub(playout); !playout.IsZero() && gap > underrunTolerance {
+					s.recordUnderrun(gap)
+				}
+				playout = now
+			}
+			playout = playout.Add(frameDuration)
+
 			// Send to WebRTC track with precise timing
 			if err := track.WriteSample(media.Sample{
-				Data:     buffer[:n],
-				Duration: audio.SampleDuration,
+				Data:     audio.ConvertG711(converted, buffer[:n], s.deviceCodec, trackCodec),
+				Duration: frameDuration,
 			}); err != nil {
 				logger.Log.Error("error sending audio sample to client",
 					slog.String("component", "audio_streamer"),
@@ -89,11 +192,109 @@ func (s *HikvisionAudioStreamer) StreamDeviceToClient(ctx context.Context, track
 	}
 }
 
+// recordUnderrun counts a gap in the audio sent to the client
+func (s *HikvisionAudioStreamer) recordUnderrun(gap time.Duration) {
+	count := s.underruns.Add(1)
+	s.gapNanos.Add(int64(gap))
+	logger.Log.Debug("device audio underrun",
+		slog.String("component", "audio_streamer"),
+		slog.Duration("gap", gap),
+		slog.Int64("underruns", count))
+}
+
+// sendReplay writes the reader's replay buffer to the track in whole packets. It runs
+// once, before live audio, so the backlog goes out as fast as the track accepts it.
+func (s *HikvisionAudioStreamer) sendReplay(track *webrtc.TrackLocalStaticSample, trackCodec audio.Codec, frameSize int, frameDuration time.Duration) error {
+	backlog := s.audioReader.Replay()
+	backlog = backlog[len(backlog)%frameSize:] // Drop the oldest partial packet
+	backlog = audio.ConvertG711(make([]byte, len(backlog)), backlog, s.deviceCodec, trackCodec)
+
+	if len(backlog) > 0 {
+		logger.Log.Debug("replaying buffered device audio",
+			slog.String("component", "audio_streamer"),
+			slog.Int("bytes", len(backlog)))
+	}
+	for len(backlog) > 0 {
+		if err := track.WriteSample(media.Sample{
+			Data:     backlog[:frameSize],
+			Duration: frameDuration,
+		}); err != nil {
+			logger.Log.Error("error sending replayed audio to client",
+				slog.String("component", "audio_streamer"),
+				slog.String("error", err.Error()))
+			return err
+		}
+		backlog = backlog[frameSize:]
+	}
+	return nil
+}
+
+// rtpCodec returns the codec of an RTP MIME type, or the device's codec for one that
+// isn't G.711 (which FrameSize has already rejected)
+func (s *HikvisionAudioStreamer) rtpCodec(mimeType string) audio.Codec {
+	name := mimeType[strings.IndexByte(mimeType, '/')+1:]
+	if codec, ok := audio.LookupRTPCodec(name); ok {
+		return codec
+	}
+	return s.deviceCodec
+}
+
+// updateInputLevel folds the RMS of a device audio frame into the smoothed input level
+func (s *HikvisionAudioStreamer) updateInputLevel(frame []byte) {
+	const smoothing = 0.2 // Weight of the newest frame (~100ms time constant at 20ms frames)
+
+	current := s.deviceCodec.RMS(frame)
+	previous := math.Float64frombits(s.inputLevel.Load())
+	level := previous + smoothing*(current-previous)
+
+	s.inputLevel.Store(math.Float64bits(level))
+	s.levelAt.Store(time.Now().UnixNano())
+}
+
+// InputLevel returns the smoothed RMS level of the doorbell microphone (0..1) and when it was last updated
+func (s *HikvisionAudioStreamer) InputLevel() (float64, time.Time) {
+	at := s.levelAt.Load()
+	if at == 0 {
+		return 0, time.Time{}
+	}
+	return math.Float64frombits(s.inputLevel.Load()), time.Unix(0, at)
+}
+
+// BytesTransferred returns the audio bytes sent to and received from the device so far
+func (s *HikvisionAudioStreamer) BytesTransferred() (sent, received int64) {
+	return s.bytesSent.Load(), s.bytesRecv.Load()
+}
+
+// Underruns returns how many times the client ran out of device audio and the total
+// silence those gaps left in its stream
+func (s *HikvisionAudioStreamer) Underruns() (count int64, silence time.Duration) {
+	return s.underruns.Load(), time.Duration(s.gapNanos.Load())
+}
+
+// ReaderRestarts returns how many times the device audio stream was reconnected after
+// stalling (hikvision.reader_stall_timeout)
+func (s *HikvisionAudioStreamer) ReaderRestarts() int64 {
+	if s.audioReader == nil {
+		return 0
+	}
+	return s.audioReader.Restarts()
+}
+
 // StreamClientToDevice reads audio from WebRTC client and sends to device
 func (s *HikvisionAudioStreamer) StreamClientToDevice(ctx context.Context, track *webrtc.TrackRemote) error {
 	defer logger.Log.Info("stopped streaming client to device",
 		slog.String("component", "audio_streamer"))
 
+	// Client audio in the other G.711 law is re-encoded for the channel
+	trackCodec := s.rtpCodec(track.Codec().MimeType)
+	if trackCodec.Name != s.deviceCodec.Name {
+		logger.Log.Info("converting client audio for the device",
+			slog.String("component", "audio_streamer"),
+			slog.String("track_codec", trackCodec.Name),
+			slog.String("device_codec", s.deviceCodec.Name))
+	}
+	var converted []byte
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -112,13 +313,17 @@ func (s *HikvisionAudioStreamer) StreamClientToDevice(ctx context.Context, track
 			}
 
 			// Send audio payload to device
-			_, err = s.audioWriter.Write(rtp.Payload)
+			if len(converted) < len(rtp.Payload) {
+				converted = make([]byte, len(rtp.Payload))
+			}
+			n, err := s.audioWriter.Write(audio.ConvertG711(converted, rtp.Payload, trackCodec, s.deviceCodec))
 			if err != nil {
 				logger.Log.Error("error writing audio to device",
 					slog.String("component", "audio_streamer"),
 					slog.String("error", err.Error()))
-				return err
+				return fmt.Errorf("%w: %w", ErrDeviceStream, err)
 			}
+			s.bytesSent.Add(int64(n))
 		}
 	}
 }
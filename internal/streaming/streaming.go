@@ -2,12 +2,18 @@ package streaming
 
 import (
 	"context"
+	"errors"
 	"io"
+	"time"
 
 	"github.com/acardace/hikvision-doorbell-server/internal/session"
 	"github.com/pion/webrtc/v4"
 )
 
+// ErrDeviceStream wraps errors reading from or writing to the device, as opposed to
+// errors on the WebRTC side, so callers can tell when the device connection is lost
+var ErrDeviceStream = errors.New("device audio stream failed")
+
 // AudioStreamer handles bidirectional audio streaming between a device and WebRTC
 // This interface allows for different backend implementations (Hikvision, Dahua, etc.)
 type AudioStreamer interface {
@@ -20,6 +26,20 @@ type AudioStreamer interface {
 	// StreamClientToDevice reads audio from WebRTC client and sends to device
 	StreamClientToDevice(ctx context.Context, track *webrtc.TrackRemote) error
 
+	// InputLevel returns the RMS level (0..1) of the device microphone and when it was last measured
+	InputLevel() (float64, time.Time)
+
+	// BytesTransferred returns the audio bytes sent to and received from the device so far
+	BytesTransferred() (sent, received int64)
+
+	// Underruns returns how many times the client ran out of device audio and the
+	// total silence those gaps left
+	Underruns() (count int64, silence time.Duration)
+
+	// ReaderRestarts returns how many times the device audio stream was reconnected
+	// after stalling
+	ReaderRestarts() int64
+
 	// Stop closes the streaming session
 	Stop() error
 }
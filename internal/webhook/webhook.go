@@ -0,0 +1,174 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/acardace/hikvision-doorbell-server/internal/logger"
+	"github.com/acardace/hikvision-doorbell-server/internal/retry"
+)
+
+// queueSize bounds the events waiting for delivery; beyond it new events are dropped
+const queueSize = 64
+
+// Event types sent to the webhook
+const (
+	EventSessionStarted = "session_started"
+	EventSessionEnded   = "session_ended"
+
+	// WebRTC call state changes, see api.CallState
+	EventCallRinging   = "call_ringing"
+	EventCallConnected = "call_connected"
+	EventCallEnded     = "call_ended"
+)
+
+// Event is the JSON payload POSTed to the webhook
+type Event struct {
+	Event           string    `json:"event"`  // session_started, session_ended or a call_* state change
+	Source          string    `json:"source"` // webrtc, play_file or play_stream
+	SessionID       string    `json:"session_id"`
+	ChannelID       string    `json:"channel_id"`
+	Timestamp       time.Time `json:"timestamp"`
+	DurationSeconds float64   `json:"duration_seconds,omitempty"` // session_ended and call_ended only
+	Success         *bool     `json:"success,omitempty"`          // session_ended and call_ended only
+	ErrorCategory   string    `json:"error_category,omitempty"`
+}
+
+// Notifier delivers events to a webhook URL in the background, in the order they
+// were sent, retrying failed deliveries. A nil Notifier discards events.
+type Notifier struct {
+	url    string
+	client *http.Client
+	retry  retry.Policy
+	events chan Event
+	done   chan struct{}
+
+	mu     sync.RWMutex // Guards closed against Notify racing Close
+	closed bool
+}
+
+// New starts a notifier posting to url. Each attempt is bounded by timeout, and
+// failed attempts (connection errors, 5xx and 429 responses) are retried per policy.
+func New(url string, timeout time.Duration, policy retry.Policy) *Notifier {
+	n := &Notifier{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+		retry:  policy,
+		events: make(chan Event, queueSize),
+		done:   make(chan struct{}),
+	}
+	go n.run()
+	return n
+}
+
+// Notify queues an event for delivery without blocking. Events are dropped (and
+// logged) when the queue is full or the notifier is closed.
+func (n *Notifier) Notify(e Event) {
+	if n == nil {
+		return
+	}
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now().UTC()
+	}
+
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	if n.closed {
+		return
+	}
+	select {
+	case n.events <- e:
+	default:
+		logger.Log.Warn("webhook queue full, dropping event",
+			slog.String("component", "webhook"),
+			slog.String("event", e.Event),
+			slog.String("session_id", e.SessionID))
+	}
+}
+
+// Close stops accepting events and waits for queued ones to be delivered, giving
+// up when ctx ends
+func (n *Notifier) Close(ctx context.Context) error {
+	if n == nil {
+		return nil
+	}
+
+	n.mu.Lock()
+	if !n.closed {
+		n.closed = true
+		close(n.events)
+	}
+	n.mu.Unlock()
+
+	select {
+	case <-n.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("webhook events not delivered: %w", ctx.Err())
+	}
+}
+
+// run delivers queued events one at a time until the queue is closed
+func (n *Notifier) run() {
+	defer close(n.done)
+
+	for e := range n.events {
+		if err := n.deliver(e); err != nil {
+			logger.Log.Error("failed to deliver webhook event",
+				slog.String("component", "webhook"),
+				slog.String("event", e.Event),
+				slog.String("session_id", e.SessionID),
+				slog.String("error", err.Error()))
+		}
+	}
+}
+
+// deliver POSTs one event, retrying per the policy. Client errors other than 429
+// are not retried since the same payload would be rejected again.
+func (n *Notifier) deliver(e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		retryable, err := n.post(body)
+		if err == nil || !retryable || !n.retry.Allows(attempt) {
+			return err
+		}
+		logger.Log.Warn("webhook delivery failed, retrying",
+			slog.String("component", "webhook"),
+			slog.String("event", e.Event),
+			slog.Int("attempt", attempt),
+			slog.String("error", err.Error()))
+		n.retry.Wait(context.Background(), attempt)
+	}
+}
+
+// post sends body once and reports whether a failure is worth retrying
+func (n *Notifier) post(body []byte) (retryable bool, err error) {
+	req, err := http.NewRequest("POST", n.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, err
+}